@@ -0,0 +1,49 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"unolink-client/logging"
+)
+
+// ACTION_TIMEOUT bounds the commands of the rules.
+const ACTION_TIMEOUT = 10 * time.Second
+
+// act runs the command of a rule for one of its alerts, in the background so
+// that a slow command does not hold the other rules back.
+func act(ctx context.Context, r Rule, a Alert) {
+	if r.Command != "" {
+		go func() {
+			if err := run(ctx, r.Command, a); err != nil {
+				logging.Error("alert command failed", "rule", a.Rule, "device", a.Device, "err", err)
+			}
+		}()
+	}
+}
+
+// run runs the command of a rule with the shell, the alert being passed in
+// the UNOLINK_ALERT_* environment variables.
+func run(ctx context.Context, command string, a Alert) error {
+	ctx, cancel := context.WithTimeout(ctx, ACTION_TIMEOUT)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"UNOLINK_ALERT_RULE="+a.Rule,
+		"UNOLINK_ALERT_SEVERITY="+string(a.Severity),
+		"UNOLINK_ALERT_DEVICE="+a.Device,
+		"UNOLINK_ALERT_FIELD="+a.Field,
+		"UNOLINK_ALERT_VALUE="+strconv.FormatFloat(a.Value, 'f', -1, 64),
+		"UNOLINK_ALERT_RAISED="+a.Raised.Format(time.RFC3339),
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+	}
+	return err
+}
@@ -0,0 +1,296 @@
+// Package alerts evaluates the rules of the rules file against the live
+// state of the devices and raises an alert when one holds, logging it,
+// publishing it to the subscribers and running the command of the rule. The
+// webhooks of the rules are posted by package notify.
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	conn "unolink-client/connection"
+	def "unolink-client/definitions"
+	"unolink-client/logging"
+	"unolink-client/units"
+)
+
+const (
+	EVENT_BUFFER_SIZE = 64
+	// TICK is how often the silence rules are checked.
+	TICK = time.Second
+)
+
+// Alert is a rule holding for a device. Value is the metric that raised it,
+// in the selected units, or for a silence rule the seconds since the last
+// packet.
+type Alert struct {
+	Rule     string    `json:"rule"`
+	Severity Severity  `json:"severity"`
+	Device   string    `json:"device"`
+	Field    string    `json:"field,omitempty"`
+	Value    float64   `json:"value"`
+	Raised   time.Time `json:"raised"`
+	Cleared  time.Time `json:"cleared"` // zero while active
+}
+
+// Active tells whether the rule still holds.
+func (a Alert) Active() bool {
+	return a.Cleared.IsZero()
+}
+
+// Update is implemented by everything published to subscribers: Raised and
+// Cleared.
+type Update interface {
+	update()
+}
+
+// Raised reports a rule that started holding for a device.
+type Raised struct {
+	Alert Alert
+	Rule  Rule
+}
+
+func (Raised) update() {}
+
+// Cleared reports a rule that stopped holding for a device.
+type Cleared struct {
+	Alert Alert
+}
+
+func (Cleared) update() {}
+
+var (
+	subscribersMu sync.Mutex
+	subscribers   []chan Update
+)
+
+// Subscribe returns a channel receiving every update from now on. Slow
+// subscribers lose updates rather than blocking the rules.
+func Subscribe() <-chan Update {
+	ch := make(chan Update, EVENT_BUFFER_SIZE)
+	subscribersMu.Lock()
+	subscribers = append(subscribers, ch)
+	subscribersMu.Unlock()
+	return ch
+}
+
+func publish(u Update) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	for _, ch := range subscribers {
+		select {
+		case ch <- u:
+		default:
+			logging.Warn("alert update dropped", "update", fmt.Sprintf("%T", u))
+		}
+	}
+}
+
+// track is the state of a rule for a device: since when its condition holds
+// and the alert raised once it held for long enough.
+type track struct {
+	since time.Time
+	alert *Alert
+}
+
+// Engine evaluates rules against device states. It keeps no clock of its own,
+// every evaluation being given the time it happens at, and is not safe for
+// concurrent use.
+type Engine struct {
+	rules  []Rule
+	tracks map[string][]track // by device, one per rule
+	last   map[string]def.DeviceState
+	live   map[string]time.Time // devices in telemetry, since when
+}
+
+func NewEngine(rules []Rule) *Engine {
+	return &Engine{
+		rules:  rules,
+		tracks: map[string][]track{},
+		last:   map[string]def.DeviceState{},
+		live:   map[string]time.Time{},
+	}
+}
+
+// State evaluates the field rules against a new state of a device, returning
+// the alerts raised and cleared by it.
+func (e *Engine) State(d def.DeviceState, now time.Time) []Update {
+	id := d.Id.String()
+	e.last[id] = d
+	fields := d.Fields()
+	var updates []Update
+	for i, r := range e.rules {
+		if r.Silence() {
+			continue
+		}
+		if r.Live {
+			if _, ok := e.live[id]; !ok {
+				updates = e.evaluate(updates, id, i, false, 0, r.For, now)
+				continue
+			}
+		}
+		v, ok := number(fields[r.Field])
+		if ok {
+			v = units.Convert(r.Field, v)
+		}
+		updates = e.evaluate(updates, id, i, ok && r.holds(v), v, r.For, now)
+	}
+	return updates
+}
+
+// Devices evaluates the field rules against a device list, forgetting the
+// devices no longer listed and clearing their alerts.
+func (e *Engine) Devices(devices []def.DeviceState, now time.Time) []Update {
+	listed := make(map[string]bool, len(devices))
+	var updates []Update
+	for _, d := range devices {
+		listed[d.Id.String()] = true
+		updates = append(updates, e.State(d, now)...)
+	}
+	for id, tracks := range e.tracks {
+		if listed[id] {
+			continue
+		}
+		for _, t := range tracks {
+			if t.alert != nil {
+				t.alert.Cleared = now
+				updates = append(updates, Cleared{*t.alert})
+			}
+		}
+		delete(e.tracks, id)
+		delete(e.last, id)
+	}
+	return updates
+}
+
+// Mapping records the devices in telemetry.
+func (e *Engine) Mapping(mapping map[string]uint8, now time.Time) {
+	for id := range e.live {
+		if _, ok := mapping[id]; !ok {
+			delete(e.live, id)
+		}
+	}
+	for id := range mapping {
+		if _, ok := e.live[id]; !ok {
+			e.live[id] = now
+		}
+	}
+}
+
+// Tick evaluates the silence rules, returning the alerts raised and cleared
+// since the last packets. A device that never sent one is silent since it
+// went live; one neither live nor ever heard of is ignored.
+func (e *Engine) Tick(now time.Time) []Update {
+	var updates []Update
+	for i, r := range e.rules {
+		if !r.Silence() {
+			continue
+		}
+		for id, d := range e.last {
+			from := d.LastSeen
+			live, isLive := e.live[id]
+			if from.IsZero() || live.After(from) {
+				from = live
+			}
+			silent := now.Sub(from)
+			holds := !from.IsZero() && silent >= r.For && (!r.Live || isLive)
+			// the silence already lasted the duration of the rule
+			updates = e.evaluate(updates, id, i, holds, silent.Seconds(), 0, now)
+		}
+	}
+	return updates
+}
+
+// evaluate moves the rule i of a device forward, raising its alert once it
+// held for wait and clearing it when it stops holding.
+func (e *Engine) evaluate(updates []Update, id string, i int, holds bool, v float64, wait time.Duration, now time.Time) []Update {
+	tracks := e.tracks[id]
+	if tracks == nil {
+		tracks = make([]track, len(e.rules))
+		e.tracks[id] = tracks
+	}
+	t := &tracks[i]
+	r := e.rules[i]
+	if !holds {
+		if t.alert != nil {
+			t.alert.Cleared = now
+			updates = append(updates, Cleared{*t.alert})
+		}
+		*t = track{}
+		return updates
+	}
+	if t.since.IsZero() {
+		t.since = now
+	}
+	if t.alert == nil && now.Sub(t.since) >= wait {
+		t.alert = &Alert{Rule: r.Name, Severity: r.Severity, Device: id, Field: r.Field, Value: v, Raised: now}
+		updates = append(updates, Raised{Alert: *t.alert, Rule: r})
+	}
+	return updates
+}
+
+// number converts a numeric field to float64.
+func number(v any) (float64, bool) {
+	r := reflect.ValueOf(v)
+	switch r.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(r.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(r.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return r.Float(), true
+	}
+	return 0, false
+}
+
+// Run evaluates the rules against the updates of the connection until ctx is
+// cancelled.
+func Run(ctx context.Context, rules []Rule) error {
+	updates := conn.Subscribe()
+	e := NewEngine(rules)
+	e.Mapping(def.Mapping(), time.Now())
+	ticker := time.NewTicker(TICK)
+	defer ticker.Stop()
+	logging.Info("alert rules loaded", "rules", len(rules))
+
+	for {
+		var changes []Update
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			changes = e.Tick(now)
+		case u := <-updates:
+			now := time.Now()
+			switch u := u.(type) {
+			case conn.PacketDecoded:
+				changes = e.State(u.Device, now)
+			case conn.DevicesChanged:
+				changes = e.Devices(u.Devices, now)
+			case conn.MappingChanged:
+				e.Mapping(u.Mapping, now)
+			}
+		}
+		for _, u := range changes {
+			notify(ctx, u)
+		}
+	}
+}
+
+// notify logs and publishes an update and runs the command of the rule of a
+// raised alert.
+func notify(ctx context.Context, u Update) {
+	switch u := u.(type) {
+	case Raised:
+		a := u.Alert
+		logging.Warn("alert raised", "rule", a.Rule, "severity", a.Severity, "device", a.Device, "value", a.Value)
+		act(ctx, u.Rule, a)
+	case Cleared:
+		a := u.Alert
+		logging.Info("alert cleared", "rule", a.Rule, "device", a.Device, "after", a.Cleared.Sub(a.Raised).Round(time.Second))
+	}
+	publish(u)
+}
@@ -0,0 +1,222 @@
+package alerts
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"unolink-client/config"
+	def "unolink-client/definitions"
+)
+
+// ID is the device of the synthetic states.
+const ID = "0A0B0C"
+
+var start = time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+// step feeds the engine at a time since start: a device state, a telemetry
+// mapping, a device list or a tick.
+type step struct {
+	at      time.Duration
+	state   *def.DeviceState
+	mapping map[string]uint8
+	list    []def.DeviceState
+	listed  bool // list is fed, even when empty
+	tick    bool
+	want    []string // "raised <rule>" or "cleared <rule>"
+}
+
+// state is the device with a heart rate, heard from at a time since start or
+// never when seen is negative.
+func state(hrm uint8, seen time.Duration) *def.DeviceState {
+	d := &def.DeviceState{Id: def.RadioAddress{0x0A, 0x0B, 0x0C}, Hrm: hrm, Battery: def.BATTERY_UNKNOWN}
+	if seen >= 0 {
+		d.LastSeen = start.Add(seen)
+	}
+	return d
+}
+
+func describe(updates []Update) []string {
+	var got []string
+	for _, u := range updates {
+		switch u := u.(type) {
+		case Raised:
+			got = append(got, "raised "+u.Alert.Rule)
+		case Cleared:
+			got = append(got, "cleared "+u.Alert.Rule)
+		}
+	}
+	return got
+}
+
+func TestEngine(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+		steps []step
+	}{
+		{
+			name:  "raised at once",
+			rules: []string{"hrm > 180"},
+			steps: []step{
+				{at: 0, state: state(175, 0)},
+				{at: time.Second, state: state(185, time.Second), want: []string{"raised hrm > 180"}},
+				{at: 2 * time.Second, state: state(190, 2*time.Second)},
+			},
+		},
+		{
+			name:  "raised after for",
+			rules: []string{"hrm > 180 for 5s"},
+			steps: []step{
+				{at: 0, state: state(185, 0)},
+				{at: 3 * time.Second, state: state(190, 3*time.Second)},
+				{at: 5 * time.Second, state: state(186, 5*time.Second), want: []string{"raised hrm > 180 for 5s"}},
+				{at: 6 * time.Second, state: state(186, 6*time.Second)},
+			},
+		},
+		{
+			name:  "interrupted before for",
+			rules: []string{"hrm > 180 for 5s"},
+			steps: []step{
+				{at: 0, state: state(185, 0)},
+				{at: 3 * time.Second, state: state(170, 3*time.Second)},
+				{at: 6 * time.Second, state: state(185, 6*time.Second)},
+				{at: 10 * time.Second, state: state(185, 10*time.Second)},
+				{at: 11 * time.Second, state: state(185, 11*time.Second), want: []string{"raised hrm > 180 for 5s"}},
+			},
+		},
+		{
+			name:  "cleared and raised again",
+			rules: []string{"hrm >= 180"},
+			steps: []step{
+				{at: 0, state: state(180, 0), want: []string{"raised hrm >= 180"}},
+				{at: time.Second, state: state(179, time.Second), want: []string{"cleared hrm >= 180"}},
+				{at: 2 * time.Second, state: state(170, 2*time.Second)},
+				{at: 3 * time.Second, state: state(181, 3*time.Second), want: []string{"raised hrm >= 180"}},
+			},
+		},
+		{
+			name:  "field rule while live",
+			rules: []string{"hrm > 180 while live"},
+			steps: []step{
+				{at: 0, state: state(185, 0)},
+				{at: time.Second, mapping: map[string]uint8{ID: 1}},
+				{at: 2 * time.Second, state: state(185, 2*time.Second), want: []string{"raised hrm > 180 while live"}},
+				{at: 3 * time.Second, mapping: map[string]uint8{}},
+				{at: 4 * time.Second, state: state(185, 4*time.Second), want: []string{"cleared hrm > 180 while live"}},
+			},
+		},
+		{
+			name:  "offline",
+			rules: []string{"no packets for 5s"},
+			steps: []step{
+				{at: 0, state: state(150, 0)},
+				{at: 4 * time.Second, tick: true},
+				{at: 5 * time.Second, tick: true, want: []string{"raised no packets for 5s"}},
+				{at: 6 * time.Second, tick: true},
+				{at: 7 * time.Second, state: state(150, 7*time.Second)},
+				{at: 8 * time.Second, tick: true, want: []string{"cleared no packets for 5s"}},
+			},
+		},
+		{
+			name:  "offline while live",
+			rules: []string{"no packets for 5s while live"},
+			steps: []step{
+				{at: 0, list: []def.DeviceState{*state(0, -1)}, listed: true},
+				{at: 10 * time.Second, tick: true},
+				{at: 20 * time.Second, mapping: map[string]uint8{ID: 1}},
+				{at: 24 * time.Second, tick: true},
+				{at: 25 * time.Second, tick: true, want: []string{"raised no packets for 5s while live"}},
+				{at: 26 * time.Second, mapping: map[string]uint8{}},
+				{at: 27 * time.Second, tick: true, want: []string{"cleared no packets for 5s while live"}},
+			},
+		},
+		{
+			name:  "offline since the last packet rather than since live",
+			rules: []string{"no packets for 5s while live"},
+			steps: []step{
+				{at: 0, mapping: map[string]uint8{ID: 1}},
+				{at: 3 * time.Second, state: state(150, 3*time.Second)},
+				{at: 7 * time.Second, tick: true},
+				{at: 8 * time.Second, tick: true, want: []string{"raised no packets for 5s while live"}},
+			},
+		},
+		{
+			name:  "device removed",
+			rules: []string{"hrm > 180", "no packets for 5s"},
+			steps: []step{
+				{at: 0, list: []def.DeviceState{*state(185, 0)}, listed: true, want: []string{"raised hrm > 180"}},
+				{at: time.Second, list: []def.DeviceState{}, listed: true, want: []string{"cleared hrm > 180"}},
+				{at: 10 * time.Second, tick: true},
+			},
+		},
+	}
+
+	config.Current = config.Default()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := make([]Rule, len(tt.rules))
+			for i, s := range tt.rules {
+				r, err := ParseCondition(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r.Name = s
+				rules[i] = r
+			}
+			e := NewEngine(rules)
+			for _, s := range tt.steps {
+				now := start.Add(s.at)
+				var updates []Update
+				switch {
+				case s.state != nil:
+					updates = e.State(*s.state, now)
+				case s.mapping != nil:
+					e.Mapping(s.mapping, now)
+				case s.listed:
+					updates = e.Devices(s.list, now)
+				case s.tick:
+					updates = e.Tick(now)
+				}
+				if got := describe(updates); !reflect.DeepEqual(got, s.want) {
+					t.Fatalf("at %s: %v, want %v", s.at, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		condition string
+		want      Rule
+		err       bool
+	}{
+		{condition: "hrm > 190", want: Rule{Field: "hrm", Op: ">", Threshold: 190}},
+		{condition: "speed >= 7.5 for 2s", want: Rule{Field: "speed", Op: ">=", Threshold: 7.5, For: 2 * time.Second}},
+		{condition: "battery < 15 while live", want: Rule{Field: "battery", Op: "<", Threshold: 15, Live: true}},
+		{condition: "no packets for 5s while live", want: Rule{For: 5 * time.Second, Live: true}},
+		{condition: "no packets", err: true},
+		{condition: "hrm > fast", err: true},
+		{condition: "hrm => 190", err: true},
+		{condition: "firmware == 1", err: true},
+		{condition: "hrm > 190 for -1s", err: true},
+		{condition: "hrm", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCondition(tt.condition)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: no error", tt.condition)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.condition, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: %+v, want %+v", tt.condition, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package alerts
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	def "unolink-client/definitions"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Severity is how urgent the alerts of a rule are.
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// Rule raises an alert for a device when its condition has held for For.
+// A field rule compares a metric of the device to Threshold, expressed in
+// the selected units; a silence rule holds while no packet of the device
+// has been received, only counting the devices in telemetry when Live is
+// set. Webhook and Command are the actions triggered by its alerts.
+type Rule struct {
+	Name      string
+	Severity  Severity
+	Field     string // empty for a silence rule
+	Op        string
+	Threshold float64
+	For       time.Duration
+	Live      bool
+	Webhook   string
+	Command   string
+}
+
+// Silence tells whether the rule is about packets not being received.
+func (r Rule) Silence() bool {
+	return r.Field == ""
+}
+
+// String returns the condition of the rule as written in the rules file.
+func (r Rule) String() string {
+	var s string
+	if r.Silence() {
+		s = "no packets"
+	} else {
+		s = fmt.Sprintf("%s %s %s", r.Field, r.Op, strconv.FormatFloat(r.Threshold, 'f', -1, 64))
+	}
+	if r.For > 0 {
+		s += " for " + r.For.String()
+	}
+	if r.Live {
+		s += " while live"
+	}
+	return s
+}
+
+// holds tells whether a metric value satisfies the condition of a field
+// rule.
+func (r Rule) holds(v float64) bool {
+	switch r.Op {
+	case ">":
+		return v > r.Threshold
+	case ">=":
+		return v >= r.Threshold
+	case "<":
+		return v < r.Threshold
+	case "<=":
+		return v <= r.Threshold
+	case "==":
+		return v == r.Threshold
+	case "!=":
+		return v != r.Threshold
+	}
+	return false
+}
+
+var OPERATORS = []string{">", ">=", "<", "<=", "==", "!="}
+
+// ruleFile is a rule as written in the rules file.
+type ruleFile struct {
+	Name     string   `yaml:"name"`
+	When     string   `yaml:"when"`
+	Severity Severity `yaml:"severity"`
+	Webhook  string   `yaml:"webhook"`
+	Command  string   `yaml:"command"`
+}
+
+// LoadRules reads the rules file, a list of rules such as:
+//
+//	# rules.yaml
+//	- name: heart rate
+//	  when: hrm > 190 for 10s
+//	  severity: critical
+//	- when: battery < 15
+//	- name: dropout
+//	  when: no packets for 5s while live
+//	  webhook: https://example.com/hook
+//	  command: notify-send "$UNOLINK_ALERT_DEVICE lost"
+//
+// The name defaults to the condition and the severity to warning.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []ruleFile
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	rules := make([]Rule, 0, len(entries))
+	for i, e := range entries {
+		r, err := ParseCondition(e.When)
+		if err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %w", path, i+1, err)
+		}
+		r.Name = e.Name
+		if r.Name == "" {
+			r.Name = e.When
+		}
+		switch e.Severity {
+		case "":
+			r.Severity = SeverityWarning
+		case SeverityInfo, SeverityWarning, SeverityCritical:
+			r.Severity = e.Severity
+		default:
+			return nil, fmt.Errorf("%s: rule %d: invalid severity %q, expected info, warning or critical", path, i+1, e.Severity)
+		}
+		r.Webhook = e.Webhook
+		r.Command = e.Command
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// ParseCondition parses the condition of a rule, either
+// "<field> <op> <threshold> [for <duration>] [while live]" or
+// "no packets for <duration> [while live]".
+func ParseCondition(s string) (Rule, error) {
+	words := strings.Fields(s)
+	var r Rule
+	if n := len(words); n >= 2 && words[n-2] == "while" && words[n-1] == "live" {
+		r.Live = true
+		words = words[:n-2]
+	}
+	if n := len(words); n >= 2 && words[n-2] == "for" {
+		d, err := time.ParseDuration(words[n-1])
+		if err != nil || d < 0 {
+			return Rule{}, fmt.Errorf("invalid duration %q in %q", words[n-1], s)
+		}
+		r.For = d
+		words = words[:n-2]
+	}
+
+	if len(words) == 2 && words[0] == "no" && words[1] == "packets" {
+		if r.For == 0 {
+			return Rule{}, fmt.Errorf("missing duration in %q", s)
+		}
+		return r, nil
+	}
+	if len(words) != 3 {
+		return Rule{}, fmt.Errorf("invalid condition %q, expected \"<field> <op> <value> [for <duration>] [while live]\" or \"no packets for <duration> [while live]\"", s)
+	}
+	if !numeric(words[0]) {
+		return Rule{}, fmt.Errorf("invalid field %q in %q", words[0], s)
+	}
+	if !slices.Contains(OPERATORS, words[1]) {
+		return Rule{}, fmt.Errorf("invalid operator %q in %q, expected one of %s", words[1], s, strings.Join(OPERATORS, " "))
+	}
+	threshold, err := strconv.ParseFloat(words[2], 64)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid value %q in %q", words[2], s)
+	}
+	r.Field, r.Op, r.Threshold = words[0], words[1], threshold
+	return r, nil
+}
+
+// numeric tells whether a device field is a number rules can compare.
+func numeric(field string) bool {
+	switch field {
+	case "firmware", "last_seen", "cum_distance", "tag_id":
+		return false
+	}
+	return slices.Contains(def.FIELDS, field)
+}
@@ -0,0 +1,232 @@
+// Package battery follows the battery of every device over time, estimates
+// how long it will last from its discharge rate and flags the devices
+// running low.
+package battery
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"unolink-client/config"
+	conn "unolink-client/connection"
+	def "unolink-client/definitions"
+	"unolink-client/logging"
+)
+
+const (
+	// HISTORY_LENGTH is how far back the levels of a device are kept.
+	HISTORY_LENGTH = 12 * time.Hour
+	// RATE_WINDOW is how far back the discharge rate is estimated from, and
+	// MIN_RATE_SPAN the shortest history it is estimated from.
+	RATE_WINDOW   = time.Hour
+	MIN_RATE_SPAN = 10 * time.Minute
+)
+
+// Status is how worrying the battery of a device is.
+type Status int
+
+const (
+	StatusUnknown Status = iota
+	StatusOK
+	StatusWarning
+	StatusCritical
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusWarning:
+		return "warning"
+	case StatusCritical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// StatusOf returns the status of a battery level against the thresholds of
+// the configuration.
+func StatusOf(level uint8) Status {
+	cfg := config.Current.Battery
+	switch {
+	case level == def.BATTERY_UNKNOWN:
+		return StatusUnknown
+	case level <= cfg.Critical:
+		return StatusCritical
+	case level <= cfg.Warning:
+		return StatusWarning
+	}
+	return StatusOK
+}
+
+// Sample is a battery level reported at a time, in percent.
+type Sample struct {
+	At    time.Time `json:"at"`
+	Level uint8     `json:"level"`
+}
+
+// Device is the battery of a device. Rate is its discharge rate in percent
+// per hour, 0 until enough history is known; Remaining is how long it should
+// last, estimated from Rate or else from the configured runtime.
+type Device struct {
+	ID        string        `json:"id"`
+	Level     uint8         `json:"level"`
+	Status    Status        `json:"status"`
+	History   []Sample      `json:"history"`
+	Rate      float64       `json:"rate"`
+	Remaining time.Duration `json:"remaining_ns"`
+}
+
+var (
+	mu        sync.Mutex
+	histories = map[string][]Sample{}
+)
+
+// Run records the battery levels of the device lists until ctx is
+// cancelled, and logs the devices entering the warning and critical
+// levels.
+func Run(ctx context.Context) error {
+	updates := conn.Subscribe()
+	for _, d := range def.Snapshot() {
+		record(d.Id.String(), d.Battery, time.Now())
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case u := <-updates:
+			if u, ok := u.(conn.DevicesChanged); ok {
+				now := time.Now()
+				for _, d := range u.Devices {
+					record(d.Id.String(), d.Battery, now)
+				}
+			}
+		}
+	}
+}
+
+// record adds a level to the history of a device when it changed. A level
+// going up means the battery was charged, which starts a new history.
+func record(id string, level uint8, at time.Time) {
+	if level == def.BATTERY_UNKNOWN {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	h := histories[id]
+	var prev uint8 = def.BATTERY_UNKNOWN
+	if len(h) > 0 {
+		prev = h[len(h)-1].Level
+		if prev == level {
+			return
+		}
+		if level > prev {
+			h = nil
+		}
+	}
+	h = append(h, Sample{At: at, Level: level})
+	for len(h) > 1 && at.Sub(h[0].At) > HISTORY_LENGTH {
+		h = h[1:]
+	}
+	histories[id] = h
+
+	if status := StatusOf(level); status > StatusOK && (prev == def.BATTERY_UNKNOWN || StatusOf(prev) < status) {
+		logging.Warn("battery low", "device", id, "level", level, "status", status)
+	}
+}
+
+// Lookup returns the battery of a device, if its level is known.
+func Lookup(id string) (Device, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	h, ok := histories[id]
+	if !ok {
+		return Device{}, false
+	}
+	return device(id, h, time.Now()), true
+}
+
+// Snapshot returns the battery of every device with a known level, by ID.
+func Snapshot() []Device {
+	mu.Lock()
+	defer mu.Unlock()
+	now := time.Now()
+	devices := make([]Device, 0, len(histories))
+	for id, h := range histories {
+		devices = append(devices, device(id, h, now))
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices
+}
+
+func device(id string, h []Sample, now time.Time) Device {
+	last := h[len(h)-1]
+	d := Device{
+		ID:      id,
+		Level:   last.Level,
+		Status:  StatusOf(last.Level),
+		History: append([]Sample(nil), h...),
+		Rate:    rate(h, now),
+	}
+	if d.Rate > 0 {
+		d.Remaining = time.Duration(float64(last.Level) / d.Rate * float64(time.Hour))
+	} else {
+		d.Remaining = time.Duration(float64(last.Level) / 100 * float64(config.Current.Battery.Runtime))
+	}
+	return d
+}
+
+// rate is the discharge rate of a history in percent per hour, fitted by
+// least squares over the samples of the last RATE_WINDOW and the level
+// holding when it started. The current level is taken as still holding now,
+// so that a battery that stopped dropping is not estimated to drop as fast
+// as before.
+func rate(h []Sample, now time.Time) float64 {
+	first := len(h) - 1
+	for first > 0 && now.Sub(h[first].At) <= RATE_WINDOW {
+		first--
+	}
+	samples := append([]Sample(nil), h[first:]...)
+	if samples[len(samples)-1].At.Before(now) {
+		samples = append(samples, Sample{At: now, Level: h[len(h)-1].Level})
+	}
+	if len(samples) < 2 || samples[len(samples)-1].At.Sub(samples[0].At) < MIN_RATE_SPAN {
+		return 0
+	}
+
+	var sx, sy, sxx, sxy float64
+	for _, s := range samples {
+		x := s.At.Sub(samples[0].At).Hours()
+		y := float64(s.Level)
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	n := float64(len(samples))
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return 0
+	}
+	slope := (n*sxy - sx*sy) / den
+	return max(-slope, 0)
+}
+
+// KitCheck returns the devices whose battery is not expected to last a
+// session of the given length, the shortest first.
+func KitCheck(devices []Device, session time.Duration) []Device {
+	var short []Device
+	for _, d := range devices {
+		if d.Remaining < session {
+			short = append(short, d)
+		}
+	}
+	sort.SliceStable(short, func(i, j int) bool { return short[i].Remaining < short[j].Remaining })
+	return short
+}
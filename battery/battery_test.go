@@ -0,0 +1,63 @@
+package battery
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"unolink-client/config"
+	def "unolink-client/definitions"
+)
+
+var now = time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+// ago is a level reported some time before now.
+func ago(d time.Duration, level uint8) Sample {
+	return Sample{At: now.Add(-d), Level: level}
+}
+
+func TestRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []Sample
+		want    float64
+	}{
+		{name: "single level", history: []Sample{ago(time.Hour, 80)}, want: 0},
+		{name: "too short", history: []Sample{ago(5*time.Minute, 80), ago(0, 79)}, want: 0},
+		{name: "steady", history: []Sample{ago(time.Hour, 80), ago(30*time.Minute, 75), ago(0, 70)}, want: 10},
+		// the level holding now flattens the fit
+		{name: "stopped dropping", history: []Sample{ago(time.Hour, 80), ago(30*time.Minute, 70)}, want: 10},
+		// the level at -90m held when the window started, the one at -4h is left out
+		{name: "window", history: []Sample{ago(4*time.Hour, 100), ago(90*time.Minute, 96), ago(30*time.Minute, 90), ago(0, 87)}, want: 6},
+	}
+	for _, tt := range tests {
+		if got := rate(tt.history, now); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	config.Current = config.Default()
+	histories = map[string][]Sample{}
+
+	steps := []struct {
+		at    time.Duration
+		level uint8
+	}{
+		{0, 80},
+		{10 * time.Minute, 80}, // unchanged
+		{20 * time.Minute, def.BATTERY_UNKNOWN},
+		{30 * time.Minute, 70},
+		{time.Hour, 95}, // charged
+		{2 * time.Hour, 90},
+	}
+	for _, s := range steps {
+		record("0A0B0C", s.level, now.Add(s.at))
+	}
+	want := []Sample{{At: now.Add(time.Hour), Level: 95}, {At: now.Add(2 * time.Hour), Level: 90}}
+	if got := histories["0A0B0C"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("history %+v, want %+v", got, want)
+	}
+}
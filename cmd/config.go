@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+
+	"unolink-client/config"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the client configuration",
+	}
+
+	configShowCmd = &cobra.Command{
+		Use:   "show",
+		Short: "Print the effective configuration",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out, err := config.Current.YAML()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("# %s\n%s", configPath, out)
+			return nil
+		},
+	}
+)
+
+func init() {
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"unolink-client/config"
+	"unolink-client/inventory"
+
+	"github.com/spf13/cobra"
+)
+
+var inventoryCmd = &cobra.Command{
+	Use:   "inventory",
+	Short: "List every device ever seen, grouped by firmware",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := config.Current.Inventory.File
+		if path == "" {
+			return errors.New("no inventory file configured, set inventory.file or --inventory")
+		}
+		list, err := inventory.Load(path)
+		if err != nil {
+			return err
+		}
+		inventory.Sort(list)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FIRMWARE\tDEVICES\tSTATUS")
+		for _, g := range inventory.Groups(list) {
+			status := "ok"
+			if g.Outdated {
+				status = "outdated"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\n", orDash(g.Firmware), g.Devices, status)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "DEVICE\tFIRMWARE\tBASE\tFIRST SEEN\tLAST SEEN\tBATTERY\tHEALTH")
+		for _, e := range list {
+			health := "-"
+			if h := e.Health(); h > 0 {
+				health = fmt.Sprintf("%.0f%%", h*100)
+			}
+			firmware := orDash(e.Firmware)
+			if e.Outdated() {
+				firmware += " (outdated)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d%%\t%s\n", strings.ToUpper(e.ID), firmware, orDash(e.Base),
+				e.FirstSeen.Format(SESSION_TIME_FORMAT), e.LastSeen.Format(SESSION_TIME_FORMAT), e.Battery, health)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(inventoryCmd)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -4,79 +4,271 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"sync"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"unolink-client/alerts"
+	"unolink-client/battery"
+	"unolink-client/config"
 	"unolink-client/connection"
+	"unolink-client/csvexport"
 	"unolink-client/display"
+	"unolink-client/inventory"
+	"unolink-client/logging"
+	"unolink-client/metrics"
+	"unolink-client/mqtt"
+	"unolink-client/notify"
+	"unolink-client/server"
+	"unolink-client/storage"
+	"unolink-client/training"
+	"unolink-client/units"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
+const SHUTDOWN_TIMEOUT = 3 * time.Second
+
 var (
 	// Used for flags.
-	ulAddress  string
-	streamPort uint16
-	restPort   uint16
+	configPath string
+	profile    string
 
 	rootCmd = &cobra.Command{
-		Use:   "unolink-client",
-		Short: "Fancy terminal client for the Unolink",
-		Run: func(cmd *cobra.Command, args []string) {
-			// fmt.Println("Starting the client...")
-			run(ulAddress, streamPort, restPort)
+		Use:           "unolink-client",
+		Short:         "Fancy terminal client for the Unolink",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return loadConfig(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := selectProfile(cmd); err != nil {
+				return err
+			}
+			return start(true)
 		},
 	}
 )
 
 func init() {
-	rootCmd.PersistentFlags().StringVarP(&ulAddress, "host", "H", "127.0.0.1", "unolink ip address")
-	rootCmd.PersistentFlags().Uint16VarP(&restPort, "rest-port", "r", 2280, "port of REST API")
-	rootCmd.PersistentFlags().Uint16VarP(&streamPort, "stream-port", "s", 2281, "port of stream TCP connection")
+	cfg := &config.Current
+	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", config.DefaultPath(), "configuration file")
+	rootCmd.PersistentFlags().StringVarP(&profile, "profile", "p", "", "use the connection settings of a named profile")
+	rootCmd.PersistentFlags().StringSliceVar(&cfg.Stations, "station", cfg.Stations, "connect to several profiles at once (repeatable)")
+	rootCmd.PersistentFlags().StringVarP(&cfg.Host, "host", "H", cfg.Host, "unolink ip address (default "+config.DEFAULT_HOST+")")
+	rootCmd.PersistentFlags().Uint16VarP(&cfg.RestPort, "rest-port", "r", cfg.RestPort, "port of REST API")
+	rootCmd.PersistentFlags().Uint16VarP(&cfg.StreamPort, "stream-port", "s", cfg.StreamPort, "port of stream TCP connection")
+	rootCmd.PersistentFlags().DurationVar(&cfg.Intervals.ListRefresh, "list-refresh", cfg.Intervals.ListRefresh, "interval between device list requests")
+	rootCmd.PersistentFlags().DurationVar(&cfg.Intervals.MappingRefresh, "mapping-refresh", cfg.Intervals.MappingRefresh, "interval between telemetry mapping requests")
+	rootCmd.PersistentFlags().DurationVar(&cfg.Intervals.MaxRefresh, "max-refresh", cfg.Intervals.MaxRefresh, "slowest polling interval when nothing changes")
+	rootCmd.PersistentFlags().DurationVar(&cfg.Intervals.FastRefresh, "fast-refresh", cfg.Intervals.FastRefresh, "polling interval right after a command")
+	rootCmd.PersistentFlags().DurationVar(&cfg.Intervals.StreamTimeout, "stream-timeout", cfg.Intervals.StreamTimeout, "read timeout of the stream connection")
+	rootCmd.PersistentFlags().DurationVar(&cfg.Intervals.UIRefresh, "ui-refresh", cfg.Intervals.UIRefresh, "window of the packet counters")
+	rootCmd.PersistentFlags().DurationVar(&cfg.Intervals.Render, "render-interval", cfg.Intervals.Render, "fastest table refresh")
+	rootCmd.PersistentFlags().StringVar(&cfg.PushPath, "push-path", cfg.PushPath, "server-sent events endpoint used instead of polling when available, e.g. /events")
+	rootCmd.PersistentFlags().DurationVar(&cfg.Commands.Timeout, "command-timeout", cfg.Commands.Timeout, "time a command has to show its effect")
+	rootCmd.PersistentFlags().IntVar(&cfg.Commands.Retries, "command-retries", cfg.Commands.Retries, "automatic retries of a command that had no effect")
+	rootCmd.PersistentFlags().StringVar(&cfg.UI.Content, "content", cfg.UI.Content, "initial table content: counters, states or session")
+	rootCmd.PersistentFlags().BoolVar(&cfg.UI.ShowHelp, "show-help", cfg.UI.ShowHelp, "show the full help at startup")
+	rootCmd.PersistentFlags().StringVar(&cfg.Roster, "roster", cfg.Roster, "roster file")
+	rootCmd.PersistentFlags().StringVar(&cfg.Alerts.Rules, "rules", cfg.Alerts.Rules, "alert rules file, empty to disable")
+	rootCmd.PersistentFlags().StringVar(&cfg.Database, "database", cfg.Database, "SQLite file the sessions are stored in, empty to disable")
+	rootCmd.PersistentFlags().Uint8Var(&cfg.Battery.Warning, "battery-warning", cfg.Battery.Warning, "battery percentage under which a device is flagged")
+	rootCmd.PersistentFlags().Uint8Var(&cfg.Battery.Critical, "battery-critical", cfg.Battery.Critical, "battery percentage under which a device is critical")
+	rootCmd.PersistentFlags().DurationVar(&cfg.Battery.Session, "session-length", cfg.Battery.Session, "planned session length the kit check is made for")
+	rootCmd.PersistentFlags().StringVar(&cfg.Inventory.File, "inventory", cfg.Inventory.File, "file recording every device ever seen, empty to disable")
+	rootCmd.PersistentFlags().StringVar(&cfg.Inventory.MinFirmware, "min-firmware", cfg.Inventory.MinFirmware, "firmware version under which a device is outdated")
+	rootCmd.PersistentFlags().StringVar(&cfg.Units.Speed, "speed-unit", cfg.Units.Speed, "unit of the speeds shown and exported: m/s, km/h, mph or kn")
+	rootCmd.PersistentFlags().StringVar(&cfg.Units.Distance, "distance-unit", cfg.Units.Distance, "unit of the distances shown and exported: m, km or yd")
+	rootCmd.PersistentFlags().StringVar(&cfg.Units.Energy, "energy-unit", cfg.Units.Energy, "unit of the energy shown and exported: kcal or kJ")
+	rootCmd.PersistentFlags().StringVar(&cfg.Log.File, "log-file", cfg.Log.File, "write logs to this file (rotated automatically)")
+	rootCmd.PersistentFlags().StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "log format: logfmt or json")
+	rootCmd.PersistentFlags().StringVar(&cfg.Export.CSVDir, "csv", cfg.Export.CSVDir, "write every decoded packet to CSV files in a session directory under this one")
+	rootCmd.PersistentFlags().StringVar(&cfg.Export.CSVLayout, "csv-layout", cfg.Export.CSVLayout, "CSV files: one per device or one per packet type")
+	rootCmd.PersistentFlags().StringVar(&cfg.Export.ServeAddr, "serve-addr", cfg.Export.ServeAddr, "re-publish devices over REST and WebSocket on this address, e.g. :2290")
+	rootCmd.PersistentFlags().Float64Var(&cfg.Export.ServeRate, "serve-rate", cfg.Export.ServeRate, "most updates per second and device sent to a WebSocket client")
+	rootCmd.PersistentFlags().StringVar(&cfg.Export.MQTTBroker, "mqtt-broker", cfg.Export.MQTTBroker, "publish devices to this MQTT broker, e.g. tcp://localhost:1883")
+	rootCmd.PersistentFlags().StringVar(&cfg.Export.MQTTTopic, "mqtt-topic", cfg.Export.MQTTTopic, "prefix of the MQTT topics")
+	rootCmd.PersistentFlags().Uint8Var(&cfg.Export.MQTTQoS, "mqtt-qos", cfg.Export.MQTTQoS, "QoS of the MQTT messages: 0, 1 or 2")
+	rootCmd.PersistentFlags().BoolVar(&cfg.Export.MQTTRetain, "mqtt-retain", cfg.Export.MQTTRetain, "retain the last value of every MQTT topic")
+	rootCmd.PersistentFlags().StringVar(&cfg.Export.MetricsAddr, "metrics-addr", cfg.Export.MetricsAddr, "serve Prometheus metrics on this address, e.g. :9120")
+}
+
+// loadConfig builds the effective configuration. Precedence is
+// flags > --profile > environment > profile of the file or UNOLINK_PROFILE >
+// configuration file > defaults.
+func loadConfig(cmd *cobra.Command) error {
+	changed := map[string]string{}
+	slices := map[string][]string{}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			slices[f.Name] = append([]string(nil), sv.GetSlice()...)
+		} else {
+			changed[f.Name] = f.Value.String()
+		}
+	})
+
+	_, explicit := changed["config"]
+	if err := config.Load(configPath, explicit, profile); err != nil {
+		return err
+	}
+
+	for name, value := range changed {
+		if name == "config" || name == "profile" {
+			continue
+		}
+		if err := cmd.Flags().Set(name, value); err != nil {
+			return err
+		}
+	}
+	for name, values := range slices {
+		if err := cmd.Flags().Lookup(name).Value.(pflag.SliceValue).Replace(values); err != nil {
+			return err
+		}
+	}
+	return units.Select(config.Current.Units)
+}
+
+// selectProfile makes sure there is an address to connect to. When none was
+// given the user picks one of the configured profiles, starting from the one
+// used last time.
+func selectProfile(cmd *cobra.Command) error {
+	if config.Current.Host == "" && len(config.Current.Stations) == 0 {
+		names := config.ProfileNames()
+		if len(names) == 0 {
+			config.Current.Host = config.DEFAULT_HOST
+			return nil
+		}
+		name, err := display.PickProfile(names, config.LastProfile())
+		if err != nil {
+			return err
+		}
+		profile = name
+		if err := loadConfig(cmd); err != nil {
+			return err
+		}
+		if config.Current.Host == "" {
+			config.Current.Host = config.DEFAULT_HOST
+		}
+	}
+
+	if config.Current.Profile != "" {
+		if err := config.SaveLastProfile(config.Current.Profile); err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot remember the profile:", err)
+		}
+	}
+	return nil
 }
 
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
 }
 
-func run(address string, streamPort, restPort uint16) {
-	ctx, cancel := context.WithCancel(context.Background())
-	quitCh := make(chan struct{})
-	defer cancel()
-
-	var wg sync.WaitGroup
-	errCh := make(chan error)
-
-	wg.Add(2)
-	go connection.Handle(ctx, &wg, errCh, quitCh, address, streamPort, restPort)
-	go display.RenderTable(ctx, &wg, errCh, quitCh)
-
-	go func() {
-		for {
-			select {
-			case <-errCh:
-				return
-			case <-quitCh:
-				fmt.Println("Terminating...")
-				return
-			case <-ctx.Done():
-				fmt.Println("Terminating...")
-				return
-			default:
-				time.Sleep(100 * time.Microsecond)
-			}
+// start connects to the configured base stations and runs until the user
+// quits or a subsystem fails. Without the display the client only feeds its
+// exporters and servers.
+func start(withDisplay bool) error {
+	cfg := config.Current
+	stations, err := config.StationList()
+	if err != nil {
+		return err
+	}
+	if err := logging.Init(cfg.Log.File, cfg.Log.Level, cfg.Log.Format); err != nil {
+		return err
+	}
+	defer logging.Close()
+	for _, st := range stations {
+		logging.Info("client started", "base", st.Name, "host", st.Host, "rest_port", st.RestPort, "stream_port", st.StreamPort)
+	}
+	err = run(stations, withDisplay)
+	logging.Info("client stopped", "err", err)
+	return err
+}
+
+func run(stations []config.Station, withDisplay bool) error {
+	roster, err := config.LoadRoster(config.Current.Roster)
+	if err != nil {
+		return err
+	}
+	var rules []alerts.Rule
+	if path := config.Current.Alerts.Rules; path != "" {
+		if rules, err = alerts.LoadRules(path); err != nil {
+			return err
 		}
-	}()
-
-	// Wait for an error or context cancellation
-	// select {
-	// case <-errCh:
-	// 	// cancel() // Cancel the context to signal termination
-	// 	ctx.Done()
-	// case <-quitCh:
-	// 	cancel()
-	// }
-	wg.Wait()
+	}
+	hooks, err := notify.Webhooks(config.Current.Notify)
+	if err != nil {
+		return err
+	}
+	ruleHooks := notify.RuleWebhooks(rules, config.Current.Notify)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s := newSupervisor(ctx)
+	if withDisplay {
+		s.Go("display", display.Run)
+	}
+	s.Go("connection", func(ctx context.Context) error {
+		return connection.Run(ctx, stations)
+	})
+	s.Go("training", func(ctx context.Context) error {
+		return training.Run(ctx, roster)
+	})
+	s.Go("battery", battery.Run)
+	if len(rules) > 0 {
+		s.Go("alerts", func(ctx context.Context) error {
+			return alerts.Run(ctx, rules)
+		})
+	}
+	if len(hooks) > 0 || len(ruleHooks) > 0 {
+		s.Go("notify", func(ctx context.Context) error {
+			return notify.Run(ctx, hooks, ruleHooks)
+		})
+	}
+	if path := config.Current.Inventory.File; path != "" {
+		s.Go("inventory", func(ctx context.Context) error {
+			return inventory.Run(ctx, path)
+		})
+	}
+	if addr := config.Current.Export.MetricsAddr; addr != "" {
+		s.Go("metrics", func(ctx context.Context) error {
+			return metrics.Serve(ctx, addr)
+		})
+	}
+	if path := config.Current.Database; path != "" {
+		names := make([]string, len(stations))
+		for i, st := range stations {
+			names[i] = st.Name
+		}
+		s.Go("storage", func(ctx context.Context) error {
+			return storage.Record(ctx, path, names)
+		})
+	}
+	if dir := config.Current.Export.CSVDir; dir != "" {
+		s.Go("csv", func(ctx context.Context) error {
+			return csvexport.Record(ctx, dir, config.Current.Export.CSVLayout)
+		})
+	}
+	if broker := config.Current.Export.MQTTBroker; broker != "" {
+		export := config.Current.Export
+		s.Go("mqtt", func(ctx context.Context) error {
+			return mqtt.Publish(ctx, broker, export.MQTTTopic, export.MQTTQoS, export.MQTTRetain)
+		})
+	}
+	if addr := config.Current.Export.ServeAddr; addr != "" {
+		s.Go("server", func(ctx context.Context) error {
+			return server.Serve(ctx, addr, config.Current.Export.ServeRate)
+		})
+	}
+
+	err = s.Wait(SHUTDOWN_TIMEOUT)
+	fmt.Println("Terminating...")
+	return err
 }
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"unolink-client/config"
+
+	"github.com/spf13/cobra"
+)
+
+const DEFAULT_SERVE_ADDR = ":2290"
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run without the terminal UI, re-publishing the devices to other applications",
+	Long: `Connect to the base stations and re-publish the decoded devices and events
+without the terminal UI, so that many applications can share one connection:
+
+  GET /devices        every device, ?fields=speed,hrm restricts the metrics
+  GET /devices/{id}   one device
+  GET /ws             WebSocket stream; ?fields=, ?devices=, ?rate= and
+                      ?events= select what is sent, and a JSON message with
+                      the same keys changes the subscription
+
+The address defaults to ` + DEFAULT_SERVE_ADDR + ` unless --serve-addr is given.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if config.Current.Host == "" && len(config.Current.Stations) == 0 {
+			config.Current.Host = config.DEFAULT_HOST
+		}
+		if config.Current.Export.ServeAddr == "" {
+			config.Current.Export.ServeAddr = DEFAULT_SERVE_ADDR
+		}
+		return start(false)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"unolink-client/config"
+	"unolink-client/storage"
+	"unolink-client/units"
+
+	"github.com/spf13/cobra"
+)
+
+const SESSION_TIME_FORMAT = "2006-01-02 15:04:05"
+
+var (
+	sessionCmd = &cobra.Command{
+		Use:   "session",
+		Short: "Inspect the sessions stored in the database",
+	}
+
+	sessionListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the recorded sessions, the latest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			sessions, err := store.Sessions()
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tNAME\tSTARTED\tDURATION\tSTATIONS\tDEVICES\tSAMPLES")
+			for _, s := range sessions {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%d\n", s.ID, s.Name, s.Started.Format(SESSION_TIME_FORMAT),
+					sessionDuration(s), strings.Join(s.Stations, ","), s.Devices, s.Samples)
+			}
+			return w.Flush()
+		},
+	}
+
+	sessionShowCmd = &cobra.Command{
+		Use:   "show <id>",
+		Short: "Show the devices, totals and commands of a session",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid session ID %q", args[0])
+			}
+			store, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			s, err := store.Session(id)
+			if err != nil {
+				return err
+			}
+			devices, err := store.Devices(id)
+			if err != nil {
+				return err
+			}
+			laps, err := store.Laps(id)
+			if err != nil {
+				return err
+			}
+			totals, err := store.Totals(id)
+			if err != nil {
+				return err
+			}
+			sprints, err := store.Sprints(id)
+			if err != nil {
+				return err
+			}
+			commands, err := store.Commands(id)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Session %d: %s\n", s.ID, s.Name)
+			fmt.Printf("Started:  %s\n", s.Started.Format(SESSION_TIME_FORMAT))
+			fmt.Printf("Duration: %s\n", sessionDuration(s))
+			fmt.Printf("Stations: %s\n", strings.Join(s.Stations, ", "))
+			fmt.Printf("Samples:  %d\n\n", s.Samples)
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(w, "DEVICE\tPLAYER\tSAMPLES\tFIRST\tLAST\t%s\tMAX HR\t%s\n",
+				units.Title("MAX SPEED", "speed"), units.Title("DISTANCE", "distance"))
+			for _, d := range devices {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%d\t%s\n", d.Device, d.Player, d.Samples,
+					d.First.Format(time.TimeOnly), d.Last.Format(time.TimeOnly), units.Format("speed", d.MaxSpeed),
+					d.MaxHrm, units.Format("distance", d.Distance))
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			printTotals("Session totals", 0, totals)
+			for _, l := range laps {
+				title := fmt.Sprintf("%s (%s)", l.Name, l.Started.Format(time.TimeOnly))
+				if !l.Ended.IsZero() {
+					title = fmt.Sprintf("%s (%s, %s)", l.Name, l.Started.Format(time.TimeOnly),
+						l.Ended.Sub(l.Started).Round(time.Second))
+				}
+				printTotals(title, l.Number, totals)
+			}
+
+			if len(sprints) > 0 {
+				fmt.Println("\nSprints")
+				w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintf(w, "DEVICE\tPLAYER\tSTARTED\tDURATION\t%s\t%s\n",
+					units.Title("PEAK SPEED", "peak_speed"), units.Title("DISTANCE", "distance"))
+				for _, sp := range sprints {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", sp.Device, sp.Player, sp.Start.Format(time.TimeOnly),
+						sp.Duration.Round(100*time.Millisecond), units.Format("peak_speed", sp.PeakSpeed),
+						units.Format("distance", sp.Distance))
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			}
+
+			if len(commands) == 0 {
+				return nil
+			}
+			fmt.Println()
+			w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ISSUED\tDEVICE\tCOMMAND\tSTATE\tATTEMPTS\tAFTER")
+			for _, c := range commands {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", c.Issued.Format(time.TimeOnly), c.Device, c.Action,
+					c.State, c.Attempts, c.Settled.Sub(c.Issued).Round(time.Millisecond))
+			}
+			return w.Flush()
+		},
+	}
+)
+
+func init() {
+	sessionCmd.AddCommand(sessionListCmd, sessionShowCmd)
+	rootCmd.AddCommand(sessionCmd)
+}
+
+func openStore() (*storage.Store, error) {
+	if config.Current.Database == "" {
+		return nil, errors.New("no database configured, set database or --database")
+	}
+	return storage.Open(config.Current.Database)
+}
+
+// printTotals prints the totals of one lap, 0 being the whole session.
+func printTotals(title string, lap int, totals []storage.TotalsRecord) {
+	var rows []storage.TotalsRecord
+	for _, t := range totals {
+		if t.Lap == lap {
+			rows = append(rows, t)
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	fmt.Printf("\n%s\n", title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "DEVICE\tPLAYER\t%s\t%s\t%s\tACC\tDEC\tJUMPS\tIMPACTS\t%s\tTRIMP\tEDWARDS\tHR SIGNAL\tHR ZONES\t%s\t%s\n",
+		units.Title("DIST", "distance"), units.Title("HSD", "high_speed_distance"), units.Title("MAX SPEED", "max_speed"),
+		units.Title("HMLD", "hmld"), units.Title("SPEED BANDS", "speed_band"), units.Title("DEVICE BANDS", "device_band"))
+	for _, t := range rows {
+		zones := make([]string, len(t.HRZones))
+		for i, d := range t.HRZones {
+			zones[i] = d.Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\t%.1f\t%.1f\t%.0f%%\t%s\t%s\t%s\n", t.Device, t.Player,
+			units.Format("distance", t.Distance), units.Format("high_speed_distance", t.HighSpeedDistance),
+			units.Format("max_speed", t.MaxSpeed), t.Accelerations, t.Decelerations, t.Jumps, t.Impacts,
+			units.Format("hmld", t.HMLD), t.TRIMP, t.Edwards(), t.HRQuality()*100, strings.Join(zones, " "),
+			distances(t.SpeedBands), distances(t.DeviceBands))
+	}
+	w.Flush()
+}
+
+func distances(bands []float64) string {
+	s := make([]string, len(bands))
+	for i, d := range bands {
+		s[i] = units.Format("speed_band", d)
+	}
+	return strings.Join(s, " ")
+}
+
+func sessionDuration(s storage.Session) string {
+	if s.Ended.IsZero() {
+		return "-"
+	}
+	return s.Ended.Sub(s.Started).Round(time.Second).String()
+}
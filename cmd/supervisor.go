@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"unolink-client/logging"
+
+	"golang.org/x/sync/errgroup"
+)
+
+var ErrShutdownTimeout = errors.New("subsystems did not stop in time")
+
+// supervisor runs the subsystems of the client. The first one to fail or to
+// return stops all the others through the shared context, and every error is
+// kept to be reported on exit.
+type supervisor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	group  *errgroup.Group
+
+	mu   sync.Mutex
+	errs []error
+}
+
+func newSupervisor(parent context.Context) *supervisor {
+	ctx, cancel := context.WithCancel(parent)
+	group, ctx := errgroup.WithContext(ctx)
+	return &supervisor{ctx: ctx, cancel: cancel, group: group}
+}
+
+// Go starts a subsystem. It must return once ctx is cancelled.
+func (s *supervisor) Go(name string, fn func(ctx context.Context) error) {
+	s.group.Go(func() error {
+		defer s.cancel()
+		err := fn(s.ctx)
+		if err == nil || errors.Is(err, context.Canceled) {
+			logging.Debug("subsystem stopped", "subsystem", name)
+			return nil
+		}
+		logging.Error("subsystem failed", "subsystem", name, "err", err)
+		s.mu.Lock()
+		s.errs = append(s.errs, fmt.Errorf("%s: %w", name, err))
+		s.mu.Unlock()
+		return err
+	})
+}
+
+// Wait blocks until a subsystem stops, then gives the others timeout to
+// follow. It returns every error collected along the way.
+func (s *supervisor) Wait(timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		s.group.Wait()
+		close(done)
+	}()
+
+	<-s.ctx.Done()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logging.Error("shutdown timed out", "timeout", timeout)
+		s.mu.Lock()
+		s.errs = append(s.errs, ErrShutdownTimeout)
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(s.errs...)
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"unolink-client/config"
+	"unolink-client/connection"
+	"unolink-client/connection/conntest"
+)
+
+func TestSupervisor(t *testing.T) {
+	const timeout = 500 * time.Millisecond
+	errBroken := errors.New("broken")
+
+	tests := []struct {
+		name      string
+		subsystem func(ctx context.Context) error
+		cancel    bool    // the user interrupts the client
+		want      []error // errors.Is the returned error
+	}{
+		{
+			name: "subsystem fails",
+			subsystem: func(ctx context.Context) error {
+				time.Sleep(100 * time.Millisecond)
+				return errBroken
+			},
+			want: []error{errBroken},
+		},
+		{
+			name: "quit",
+			subsystem: func(ctx context.Context) error {
+				time.Sleep(100 * time.Millisecond)
+				return nil
+			},
+		},
+		{
+			name: "interrupted",
+			subsystem: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			cancel: true,
+		},
+		{
+			name: "subsystem hangs",
+			subsystem: func(ctx context.Context) error {
+				<-ctx.Done()
+				time.Sleep(2 * timeout)
+				return nil
+			},
+			cancel: true,
+			want:   []error{ErrShutdownTimeout},
+		},
+		{
+			name: "subsystem fails and another hangs",
+			subsystem: func(ctx context.Context) error {
+				time.Sleep(100 * time.Millisecond)
+				return errBroken
+			},
+			want: []error{errBroken, ErrShutdownTimeout},
+		},
+	}
+
+	config.Current = config.Default()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a base station without any device
+			st := conntest.StandIn(t, nil, nil)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			s := newSupervisor(ctx)
+			s.Go("connection", func(ctx context.Context) error {
+				return connection.Run(ctx, []config.Station{st})
+			})
+			s.Go("subsystem", tt.subsystem)
+			if len(tt.want) > 1 {
+				s.Go("hanging", func(ctx context.Context) error {
+					<-ctx.Done()
+					time.Sleep(2 * timeout)
+					return nil
+				})
+			}
+			if tt.cancel {
+				time.AfterFunc(100*time.Millisecond, cancel)
+			}
+
+			started := time.Now()
+			err := s.Wait(timeout)
+			if elapsed := time.Since(started); elapsed > timeout+time.Second {
+				t.Errorf("returned after %s", elapsed)
+			}
+			if len(tt.want) == 0 && err != nil {
+				t.Errorf("error %v, want none", err)
+			}
+			for _, want := range tt.want {
+				if !errors.Is(err, want) {
+					t.Errorf("error %v, want %v", err, want)
+				}
+			}
+		})
+	}
+}
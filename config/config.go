@@ -0,0 +1,612 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	APP_NAME          = "unolink-client"
+	FILE_NAME         = "config.yaml"
+	LAST_PROFILE_FILE = "last_profile"
+	ENV_PREFIX        = "UNOLINK_"
+	DEFAULT_HOST      = "127.0.0.1"
+)
+
+// Profile groups the connection settings of one base station. Empty fields
+// leave the current value untouched.
+type Profile struct {
+	Host       string `yaml:"host"`
+	RestPort   uint16 `yaml:"rest_port"`
+	StreamPort uint16 `yaml:"stream_port"`
+}
+
+// ListRefresh and MappingRefresh are the fastest polling rates used while
+// things change. Polling slows down to MaxRefresh when nothing does, and
+// speeds up to FastRefresh right after a command.
+type Intervals struct {
+	ListRefresh    time.Duration `yaml:"list_refresh"`
+	MappingRefresh time.Duration `yaml:"mapping_refresh"`
+	MaxRefresh     time.Duration `yaml:"max_refresh"`
+	FastRefresh    time.Duration `yaml:"fast_refresh"`
+	StreamTimeout  time.Duration `yaml:"stream_timeout"`
+	UIRefresh      time.Duration `yaml:"ui_refresh"` // window of the packet counters
+	Render         time.Duration `yaml:"render"`     // fastest table refresh
+}
+
+type UI struct {
+	Content  string `yaml:"content"` // "counters", "states", "session", "leaderboard" or "inventory"
+	ShowHelp bool   `yaml:"show_help"`
+	Rank     string `yaml:"rank"` // leaderboard metric: speed, max_speed, distance, high_speed_distance, accelerations or energy
+	Top      int    `yaml:"top"`  // devices of the compact leaderboard
+}
+
+// Commands controls how device commands are confirmed against the state
+// observed on the base stations.
+type Commands struct {
+	Timeout time.Duration `yaml:"timeout"`
+	Retries int           `yaml:"retries"`
+}
+
+// Telemetry describes the telemetry of the base stations. Slots is how many
+// devices a base station streams at once, 0 when unknown, which rules out
+// the slot commands and the group telemetry; VO2Max is sent for the devices
+// whose player has none.
+type Telemetry struct {
+	Slots  int     `yaml:"slots"`
+	VO2Max float64 `yaml:"vo2max"` // ml/kg/min
+}
+
+// Battery controls the battery warnings. Runtime is how long a full battery
+// lasts, used until the discharge rate of a device is known; Session is the
+// planned length of a session for the kit check.
+type Battery struct {
+	Warning  uint8         `yaml:"warning"`  // percent
+	Critical uint8         `yaml:"critical"` // percent
+	Runtime  time.Duration `yaml:"runtime"`
+	Session  time.Duration `yaml:"session"`
+}
+
+// Alerts controls the alert rules. Rules is the rules file, see
+// alerts.LoadRules; Bell rings the terminal bell on every alert.
+type Alerts struct {
+	Rules string `yaml:"rules"` // empty to disable
+	Bell  bool   `yaml:"bell"`
+}
+
+// Notify controls the webhook notifications. A device in telemetry is
+// offline once no packet of it was received for Offline; a failed delivery
+// is retried up to Retries times, waiting RetryDelay and then twice as long
+// every time.
+type Notify struct {
+	Webhooks   []Webhook     `yaml:"webhooks"`
+	Offline    time.Duration `yaml:"offline"`
+	Retries    int           `yaml:"retries"`
+	RetryDelay time.Duration `yaml:"retry_delay"`
+}
+
+// Webhook is a URL notified of the events listed in Events, every event when
+// empty. Template is a text/template of the body, the notification as JSON
+// when empty; Secret signs the body with HMAC-SHA256.
+type Webhook struct {
+	URL      string   `yaml:"url"`
+	Events   []string `yaml:"events"`
+	Template string   `yaml:"template"`
+	Secret   string   `yaml:"secret"`
+}
+
+// Inventory controls the record of every device ever seen. Devices with a
+// firmware older than MinFirmware are flagged as outdated.
+type Inventory struct {
+	File        string `yaml:"file"` // empty to disable
+	MinFirmware string `yaml:"min_firmware"`
+}
+
+// Training controls how sessions are delimited and totalled. HRZones holds
+// the lower bound of each heart rate zone as a fraction of the maximum heart
+// rate; MaxHR and RestHR apply to the players without their own. SpeedBands
+// holds the lower bound of each speed band, in metres per second; they should
+// match the five bands the devices count distance in to be cross-checked.
+type Training struct {
+	FollowTelemetry bool          `yaml:"follow_telemetry"` // start and stop sessions with the telemetry
+	HighSpeed       float64       `yaml:"high_speed"`       // metres per second from which distance is high intensity
+	SpeedBands      []float64     `yaml:"speed_bands"`
+	SprintSpeed     float64       `yaml:"sprint_speed"`    // metres per second from which an effort is a sprint
+	SprintDuration  time.Duration `yaml:"sprint_duration"` // shortest sprint
+	MaxHR           uint8         `yaml:"max_hr"`
+	RestHR          uint8         `yaml:"rest_hr"`
+	HRZones         []float64     `yaml:"hr_zones"`
+	SummaryDir      string        `yaml:"summary_dir"` // where session summaries are exported, empty to disable
+}
+
+// Units are the units metrics are displayed and exported in. The database
+// keeps those of the devices.
+type Units struct {
+	Speed    string `yaml:"speed"`    // m/s, km/h, mph or kn
+	Distance string `yaml:"distance"` // m, km or yd
+	Energy   string `yaml:"energy"`   // kcal or kJ
+}
+
+type Log struct {
+	File   string `yaml:"file"`
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// Export holds the destinations the decoded data is written to. An empty
+// value disables the corresponding sink.
+type Export struct {
+	CSVDir      string  `yaml:"csv_dir"`
+	CSVLayout   string  `yaml:"csv_layout"` // "device" or "type"
+	MetricsAddr string  `yaml:"metrics_addr"`
+	MQTTBroker  string  `yaml:"mqtt_broker"`
+	MQTTTopic   string  `yaml:"mqtt_topic"` // prefix of the published topics
+	MQTTQoS     uint8   `yaml:"mqtt_qos"`
+	MQTTRetain  bool    `yaml:"mqtt_retain"` // keep the last value of every topic on the broker
+	ServeAddr   string  `yaml:"serve_addr"`
+	ServeRate   float64 `yaml:"serve_rate"` // most state updates per second and device sent to a WebSocket client
+}
+
+// Station is a base station the client connects to.
+type Station struct {
+	Name       string
+	Host       string
+	RestPort   uint16
+	StreamPort uint16
+}
+
+type Config struct {
+	Profile    string             `yaml:"profile"`
+	Stations   []string           `yaml:"stations"` // profiles connected at the same time
+	Host       string             `yaml:"host"`
+	RestPort   uint16             `yaml:"rest_port"`
+	StreamPort uint16             `yaml:"stream_port"`
+	Intervals  Intervals          `yaml:"intervals"`
+	UI         UI                 `yaml:"ui"`
+	Commands   Commands           `yaml:"commands"`
+	Telemetry  Telemetry          `yaml:"telemetry"`
+	Training   Training           `yaml:"training"`
+	Battery    Battery            `yaml:"battery"`
+	Inventory  Inventory          `yaml:"inventory"`
+	Alerts     Alerts             `yaml:"alerts"`
+	Notify     Notify             `yaml:"notify"`
+	Units      Units              `yaml:"units"`
+	PushPath   string             `yaml:"push_path"` // server-sent events endpoint such as /events, empty to disable
+	Roster     string             `yaml:"roster"`
+	Database   string             `yaml:"database"` // SQLite file the sessions are stored in, empty to disable
+	Log        Log                `yaml:"log"`
+	Export     Export             `yaml:"export"`
+	Profiles   map[string]Profile `yaml:"profiles"`
+}
+
+// Current is the effective configuration, filled by Load.
+var Current = Default()
+
+func Default() Config {
+	// Host is left empty so that a missing address can be told apart from
+	// an explicit one: the client then asks for a profile or falls back to
+	// DEFAULT_HOST.
+	return Config{
+		RestPort:   2280,
+		StreamPort: 2281,
+		Intervals: Intervals{
+			ListRefresh:    1 * time.Second,
+			MappingRefresh: 1 * time.Second,
+			MaxRefresh:     8 * time.Second,
+			FastRefresh:    250 * time.Millisecond,
+			StreamTimeout:  1 * time.Second,
+			UIRefresh:      1 * time.Second,
+			Render:         100 * time.Millisecond,
+		},
+		UI: UI{
+			Content:  "counters",
+			Rank:     "speed",
+			Top:      5,
+			ShowHelp: false,
+		},
+		Commands: Commands{
+			Timeout: 10 * time.Second,
+			Retries: 1,
+		},
+		Telemetry: Telemetry{
+			VO2Max: 18.18,
+		},
+		Training: Training{
+			FollowTelemetry: true,
+			HighSpeed:       5.5,
+			SpeedBands:      []float64{0, 2, 4, 5.5, 7},
+			SprintSpeed:     7,
+			SprintDuration:  time.Second,
+			MaxHR:           190,
+			RestHR:          60,
+			HRZones:         []float64{0.5, 0.6, 0.7, 0.8, 0.9},
+		},
+		Battery: Battery{
+			Warning:  30,
+			Critical: 15,
+			Runtime:  6 * time.Hour,
+			Session:  90 * time.Minute,
+		},
+		Alerts: Alerts{
+			Bell: true,
+		},
+		Notify: Notify{
+			Offline:    10 * time.Second,
+			Retries:    5,
+			RetryDelay: 2 * time.Second,
+		},
+		Units: Units{
+			Speed:    "m/s",
+			Distance: "m",
+			Energy:   "kcal",
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "logfmt",
+		},
+		Export: Export{
+			CSVLayout:  "device",
+			MQTTTopic:  "unolink",
+			MQTTRetain: true,
+			ServeRate:  10,
+		},
+	}
+}
+
+// Dir returns the directory holding the client configuration, following the
+// XDG base directory specification on Linux.
+func Dir() (string, error) {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, APP_NAME), nil
+}
+
+func DefaultPath() string {
+	dir, err := Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, FILE_NAME)
+}
+
+// StateDir returns the directory where the client remembers things between
+// runs, following XDG_STATE_HOME.
+func StateDir() (string, error) {
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(base, APP_NAME), nil
+}
+
+// Player is an entry of the roster. MaxHR and RestHR replace those of the
+// training configuration when set; Sex, "m" or "f", selects the weighting of
+// the training impulse. VO2Max is sent to the device entering telemetry, and
+// Groups names the groups telemetry can be started for, such as "defenders".
+type Player struct {
+	Name   string   `yaml:"name"`
+	MaxHR  uint8    `yaml:"max_hr"`
+	RestHR uint8    `yaml:"rest_hr"`
+	Sex    string   `yaml:"sex"`
+	VO2Max float64  `yaml:"vo2max"`
+	Groups []string `yaml:"groups"`
+}
+
+// UnmarshalYAML accepts a bare name as well as the full entry.
+func (p *Player) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		return n.Decode(&p.Name)
+	}
+	type plain Player
+	return n.Decode((*plain)(p))
+}
+
+// HeartRate returns the maximum and resting heart rate of the player,
+// falling back to the training configuration.
+func (p Player) HeartRate() (max, rest uint8) {
+	max, rest = p.MaxHR, p.RestHR
+	if max == 0 {
+		max = Current.Training.MaxHR
+	}
+	if rest == 0 {
+		rest = Current.Training.RestHR
+	}
+	return max, rest
+}
+
+// LoadRoster reads the roster file, mapping device IDs to the players
+// wearing them:
+//
+//	0A0B0C: Jane Doe
+//	0A0B0D:
+//	  name: John Doe
+//	  max_hr: 195
+//	  rest_hr: 52
+//	  sex: m
+//	  vo2max: 52.5
+//	  groups: [defenders, bench]
+//
+// An empty path is an empty roster.
+func LoadRoster(path string) (map[string]Player, error) {
+	roster := map[string]Player{}
+	if path == "" {
+		return roster, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &roster); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	upper := make(map[string]Player, len(roster))
+	for id, player := range roster {
+		if player.Sex != "" && player.Sex != "m" && player.Sex != "f" {
+			return nil, fmt.Errorf("%s: %s: invalid sex %q, expected m or f", path, id, player.Sex)
+		}
+		if player.VO2Max < 0 {
+			return nil, fmt.Errorf("%s: %s: invalid vo2max %v", path, id, player.VO2Max)
+		}
+		upper[strings.ToUpper(id)] = player
+	}
+	return upper, nil
+}
+
+// Load resets Current to the defaults and then applies the file at path, the
+// selected profile and the UNOLINK_* environment variables on top of it. The
+// profile is taken from the argument, UNOLINK_PROFILE or the file, in this
+// order. A profile given as argument was chosen on the command line and
+// comes last, over the environment; the others come before it. A missing
+// file is only an error when required is set.
+func Load(path string, required bool, profile string) error {
+	Current = Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if !required && errors.Is(err, fs.ErrNotExist) {
+				data = nil
+			} else {
+				return err
+			}
+		}
+		if err := yaml.Unmarshal(data, &Current); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if profile == "" {
+		selected := os.Getenv(ENV_PREFIX + "PROFILE")
+		if selected == "" {
+			selected = Current.Profile
+		}
+		if selected != "" {
+			if err := ApplyProfile(selected); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(&Current).Elem(), ENV_PREFIX); err != nil {
+		return err
+	}
+	if profile != "" {
+		return ApplyProfile(profile)
+	}
+	return nil
+}
+
+// ApplyProfile copies the connection settings of the named profile into
+// Current.
+func ApplyProfile(name string) error {
+	p, ok := Current.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	Current.Profile = name
+	if p.Host != "" {
+		Current.Host = p.Host
+	}
+	if p.RestPort != 0 {
+		Current.RestPort = p.RestPort
+	}
+	if p.StreamPort != 0 {
+		Current.StreamPort = p.StreamPort
+	}
+	return nil
+}
+
+// StationList returns the base stations to connect to: one per entry of
+// Stations, or the single configured host otherwise.
+func StationList() ([]Station, error) {
+	if len(Current.Stations) == 0 {
+		name := Current.Profile
+		if name == "" {
+			name = Current.Host
+		}
+		return []Station{{
+			Name:       name,
+			Host:       Current.Host,
+			RestPort:   Current.RestPort,
+			StreamPort: Current.StreamPort,
+		}}, nil
+	}
+
+	var stations []Station
+	seen := map[string]bool{}
+	for _, name := range Current.Stations {
+		p, ok := Current.Profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q in stations", name)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if p.Host == "" {
+			return nil, fmt.Errorf("profile %q has no host", name)
+		}
+		s := Station{Name: name, Host: p.Host, RestPort: p.RestPort, StreamPort: p.StreamPort}
+		if s.RestPort == 0 {
+			s.RestPort = Current.RestPort
+		}
+		if s.StreamPort == 0 {
+			s.StreamPort = Current.StreamPort
+		}
+		stations = append(stations, s)
+	}
+	return stations, nil
+}
+
+// ProfileNames returns the configured profiles in alphabetical order.
+func ProfileNames() []string {
+	names := make([]string, 0, len(Current.Profiles))
+	for name := range Current.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LastProfile returns the profile used by the previous run, if any.
+func LastProfile() string {
+	dir, err := StateDir()
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(dir, LAST_PROFILE_FILE))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func SaveLastProfile(name string) error {
+	dir, err := StateDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, LAST_PROFILE_FILE), []byte(name+"\n"), 0o644)
+}
+
+// applyEnv walks the configuration and overrides every field whose variable
+// is set. Variable names are derived from the yaml keys, e.g.
+// intervals.list_refresh is read from UNOLINK_INTERVALS_LIST_REFRESH. Lists
+// of values are comma-separated, e.g. UNOLINK_TRAINING_HR_ZONES=0.5,0.6,0.7;
+// lists of settings such as notify.webhooks and the profiles can only be
+// set in the configuration file.
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + strings.ToUpper(tag)
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Map {
+			continue
+		}
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, name+"_"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.Struct {
+			return errors.New("can only be set in the configuration file")
+		}
+		var parts []string
+		if value != "" {
+			parts = strings.Split(value, ",")
+		}
+		slice := reflect.MakeSlice(field.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setField(slice.Index(i), strings.TrimSpace(part)); err != nil {
+				return fmt.Errorf("item %d: %w", i+1, err)
+			}
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+func (c Config) YAML() (string, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
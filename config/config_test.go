@@ -0,0 +1,150 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(c Config) bool
+		err   string
+	}{
+		{
+			name:  "string and port",
+			env:   map[string]string{"UNOLINK_HOST": "10.0.0.2", "UNOLINK_REST_PORT": "8080"},
+			check: func(c Config) bool { return c.Host == "10.0.0.2" && c.RestPort == 8080 },
+		},
+		{
+			name:  "nested duration and bool",
+			env:   map[string]string{"UNOLINK_INTERVALS_LIST_REFRESH": "250ms", "UNOLINK_UI_SHOW_HELP": "true"},
+			check: func(c Config) bool { return c.Intervals.ListRefresh == 250*time.Millisecond && c.UI.ShowHelp },
+		},
+		{
+			name: "list of floats",
+			env:  map[string]string{"UNOLINK_TRAINING_HR_ZONES": "0.5, 0.6,0.7"},
+			check: func(c Config) bool {
+				return reflect.DeepEqual(c.Training.HRZones, []float64{0.5, 0.6, 0.7})
+			},
+		},
+		{
+			name:  "empty list",
+			env:   map[string]string{"UNOLINK_TRAINING_SPEED_BANDS": ""},
+			check: func(c Config) bool { return c.Training.SpeedBands != nil && len(c.Training.SpeedBands) == 0 },
+		},
+		{name: "invalid port", env: map[string]string{"UNOLINK_REST_PORT": "70000"}, err: "UNOLINK_REST_PORT"},
+		{name: "invalid duration", env: map[string]string{"UNOLINK_COMMANDS_TIMEOUT": "5"}, err: "UNOLINK_COMMANDS_TIMEOUT"},
+		{name: "invalid item", env: map[string]string{"UNOLINK_TRAINING_HR_ZONES": "0.5,high"}, err: "item 2"},
+		{name: "list of settings", env: map[string]string{"UNOLINK_NOTIFY_WEBHOOKS": "http://localhost"}, err: "configuration file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c := Default()
+			err := applyEnv(reflect.ValueOf(&c).Elem(), ENV_PREFIX)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(c) {
+				t.Errorf("configuration %+v", c)
+			}
+		})
+	}
+}
+
+const profiles = `
+profile: field
+host: 10.0.0.1
+profiles:
+  field:
+    host: 10.0.0.10
+  gym:
+    host: 10.0.0.20
+    rest_port: 9000
+`
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FILE_NAME)
+	if err := os.WriteFile(path, []byte(profiles), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		profile  string // chosen on the command line
+		env      map[string]string
+		host     string
+		port     uint16
+		selected string
+	}{
+		{name: "profile of the file", host: "10.0.0.10", port: 2280, selected: "field"},
+		{name: "environment over the profile of the file", env: map[string]string{"UNOLINK_HOST": "10.0.0.3"}, host: "10.0.0.3", port: 2280, selected: "field"},
+		{name: "profile of the environment", env: map[string]string{"UNOLINK_PROFILE": "gym"}, host: "10.0.0.20", port: 9000, selected: "gym"},
+		{
+			name:     "environment over the profile of the environment",
+			env:      map[string]string{"UNOLINK_PROFILE": "gym", "UNOLINK_REST_PORT": "9100"},
+			host:     "10.0.0.20",
+			port:     9100,
+			selected: "gym",
+		},
+		{
+			name:     "chosen profile over the environment",
+			profile:  "gym",
+			env:      map[string]string{"UNOLINK_PROFILE": "field", "UNOLINK_HOST": "10.0.0.3", "UNOLINK_REST_PORT": "9100"},
+			host:     "10.0.0.20",
+			port:     9000,
+			selected: "gym",
+		},
+		{
+			name:     "environment where the chosen profile is silent",
+			profile:  "field",
+			env:      map[string]string{"UNOLINK_REST_PORT": "9100"},
+			host:     "10.0.0.10",
+			port:     9100,
+			selected: "field",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if err := Load(path, true, tt.profile); err != nil {
+				t.Fatal(err)
+			}
+			if Current.Host != tt.host || Current.RestPort != tt.port || Current.Profile != tt.selected {
+				t.Errorf("host %s, port %d, profile %q, want %s, %d, %q",
+					Current.Host, Current.RestPort, Current.Profile, tt.host, tt.port, tt.selected)
+			}
+		})
+	}
+	Current = Default()
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	if err := Load(filepath.Join(dir, "missing.yaml"), false, ""); err != nil {
+		t.Errorf("optional file: %v", err)
+	}
+	if err := Load(filepath.Join(dir, "missing.yaml"), true, ""); err == nil {
+		t.Error("required file: no error")
+	}
+	if err := Load("", false, "nowhere"); err == nil || !strings.Contains(err.Error(), "unknown profile") {
+		t.Errorf("unknown profile: error %v", err)
+	}
+	Current = Default()
+}
@@ -3,247 +3,414 @@ package connection
 import (
 	"bufio"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"unolink-client/config"
 	def "unolink-client/definitions"
+	"unolink-client/logging"
+	"unolink-client/metrics"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/ralflici/unolink-client/pkg/unolink"
+	"golang.org/x/sync/errgroup"
 )
 
-const (
-	LIST_MAPPING_REFRESH      = 1 * time.Second
-	TELEMETRY_MAPPING_REFRESH = 1 * time.Second
-	STREAM_SOCKET_TIMEOUT     = 1 * time.Second
-)
+const PACKET_SIZE = unolink.PacketSize
 
-var (
-	address    string
-	streamPort uint16
-	restPort   uint16
+var stations []config.Station
+
+// restGet performs a GET against the REST API and logs the outcome.
+func restGet(req *resty.Request, base, url string) (*resty.Response, error) {
+	endpoint := strings.TrimPrefix(url, "http://")
+	endpoint = endpoint[strings.Index(endpoint+"/", "/"):]
+	endpoint, _, _ = strings.Cut(endpoint, "?")
+
+	started := time.Now()
+	resp, err := req.Get(url)
+	elapsed := time.Since(started)
+	metrics.RestDuration.WithLabelValues(base, endpoint).Observe(elapsed.Seconds())
+	if err != nil {
+		metrics.RestErrors.WithLabelValues(base, endpoint).Inc()
+		logging.Error("rest call failed", "url", url, "duration", elapsed, "err", err)
+		return resp, err
+	}
+	if resp.IsError() {
+		metrics.RestErrors.WithLabelValues(base, endpoint).Inc()
+	}
+	logging.Debug("rest call", "url", url, "status", resp.StatusCode(), "duration", elapsed)
+	return resp, nil
+}
+
+func restURL(st config.Station) string {
+	return fmt.Sprintf("http://%s:%d", st.Host, st.RestPort)
+}
+
+// Run polls and streams every base station until ctx is cancelled. A base
+// station that cannot be reached is reported down through ConnectionChanged
+// and tried again with a growing delay, leaving the others alone.
+func Run(ctx context.Context, sts []config.Station) error {
+	stations = sts
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		trackCommands(ctx)
+		return nil
+	})
+	for _, st := range stations {
+		l := &link{station: st}
+		g.Go(func() error {
+			handleList(ctx, l)
+			return nil
+		})
+		g.Go(func() error {
+			handleMapping(ctx, l)
+			return nil
+		})
+		g.Go(func() error {
+			handleStream(ctx, st)
+			return nil
+		})
+		g.Go(func() error {
+			handlePush(ctx, l)
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+const (
+	RECONNECT_MIN = 1 * time.Second
+	RECONNECT_MAX = 30 * time.Second
 )
 
-func Handle(ctx context.Context, wg *sync.WaitGroup, errorCh chan<- error, quitCh <-chan struct{}, addr string, streamP, restP uint16) {
-	address = addr
-	restPort = restP
-	streamPort = streamP
+// backoff spaces the attempts to reach a base station that is down: the
+// delay doubles after every failure up to RECONNECT_MAX, and starts over once
+// the station answers.
+type backoff struct {
+	delay time.Duration
+}
 
-	defer wg.Done()
-	wg.Add(3)
+func (b *backoff) reset() {
+	b.delay = 0
+}
 
-	go handleList(ctx, wg, errorCh, quitCh)
-	go handleMapping(ctx, wg, errorCh, quitCh)
-	go handleStream(ctx, wg, errorCh, quitCh)
+// wait sleeps until the next attempt. It returns false when the client is
+// shutting down.
+func (b *backoff) wait(ctx context.Context) bool {
+	b.delay = min(max(2*b.delay, RECONNECT_MIN), RECONNECT_MAX)
+	timer := time.NewTimer(b.delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
 }
 
 // https://www.sobyte.net/post/2021-06/tutorials-for-using-resty/
-func handleList(ctx context.Context, wg *sync.WaitGroup, errCh chan<- error, quitCh <-chan struct{}) {
-	defer wg.Done()
-	url := fmt.Sprintf("http://"+address+":%d", restPort)
-	client := resty.New()
+func handleList(ctx context.Context, l *link) {
+	pollREST(ctx, l, "/listDevices", config.Current.Intervals.ListRefresh, l.applyList)
+}
 
-	type ListDevicesResp struct {
-		Result string            `json:"result"`
-		Infos  []def.ListDevices `json:"infos"`
-	}
+func handleMapping(ctx context.Context, l *link) {
+	pollREST(ctx, l, "/getTelemetryMapping", config.Current.Intervals.MappingRefresh, l.applyMapping)
+}
+
+// pollREST polls an endpoint of a base station until ctx is cancelled,
+// handing every new response to apply.
+func pollREST(ctx context.Context, l *link, endpoint string, min time.Duration, apply func([]byte)) {
+	url := restURL(l.station) + endpoint
+	client := resty.New()
+	p := newPoller(min)
+	var retry backoff
 
-	req := client.R()
+	req := client.R().SetContext(ctx)
+	up := false
 	for {
-		select {
-		case <-quitCh:
-			// fmt.Println("Quit list")
-			return
-		case <-ctx.Done():
-			return
-		default:
-			var ListDevicesResp ListDevicesResp
-			resp, err := req.Get(url + "/listDevices")
-			if err == nil {
-				err := json.Unmarshal(resp.Body(), &ListDevicesResp)
-				if err == nil {
-					def.List = ListDevicesResp.Infos
-					def.UpdateDevices()
-				}
-			} else {
-				// fmt.Println("Error      :", err)
-				errCh <- err
+		resp, err := restGet(req, l.station.Name, url)
+		if err != nil {
+			if ctx.Err() != nil {
 				return
 			}
-			time.Sleep(LIST_MAPPING_REFRESH)
+			if up || retry.delay == 0 {
+				publish(ConnectionChanged{Base: l.station.Name, Link: "rest", Up: false, Err: err})
+			}
+			up = false
+			if !retry.wait(ctx) {
+				return
+			}
+			continue
+		}
+		retry.reset()
+		if !up {
+			up = true
+			publish(ConnectionChanged{Base: l.station.Name, Link: "rest", Up: true})
+		}
+		if p.changed(resp.Body()) {
+			apply(resp.Body())
+		}
+		if !p.wait(ctx, l.push.Load()) {
+			return
 		}
 	}
 }
 
-func handleMapping(ctx context.Context, wg *sync.WaitGroup, errCh chan<- error, quitCh <-chan struct{}) {
-	defer wg.Done()
-	url := fmt.Sprintf("http://"+address+":%d", restPort)
-	client := resty.New()
-
-	type TelemetryMappingResp struct {
-		Result  string           `json:"result"`
-		Mapping map[string]uint8 `json:"mapping"`
-	}
-
-	req := client.R()
+// handleStream keeps the stream of a base station open until ctx is
+// cancelled, connecting again whenever it is lost.
+func handleStream(ctx context.Context, st config.Station) {
+	var retry backoff
 	for {
-		select {
-		case <-quitCh:
-			// fmt.Println("Quit mapping")
+		connected, err := stream(ctx, st)
+		if ctx.Err() != nil {
 			return
-		case <-ctx.Done():
+		}
+		if connected {
+			retry.reset()
+		}
+		if connected || retry.delay == 0 {
+			publish(ConnectionChanged{Base: st.Name, Link: "stream", Up: false, Err: err})
+		}
+		if !retry.wait(ctx) {
 			return
-		default:
-			var TelemetryMappingResp TelemetryMappingResp
-			resp, err := req.Get(url + "/getTelemetryMapping")
-			if err == nil {
-				err := json.Unmarshal(resp.Body(), &TelemetryMappingResp)
-				if err == nil {
-					def.TelemetryMapping = TelemetryMappingResp.Mapping
-				}
-			} else {
-				errCh <- err
-				return
-			}
-			time.Sleep(TELEMETRY_MAPPING_REFRESH)
 		}
+		logging.Info("stream reconnecting", "base", st.Name, "after", retry.delay)
+		metrics.StreamReconnects.WithLabelValues(st.Name).Inc()
 	}
 }
 
-func handleStream(ctx context.Context, wg *sync.WaitGroup, errCh chan<- error, quitCh <-chan struct{}) {
-	defer wg.Done()
-	var err error
-	tcpAddress, err := net.ResolveTCPAddr("tcp", fmt.Sprintf(address+":%d", streamPort))
-	conn, err := net.DialTCP("tcp", nil, tcpAddress)
+// stream reads the packets of a base station until the connection is lost
+// or ctx is cancelled. It reports whether the connection was established.
+// It duplicates unolink.Client.Stream for the metrics, the connection events
+// and the partial reads of the terminal client.
+func stream(ctx context.Context, st config.Station) (bool, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", st.Host, st.StreamPort))
 	if err != nil {
-		errCh <- err
-		return
+		if ctx.Err() == nil {
+			logging.Error("stream connection failed", "base", st.Name, "address", st.Host, "port", st.StreamPort, "err", err)
+		}
+		return false, err
 	}
-	fmt.Fprintf(conn, "GET / HTTP/1.0\r\n\r\n")
-	bufio.NewReader(conn)
+	logging.Info("stream connected", "base", st.Name, "address", conn.RemoteAddr().String())
+	metrics.StreamConnects.WithLabelValues(st.Name).Inc()
+	metrics.StreamUp.WithLabelValues(st.Name).Set(1)
+	publish(ConnectionChanged{Base: st.Name, Link: "stream", Up: true})
+	defer func() {
+		conn.Close()
+		metrics.StreamDisconnects.WithLabelValues(st.Name).Inc()
+		metrics.StreamUp.WithLabelValues(st.Name).Set(0)
+		logging.Info("stream disconnected", "base", st.Name, "address", conn.RemoteAddr().String())
+	}()
+	// unblock a pending read as soon as the client shuts down
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	fmt.Fprint(conn, unolink.StreamRequest)
+	reader := bufio.NewReader(conn)
 
-	// handle incoming packets
-	for {
-		select {
-		case <-quitCh:
-			// fmt.Println("Quit stream")
-			return
-		case <-ctx.Done():
-			return
-		default:
-			var bytes = make([]byte, 22)
-			for numBytes := 0; numBytes < 22; {
-				var n int = 0
-				conn.SetReadDeadline(time.Now().Add(STREAM_SOCKET_TIMEOUT))
-				bytes = make([]byte, 22)
-				n, err = conn.Read(bytes)
-				numBytes += n
+	// handle incoming packets; a read that times out halfway through a
+	// packet keeps what it got and carries on once ctx has been checked
+	var packet = make([]byte, PACKET_SIZE)
+	filled := 0
+	for ctx.Err() == nil {
+		conn.SetReadDeadline(time.Now().Add(config.Current.Intervals.StreamTimeout))
+		n, err := reader.Read(packet[filled:])
+		filled += n
+		if filled == PACKET_SIZE {
+			device, decoded, err := def.DecodePacket(st.Name, packet)
+			if err != nil {
+				reason := "malformed"
+				if errors.Is(err, unolink.ErrUnknownType) {
+					reason = "unknown-type"
+				}
+				metrics.DecodeErrors.WithLabelValues(st.Name, reason).Inc()
+				logging.Warn("cannot decode packet", "base", st.Name, "type", fmt.Sprintf("0x%02X", packet[0]),
+					"device", device.Id.String(), "err", err)
 			}
-			if err == nil {
-				def.DecodePacket(bytes)
+			publish(PacketDecoded{Base: st.Name, Device: device, Packet: decoded})
+			packet = make([]byte, PACKET_SIZE)
+			filled = 0
+		}
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
 			}
+			if ctx.Err() == nil {
+				logging.Error("stream lost", "base", st.Name, "err", err)
+			}
+			return true, fmt.Errorf("%s: stream: %w", st.Name, err)
 		}
 	}
+	return true, nil
 }
 
-func Activate(devices []string) {
-	var url = fmt.Sprintf("http://"+address+":%d/activate?devices=", restPort)
+// byStation groups devices by the base station they are attached to. Devices
+// of unknown owner are sent to the first station.
+func byStation(devices []string) map[string][]string {
+	groups := map[string][]string{}
 	for _, device := range devices {
-		url += device + "+"
-	}
-	url = url[:len(url)-1] // remove last '+'
-	client := resty.New()
-	_, err := client.R().Get(url)
-	if err != nil {
-		fmt.Println("Error during activation: ", err)
+		base := def.Owner(device)
+		if station(base) == nil && len(stations) > 0 {
+			base = stations[0].Name
+		}
+		groups[base] = append(groups[base], device)
 	}
+	return groups
 }
 
-func Deactivate(devices []string) {
-	var url = fmt.Sprintf("http://"+address+":%d/deactivate?devices=", restPort)
-	for _, device := range devices {
-		url += device + "+"
+func station(name string) *config.Station {
+	for i := range stations {
+		if stations[i].Name == name {
+			return &stations[i]
+		}
 	}
-	url = url[:len(url)-1] // remove last '+'
-	client := resty.New()
-	_, err := client.R().Get(url)
-	if err != nil {
-		fmt.Println("Error during deactivation: ", err)
+	return nil
+}
+
+// command sends the same device command to every station owning some of the
+// devices.
+func command(endpoint string, devices []string) {
+	defer nudge()
+	for base, group := range byStation(devices) {
+		st := station(base)
+		if st == nil {
+			continue
+		}
+		url := restURL(*st) + "/" + endpoint + "?devices=" + strings.Join(group, "+")
+		client := resty.New()
+		_, err := restGet(client.R(), base, url)
+		if err != nil {
+			logging.Error(endpoint+" failed", "base", base, "devices", group, "err", err)
+		}
 	}
 }
 
+func Activate(devices []string) {
+	command("activate", devices)
+	track("activate", devices, def.Listed, func(device string) {
+		command("activate", []string{device})
+	})
+}
+
+func Deactivate(devices []string) {
+	command("deactivate", devices)
+	track("deactivate", devices, notListed, func(device string) {
+		command("deactivate", []string{device})
+	})
+}
+
 func Shutdown(devices []string) {
-	var url = fmt.Sprintf("http://"+address+":%d/shutdown?devices=", restPort)
-	for _, device := range devices {
-		url += device + "+"
-	}
-	url = url[:len(url)-1] // remove last '+'
-	client := resty.New()
-	_, err := client.R().Get(url)
-	if err != nil {
-		fmt.Println("Error during shutdown: ", err)
-	}
+	command("shutdown", devices)
+	track("shutdown", devices, stoppedSending, func(device string) {
+		command("shutdown", []string{device})
+	})
 }
 
-func ToggleTelemetry(device string) {
-	var url string
-	_, ok := def.TelemetryMapping[device]
+// ToggleTelemetry takes a device out of telemetry, or puts it in with its
+// VO2Max.
+func ToggleTelemetry(device string, vo2max float64) {
+	_, ok := def.Slot(device)
 	if ok {
-		url = fmt.Sprintf("http://"+address+":%d/exitTelemetry?devices=%s", restPort, device)
+		exitTelemetry([]string{device})
 	} else {
-		url = fmt.Sprintf("http://"+address+":%d/startTelemetry?devices=%s&VO2Max=18.18", restPort, device)
+		StartTelemetry(map[string]float64{device: vo2max})
 	}
+}
 
-	client := resty.New()
-	_, err := client.R().Get(url)
-	if err != nil {
-		fmt.Println("Error toggling telemetry: ", err)
-	}
+func exitTelemetry(devices []string) {
+	command("exitTelemetry", devices)
+	track("exit telemetry", devices, hasNoSlot, func(device string) {
+		command("exitTelemetry", []string{device})
+	})
 }
 
-func StartTelemetry(devices []string) {
-	var url = fmt.Sprintf("http://"+address+":%d/startTelemetry?devices=", restPort)
-	for _, device := range devices {
-		url += device + "+"
+// StartTelemetry puts devices in telemetry, each with its VO2Max, 0 standing
+// for the configured one. The VO2Max is kept for the device entering
+// telemetry again.
+func StartTelemetry(vo2max map[string]float64) {
+	devices := make([]string, 0, len(vo2max))
+	vo2maxMu.Lock()
+	for device, v := range vo2max {
+		devices = append(devices, device)
+		if v > 0 {
+			vo2maxes[device] = v
+		}
 	}
-	url = url[:len(url)-1] // remove last '+'
-	url += "&"
+	vo2maxMu.Unlock()
+	sort.Strings(devices)
 
-	for range devices {
-		url += "VO2Max=18.18+"
+	startTelemetry(devices)
+	track("start telemetry", devices, hasSlot, func(device string) {
+		startTelemetry([]string{device})
+	})
+}
+
+var (
+	vo2maxMu sync.Mutex
+	vo2maxes = map[string]float64{} // last VO2Max sent for every device
+)
+
+func vo2maxOf(device string) float64 {
+	vo2maxMu.Lock()
+	defer vo2maxMu.Unlock()
+	if v, ok := vo2maxes[device]; ok {
+		return v
 	}
-	url = url[:len(url)-1] // remove last '+'
+	return config.Current.Telemetry.VO2Max
+}
 
-	fmt.Println(url)
+func startTelemetry(devices []string) {
+	defer nudge()
+	for base, group := range byStation(devices) {
+		st := station(base)
+		if st == nil {
+			continue
+		}
+		values := make([]string, len(group))
+		for i, device := range group {
+			values[i] = fmt.Sprintf("%.2f", vo2maxOf(device))
+		}
+		url := restURL(*st) + "/startTelemetry?devices=" + strings.Join(group, "+") + "&VO2Max=" + strings.Join(values, "+")
 
-	client := resty.New()
-	_, err := client.R().Get(url)
-	if err != nil {
-		fmt.Println("Error starting telemetry", err)
+		client := resty.New()
+		_, err := restGet(client.R(), base, url)
+		if err != nil {
+			logging.Error("start telemetry failed", "base", base, "devices", group, "err", err)
+		}
 	}
 }
 
+// StopTelemetry stops the telemetry of every base station. A device still in
+// telemetry afterwards has the stop of its station sent again.
 func StopTelemetry() {
-	url := fmt.Sprintf("http://"+address+":%d/stopTelemetry", restPort)
-	client := resty.New()
-	_, err := client.R().Get(url)
-	if err != nil {
-		fmt.Println("Error stopping telemetry", err)
-	} else {
-		// fmt.Println("Telemetry stopped")
+	var live []string
+	for device := range def.Mapping() {
+		live = append(live, device)
+	}
+	track("stop telemetry", live, hasNoSlot, func(device string) {
+		for base := range byStation([]string{device}) {
+			if st := station(base); st != nil {
+				stopTelemetry(*st)
+			}
+		}
+	})
+
+	for _, st := range stations {
+		stopTelemetry(st)
 	}
 }
 
-// Not supported anymore
-func TelemetryParty() {
-	url := fmt.Sprintf("http://"+address+":%d/telemetryParty", restPort)
+func stopTelemetry(st config.Station) {
+	defer nudge()
 	client := resty.New()
-	_, err := client.R().Get(url)
+	_, err := restGet(client.R(), st.Name, restURL(st)+"/stopTelemetry")
 	if err != nil {
-		fmt.Println("Error telemetry party", err)
-	} else {
-		// fmt.Println("Telemetry stopped")
+		logging.Error("stop telemetry failed", "base", st.Name, "err", err)
 	}
 }
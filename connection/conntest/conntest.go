@@ -0,0 +1,118 @@
+// Package conntest serves stand-ins of base stations to the tests of the
+// packages that follow them through the connection package.
+package conntest
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"unolink-client/config"
+
+	"github.com/ralflici/unolink-client/pkg/unolink"
+)
+
+// StandIn serves the REST API and the stream of a base station named
+// "stand-in" with the given device list and telemetry mapping. Every stream
+// opened receives the packets, given in hex, once the request opening it has
+// been read, and is kept open until the client closes it or the test ends.
+func StandIn(t testing.TB, devices []unolink.DeviceInfo, mapping map[string]uint8, packets ...string) config.Station {
+	t.Helper()
+	if devices == nil {
+		devices = []unolink.DeviceInfo{}
+	}
+	if mapping == nil {
+		mapping = map[string]uint8{}
+	}
+	var stream []byte
+	for _, p := range packets {
+		b, err := hex.DecodeString(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, b...)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/listDevices", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"result": "ok", "infos": devices})
+	})
+	mux.HandleFunc("/getTelemetryMapping", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"result": "ok", "mapping": mapping})
+	})
+	rest := httptest.NewServer(mux)
+	t.Cleanup(rest.Close)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	t.Cleanup(func() {
+		l.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+	})
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, c)
+			mu.Unlock()
+			go serve(c, stream)
+		}
+	}()
+
+	return config.Station{
+		Name:       "stand-in",
+		Host:       "127.0.0.1",
+		RestPort:   Port(t, rest.Listener.Addr()),
+		StreamPort: Port(t, l.Addr()),
+	}
+}
+
+// serve sends the stream once the request opening it has been read.
+func serve(c net.Conn, stream []byte) {
+	defer c.Close()
+	reader := bufio.NewReader(c)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if line == "\r\n" {
+			break
+		}
+	}
+	c.Write(stream)
+	reader.ReadByte() // until the client closes the stream
+}
+
+// Port returns the port of a local address.
+func Port(t testing.TB, addr net.Addr) uint16 {
+	t.Helper()
+	_, p, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uint16(n)
+}
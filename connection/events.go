@@ -0,0 +1,209 @@
+package connection
+
+import (
+	"fmt"
+	"sync"
+
+	def "unolink-client/definitions"
+	"unolink-client/logging"
+	"unolink-client/metrics"
+
+	"github.com/ralflici/unolink-client/pkg/unolink"
+)
+
+type EventKind int
+
+const (
+	DeviceAdded EventKind = iota
+	DeviceRemoved
+	BatteryChanged
+	SlotChanged
+)
+
+const (
+	NO_SLOT           = unolink.NoSlot
+	EVENT_BUFFER_SIZE = 256
+	// SAMPLE_BUFFER_SIZE is the buffer of the subscribers recording every
+	// packet, about a minute of a full base station.
+	SAMPLE_BUFFER_SIZE = 16384
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case DeviceAdded:
+		return "device-added"
+	case DeviceRemoved:
+		return "device-removed"
+	case BatteryChanged:
+		return "battery-changed"
+	case SlotChanged:
+		return "slot-changed"
+	}
+	return "unknown"
+}
+
+// Event describes a change observed on a base station. Battery fields are
+// only set for device and battery events, slot fields for slot events, with
+// NO_SLOT meaning the device is not in telemetry.
+type Event struct {
+	Kind        EventKind
+	Base        string
+	Device      string
+	Battery     string
+	PrevBattery string
+	Slot        int
+	PrevSlot    int
+}
+
+// Update is implemented by everything published to subscribers: Event,
+// PacketDecoded, DevicesChanged, MappingChanged, ConnectionChanged and
+// CommandSettled.
+type Update interface {
+	update()
+}
+
+func (Event) update() {}
+
+// PacketDecoded carries the state of a device right after one of its packets
+// has been decoded, and the packet itself. Packet is nil when the packet
+// could not be decoded.
+type PacketDecoded struct {
+	Base   string
+	Device def.DeviceState
+	Packet unolink.Packet
+}
+
+func (PacketDecoded) update() {}
+
+// DevicesChanged carries every device listed by a base station after a
+// device list changed.
+type DevicesChanged struct {
+	Devices []def.DeviceState
+}
+
+func (DevicesChanged) update() {}
+
+// MappingChanged carries the merged telemetry mapping after it changed, and
+// the mapping of every base station.
+type MappingChanged struct {
+	Mapping map[string]uint8
+	Bases   map[string]map[string]uint8
+}
+
+func (MappingChanged) update() {}
+
+// ConnectionChanged reports a link to a base station going up or down. Link
+// is "rest", "stream" or "push".
+type ConnectionChanged struct {
+	Base string
+	Link string
+	Up   bool
+	Err  error
+}
+
+func (ConnectionChanged) update() {}
+
+// CommandSettled reports a command that was confirmed or failed for good.
+type CommandSettled struct {
+	Command Command
+}
+
+func (CommandSettled) update() {}
+
+// subscriber is a channel updates are published to. The updates a sample
+// subscriber cannot keep up with are counted and reported, those of the
+// others are lost quietly when they are packets.
+type subscriber struct {
+	ch       chan Update
+	name     string // of a sample subscriber
+	dropping bool   // updates are being dropped since the last report
+	dropped  uint64
+}
+
+var (
+	subscribersMu sync.Mutex
+	subscribers   []*subscriber
+)
+
+// Subscribe returns a channel receiving every update from now on. Slow
+// subscribers lose updates rather than blocking the connection, a lost
+// packet being superseded by the next one of the device.
+func Subscribe() <-chan Update {
+	return subscribe(&subscriber{ch: make(chan Update, EVENT_BUFFER_SIZE)})
+}
+
+// SubscribeSamples returns a channel receiving every update from now on, for
+// the subscribers that need every decoded packet, such as the recorders. It
+// buffers SAMPLE_BUFFER_SIZE updates; the ones lost beyond that are counted
+// in metrics.UpdatesDropped under name and logged.
+func SubscribeSamples(name string) <-chan Update {
+	return subscribe(&subscriber{ch: make(chan Update, SAMPLE_BUFFER_SIZE), name: name})
+}
+
+func subscribe(s *subscriber) <-chan Update {
+	subscribersMu.Lock()
+	subscribers = append(subscribers, s)
+	subscribersMu.Unlock()
+	return s.ch
+}
+
+func publish(u Update) {
+	switch u := u.(type) {
+	case Event:
+		logging.Debug("event", "kind", u.Kind.String(), "base", u.Base, "device", u.Device,
+			"battery", u.Battery, "slot", u.Slot, "prev_slot", u.PrevSlot)
+	case ConnectionChanged:
+		logging.Info("connection changed", "base", u.Base, "link", u.Link, "up", u.Up, "err", u.Err)
+	}
+
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	for _, s := range subscribers {
+		select {
+		case s.ch <- u:
+			if s.dropping {
+				s.dropping = false
+				logging.Warn("subscriber caught up", "subscriber", s.name, "dropped", s.dropped)
+			}
+		default:
+			if s.name != "" {
+				if !s.dropping {
+					s.dropping = true
+					logging.Warn("subscriber behind, dropping updates", "subscriber", s.name)
+				}
+				s.dropped++
+				metrics.UpdatesDropped.WithLabelValues(s.name).Inc()
+			} else if _, ok := u.(PacketDecoded); !ok {
+				logging.Warn("update dropped", "update", fmt.Sprintf("%T", u))
+			}
+		}
+	}
+}
+
+// diffList compares two device lists of the same base station.
+func diffList(base string, prev, next []def.ListDevices) []Event {
+	var events []Event
+	for _, c := range unolink.DiffList(prev, next) {
+		e := Event{Kind: listKinds[c.Kind], Base: base, Device: c.Next.Id, Battery: c.Next.Batt, PrevBattery: c.Prev.Batt, Slot: NO_SLOT, PrevSlot: NO_SLOT}
+		if c.Kind == unolink.DeviceRemoved {
+			e.Device = c.Prev.Id
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+var listKinds = map[unolink.EventKind]EventKind{
+	unolink.DeviceAdded:    DeviceAdded,
+	unolink.DeviceRemoved:  DeviceRemoved,
+	unolink.BatteryChanged: BatteryChanged,
+}
+
+// diffMapping compares two telemetry mappings of the same base station.
+func diffMapping(base string, prev, next map[string]uint8) []Event {
+	var events []Event
+	for _, c := range unolink.DiffMapping(prev, next) {
+		events = append(events, Event{Kind: SlotChanged, Base: base, Device: c.Id, Slot: c.Slot, PrevSlot: c.PrevSlot})
+	}
+	return events
+}
@@ -0,0 +1,57 @@
+package connection
+
+import (
+	"fmt"
+	"sort"
+
+	"unolink-client/config"
+	def "unolink-client/definitions"
+)
+
+// GroupPlan splits the devices of a group between those telemetry can be
+// started for and those skipped, with the reason.
+type GroupPlan struct {
+	Start   []string
+	Skipped map[string]string
+}
+
+// PlanGroup checks which devices of a group can enter telemetry: those
+// attached to a base station, not in telemetry yet and fitting in the free
+// slots of their station, in the order of the IDs. It fails when the number
+// of slots is unknown.
+func PlanGroup(devices []string) (GroupPlan, error) {
+	capacity := config.Current.Telemetry.Slots
+	if capacity == 0 {
+		return GroupPlan{}, ErrUnknownCapacity
+	}
+	plan := GroupPlan{Skipped: map[string]string{}}
+	bases := def.BaseMappings()
+	free := map[string]int{}
+	for base, mapping := range bases {
+		free[base] = capacity - len(mapping)
+	}
+
+	sorted := append([]string(nil), devices...)
+	sort.Strings(sorted)
+	for _, device := range sorted {
+		if _, ok := def.Slot(device); ok {
+			plan.Skipped[device] = "already in telemetry"
+			continue
+		}
+		base := def.Owner(device)
+		if base == "" {
+			plan.Skipped[device] = "not attached to a base station"
+			continue
+		}
+		if _, ok := free[base]; !ok {
+			free[base] = capacity
+		}
+		if free[base] <= 0 {
+			plan.Skipped[device] = fmt.Sprintf("no free slot on %s", base)
+			continue
+		}
+		free[base]--
+		plan.Start = append(plan.Start, device)
+	}
+	return plan, nil
+}
@@ -0,0 +1,39 @@
+package connection
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"unolink-client/config"
+	def "unolink-client/definitions"
+)
+
+func TestPlanGroup(t *testing.T) {
+	def.UpdateDevices("groups", []def.ListDevices{
+		{Id: "2A0001", Batt: "80%"}, {Id: "2A0002", Batt: "80%"}, {Id: "2A0003", Batt: "80%"}, {Id: "2A0004", Batt: "80%"},
+	})
+	def.UpdateMapping("groups", map[string]uint8{"2A0001": 0})
+
+	config.Current = config.Default()
+	if _, err := PlanGroup([]string{"2A0002"}); !errors.Is(err, ErrUnknownCapacity) {
+		t.Fatalf("error %v, want %v", err, ErrUnknownCapacity)
+	}
+
+	config.Current.Telemetry.Slots = 3
+	plan, err := PlanGroup([]string{"2A0004", "2A0003", "2A0002", "2A0001", "2B0000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := GroupPlan{
+		Start: []string{"2A0002", "2A0003"},
+		Skipped: map[string]string{
+			"2A0001": "already in telemetry",
+			"2A0004": "no free slot on groups",
+			"2B0000": "not attached to a base station",
+		},
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("plan %+v, want %+v", plan, want)
+	}
+}
@@ -0,0 +1,152 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"unolink-client/config"
+	def "unolink-client/definitions"
+	"unolink-client/logging"
+)
+
+const FAST_POLL_WINDOW = 3 * time.Second
+
+var (
+	nudgeMu   sync.Mutex
+	nudgeCh   = make(chan struct{})
+	fastUntil time.Time
+)
+
+// nudge makes every poller query the base stations right away and keep
+// polling fast for a while, so the outcome of a command shows up quickly.
+func nudge() {
+	nudgeMu.Lock()
+	defer nudgeMu.Unlock()
+	fastUntil = time.Now().Add(FAST_POLL_WINDOW)
+	close(nudgeCh)
+	nudgeCh = make(chan struct{})
+}
+
+func nudged() (<-chan struct{}, bool) {
+	nudgeMu.Lock()
+	defer nudgeMu.Unlock()
+	return nudgeCh, time.Now().Before(fastUntil)
+}
+
+// poller adapts the polling rate of one endpoint: it doubles the interval up
+// to MaxRefresh every time the response is unchanged and goes back to min as
+// soon as it changes.
+type poller struct {
+	min      time.Duration
+	interval time.Duration
+	last     []byte
+}
+
+func newPoller(min time.Duration) *poller {
+	return &poller{min: min, interval: min}
+}
+
+// changed records body and reports whether it differs from the previous one.
+func (p *poller) changed(body []byte) bool {
+	if p.last != nil && bytes.Equal(p.last, body) {
+		max := config.Current.Intervals.MaxRefresh
+		if p.interval *= 2; p.interval > max {
+			p.interval = max
+		}
+		return false
+	}
+	p.last = append(p.last[:0], body...)
+	p.interval = p.min
+	return true
+}
+
+// wait sleeps until the next poll is due. It returns false when the client
+// is shutting down.
+func (p *poller) wait(ctx context.Context, push bool) bool {
+	nudgeCh, fast := nudged()
+	interval := p.interval
+	if fast {
+		interval = config.Current.Intervals.FastRefresh
+	} else if push {
+		interval = config.Current.Intervals.MaxRefresh
+	}
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-nudgeCh:
+		return true
+	case <-timer.C:
+		return true
+	}
+}
+
+// link holds what the client last saw from one base station, whether it was
+// polled or pushed.
+type link struct {
+	station config.Station
+	push    atomic.Bool
+
+	mu      sync.Mutex
+	list    []def.ListDevices
+	mapping map[string]uint8
+}
+
+type listDevicesResp struct {
+	Result string            `json:"result"`
+	Infos  []def.ListDevices `json:"infos"`
+}
+
+type telemetryMappingResp struct {
+	Result  string           `json:"result"`
+	Mapping map[string]uint8 `json:"mapping"`
+}
+
+func (l *link) applyList(body []byte) {
+	var resp listDevicesResp
+	if err := json.Unmarshal(body, &resp); err != nil {
+		logging.Warn("cannot decode device list", "base", l.station.Name, "err", err)
+		return
+	}
+
+	l.mu.Lock()
+	events := diffList(l.station.Name, l.list, resp.Infos)
+	l.list = resp.Infos
+	l.mu.Unlock()
+
+	if len(events) > 0 {
+		def.UpdateDevices(l.station.Name, resp.Infos)
+		publish(DevicesChanged{Devices: def.ListedSnapshot()})
+	}
+	for _, e := range events {
+		publish(e)
+	}
+}
+
+func (l *link) applyMapping(body []byte) {
+	var resp telemetryMappingResp
+	if err := json.Unmarshal(body, &resp); err != nil {
+		logging.Warn("cannot decode telemetry mapping", "base", l.station.Name, "err", err)
+		return
+	}
+
+	l.mu.Lock()
+	events := diffMapping(l.station.Name, l.mapping, resp.Mapping)
+	first := l.mapping == nil
+	l.mapping = resp.Mapping
+	l.mu.Unlock()
+
+	if first || len(events) > 0 {
+		def.UpdateMapping(l.station.Name, resp.Mapping)
+		publish(MappingChanged{Mapping: def.Mapping(), Bases: def.BaseMappings()})
+	}
+	for _, e := range events {
+		publish(e)
+	}
+}
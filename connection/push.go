@@ -0,0 +1,101 @@
+package connection
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"time"
+
+	"unolink-client/config"
+	"unolink-client/logging"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const PUSH_RETRY = 30 * time.Second
+
+// handlePush follows the server-sent events endpoint of a base station, when
+// it offers one. Each event carries the same JSON body as the REST endpoint
+// it is named after:
+//
+//	event: listDevices
+//	data: {"result": "ok", "infos": [...]}
+//
+//	event: telemetryMapping
+//	data: {"result": "ok", "mapping": {...}}
+//
+// While the channel is up the pollers only run at MaxRefresh as a safety net.
+func handlePush(ctx context.Context, l *link) {
+	if config.Current.PushPath == "" {
+		return
+	}
+	url := restURL(l.station) + config.Current.PushPath
+
+	for {
+		if !followPush(ctx, l, url) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(PUSH_RETRY):
+		}
+	}
+}
+
+// followPush reads the event stream until it ends. It returns false when the
+// server does not offer one, so that the client sticks to polling.
+func followPush(ctx context.Context, l *link, url string) bool {
+	resp, err := resty.New().R().
+		SetContext(ctx).
+		SetDoNotParseResponse(true).
+		SetHeader("Accept", "text/event-stream").
+		Get(url)
+	if err != nil {
+		logging.Debug("push channel unavailable", "base", l.station.Name, "err", err)
+		return ctx.Err() == nil
+	}
+	body := resp.RawBody()
+	defer body.Close()
+
+	if resp.StatusCode() != 200 || !strings.HasPrefix(resp.Header().Get("Content-Type"), "text/event-stream") {
+		logging.Info("push channel not offered, polling", "base", l.station.Name, "status", resp.StatusCode())
+		return false
+	}
+
+	logging.Info("push channel connected", "base", l.station.Name)
+	l.push.Store(true)
+	publish(ConnectionChanged{Base: l.station.Name, Link: "push", Up: true})
+	defer func() {
+		l.push.Store(false)
+		logging.Info("push channel closed", "base", l.station.Name)
+		publish(ConnectionChanged{Base: l.station.Name, Link: "push", Up: false})
+	}()
+
+	var event string
+	var data strings.Builder
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			switch event {
+			case "listDevices":
+				l.applyList([]byte(data.String()))
+			case "telemetryMapping":
+				l.applyMapping([]byte(data.String()))
+			}
+			event = ""
+			data.Reset()
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	return ctx.Err() == nil
+}
@@ -0,0 +1,168 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"unolink-client/config"
+	def "unolink-client/definitions"
+)
+
+// testStation points a station at a local stand-in server.
+func testStation(t *testing.T, name, addr string) config.Station {
+	t.Helper()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.Station{Name: name, Host: host, RestPort: uint16(p), StreamPort: uint16(p)}
+}
+
+func TestPush(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		push    bool // channel expected up
+	}{
+		{
+			name: "event stream",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				fmt.Fprint(w, "event: listDevices\ndata: {\"result\": \"ok\", \"infos\": [{\"code\": \"0A0B0C\", \"batt\": \"80%\", \"fmw\": \"1.2.0\"}]}\n\n")
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+			},
+			push: true,
+		},
+		{
+			name:    "not offered",
+			handler: http.NotFound,
+		},
+		{
+			name: "not an event stream",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "{}")
+			},
+		},
+	}
+
+	config.Current = config.Default()
+	config.Current.PushPath = "/events"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/events", tt.handler)
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			base := "push-" + tt.name
+			l := &link{station: testStation(t, base, srv.Listener.Addr().String())}
+			updates := Subscribe()
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				handlePush(ctx, l)
+				close(done)
+			}()
+
+			if !tt.push {
+				select {
+				case <-done:
+				case <-time.After(2 * time.Second):
+					t.Fatal("push channel not given up")
+				}
+				cancel()
+				if l.push.Load() {
+					t.Error("push channel up")
+				}
+				return
+			}
+
+			waitFor(t, updates, func(u Update) bool {
+				c, ok := u.(ConnectionChanged)
+				return ok && c.Base == base && c.Link == "push" && c.Up
+			})
+			waitFor(t, updates, func(u Update) bool {
+				e, ok := u.(Event)
+				return ok && e.Base == base && e.Kind == DeviceAdded && e.Device == "0A0B0C"
+			})
+			if !l.push.Load() {
+				t.Error("push channel down")
+			}
+			if def.Owner("0A0B0C") != base {
+				t.Errorf("owner %q, want %q", def.Owner("0A0B0C"), base)
+			}
+			cancel()
+			<-done
+			if l.push.Load() {
+				t.Error("push channel still up after shutdown")
+			}
+		})
+	}
+}
+
+// TestPollerWait checks that the pollers slow down to MaxRefresh while the
+// push channel is up, and still answer a nudge right away.
+func TestPollerWait(t *testing.T) {
+	config.Current = config.Default()
+	config.Current.Intervals.MaxRefresh = time.Hour
+	config.Current.Intervals.FastRefresh = 10 * time.Millisecond
+
+	tests := []struct {
+		name  string
+		push  bool
+		nudge bool
+		polls bool // poll within the test window
+	}{
+		{name: "polling", polls: true},
+		{name: "push", push: true},
+		{name: "push nudged", push: true, nudge: true, polls: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nudgeMu.Lock()
+			fastUntil = time.Time{}
+			nudgeMu.Unlock()
+
+			p := newPoller(10 * time.Millisecond)
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			polled := make(chan bool, 1)
+			go func() { polled <- p.wait(ctx, tt.push) }()
+			if tt.nudge {
+				time.Sleep(20 * time.Millisecond)
+				nudge()
+			}
+			if got := <-polled; got != tt.polls {
+				t.Errorf("polled %v, want %v", got, tt.polls)
+			}
+		})
+	}
+}
+
+// waitFor reads updates until one matches.
+func waitFor(t *testing.T, updates <-chan Update, match func(Update) bool) Update {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case u := <-updates:
+			if match(u) {
+				return u
+			}
+		case <-timeout:
+			t.Fatal("update not received")
+			return nil
+		}
+	}
+}
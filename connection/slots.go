@@ -0,0 +1,194 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"unolink-client/config"
+	def "unolink-client/definitions"
+	"unolink-client/logging"
+)
+
+// ErrUnknownCapacity is returned by the slot commands while the number of
+// slots of the base stations is not configured.
+var ErrUnknownCapacity = errors.New("the number of slots of the base stations is unknown, set telemetry.slots")
+
+var errStillLive = errors.New("device still in telemetry")
+
+// SlotMap inverts the telemetry mapping of a base station: the devices of
+// every slot in use, sorted. A slot with more than one device is in conflict.
+func SlotMap(mapping map[string]uint8) map[uint8][]string {
+	slots := map[uint8][]string{}
+	for id, slot := range mapping {
+		slots[slot] = append(slots[slot], id)
+	}
+	for _, devices := range slots {
+		sort.Strings(devices)
+	}
+	return slots
+}
+
+// misplaced returns the devices of a base station to move to another slot:
+// all but the first device of a slot in conflict, and those in a slot beyond
+// the capacity of the station.
+func misplaced(mapping map[string]uint8) []string {
+	capacity := config.Current.Telemetry.Slots
+	var devices []string
+	for slot, ids := range SlotMap(mapping) {
+		if int(slot) >= capacity {
+			devices = append(devices, ids...)
+			continue
+		}
+		devices = append(devices, ids[1:]...)
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+// The REST API of the base stations offers no way to pick the slot of a
+// device: the station hands one out when the device enters telemetry. Slots
+// are therefore changed by making devices leave telemetry, waiting for the
+// station to unmap them, and making them enter it again. The commands are
+// tracked until the station mapped them where expected.
+
+// reenter makes devices leave telemetry and enter it again once their base
+// station unmapped them. It returns the devices still mapped after the
+// command timeout, which are not sent back into telemetry.
+func reenter(devices []string) []string {
+	var live []string
+	for _, device := range devices {
+		if hasSlot(device) {
+			live = append(live, device)
+		}
+	}
+	if len(live) > 0 {
+		command("exitTelemetry", live)
+		deadline := time.Now().Add(config.Current.Commands.Timeout)
+		for !left(live) && time.Now().Before(deadline) {
+			time.Sleep(TRACKER_CHECK_INTERVAL)
+		}
+	}
+	var ready, stuck []string
+	for _, device := range devices {
+		if hasSlot(device) {
+			stuck = append(stuck, device)
+		} else {
+			ready = append(ready, device)
+		}
+	}
+	if len(ready) > 0 {
+		startTelemetry(ready)
+	}
+	return stuck
+}
+
+// left tells whether every device is out of telemetry.
+func left(devices []string) bool {
+	for _, device := range devices {
+		if hasSlot(device) {
+			return false
+		}
+	}
+	return true
+}
+
+// reentered tracks the devices just sent through reenter, failing right away
+// the commands of those that did not leave telemetry.
+func reentered(action string, devices, stuck []string, done func(string) bool) {
+	send := func(device string) {
+		for _, d := range reenter([]string{device}) {
+			fail(d, errStillLive)
+		}
+	}
+	track(action, devices, done, send)
+	for _, device := range stuck {
+		fail(device, errStillLive)
+	}
+}
+
+// CheckSlot tells why a device cannot be assigned a slot, if it cannot.
+func CheckSlot(device string, slot uint8) error {
+	base := def.Owner(device)
+	if base == "" {
+		return fmt.Errorf("device %s is not attached to a base station", device)
+	}
+	capacity := config.Current.Telemetry.Slots
+	if capacity == 0 {
+		return ErrUnknownCapacity
+	}
+	if int(slot) >= capacity {
+		return fmt.Errorf("slot %d is beyond the %d slots of %s", slot, capacity, base)
+	}
+	mapping := def.BaseMappings()[base]
+	if current, ok := mapping[device]; ok && current == slot {
+		return fmt.Errorf("device %s is already in slot %d", device, slot)
+	}
+	if taken := SlotMap(mapping)[slot]; len(taken) > 0 {
+		return fmt.Errorf("slot %d of %s is taken by %s", slot, base, taken[0])
+	}
+	return nil
+}
+
+// AssignSlot asks the base station of a device to put it in a slot, by
+// making the device enter telemetry again while the slot is free. It blocks
+// until the device left telemetry; the command fails when it does not, or
+// when the station keeps handing out another slot.
+func AssignSlot(device string, slot uint8) {
+	if err := CheckSlot(device, slot); err != nil {
+		logging.Warn("cannot assign slot", "device", device, "slot", slot, "err", err)
+		return
+	}
+	base := def.Owner(device)
+	logging.Info("assigning slot", "device", device, "base", base, "slot", slot)
+	stuck := reenter([]string{device})
+	reentered(fmt.Sprintf("slot %d", slot), []string{device}, stuck, func(device string) bool {
+		s, ok := def.Slot(device)
+		return ok && s == slot
+	})
+}
+
+// Misplaced returns the devices in a slot in conflict or beyond the capacity
+// of their base station. It fails when a station maps more devices than it
+// has slots, rebalancing being of no help then, or when the number of slots
+// is unknown.
+func Misplaced() ([]string, error) {
+	capacity := config.Current.Telemetry.Slots
+	if capacity == 0 {
+		return nil, ErrUnknownCapacity
+	}
+	bases := def.BaseMappings()
+	var moved []string
+	for base, mapping := range bases {
+		if len(mapping) > capacity {
+			return nil, fmt.Errorf("%s maps %d devices for %d slots", base, len(mapping), capacity)
+		}
+		moved = append(moved, misplaced(mapping)...)
+	}
+	return moved, nil
+}
+
+// Rebalance makes misplaced devices enter telemetry again, until their base
+// station maps them to a free slot within its capacity. It blocks until the
+// devices left telemetry.
+func Rebalance(moved []string) {
+	if len(moved) == 0 {
+		return
+	}
+	logging.Info("rebalancing slots", "devices", moved)
+	stuck := reenter(moved)
+	reentered("rebalance", moved, stuck, func(device string) bool {
+		base := def.Owner(device)
+		mapping := def.BaseMappings()[base]
+		if _, ok := mapping[device]; !ok {
+			return false
+		}
+		for _, d := range misplaced(mapping) {
+			if d == device {
+				return false
+			}
+		}
+		return true
+	})
+}
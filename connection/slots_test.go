@@ -0,0 +1,63 @@
+package connection
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"unolink-client/config"
+	def "unolink-client/definitions"
+)
+
+func TestMisplaced(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping map[string]uint8
+		want    []string
+	}{
+		{name: "empty", mapping: map[string]uint8{}},
+		{name: "in place", mapping: map[string]uint8{"0A0B0C": 0, "0D0E0F": 3}},
+		{name: "conflict", mapping: map[string]uint8{"0A0B0C": 1, "0D0E0F": 1, "101112": 1}, want: []string{"0D0E0F", "101112"}},
+		{name: "beyond the capacity", mapping: map[string]uint8{"0A0B0C": 0, "0D0E0F": 4}, want: []string{"0D0E0F"}},
+	}
+	config.Current = config.Default()
+	config.Current.Telemetry.Slots = 4
+	for _, tt := range tests {
+		if got := misplaced(tt.mapping); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckSlot(t *testing.T) {
+	def.UpdateDevices("slots", []def.ListDevices{{Id: "1A2B3C", Batt: "80%"}, {Id: "4D5E6F", Batt: "80%"}})
+	def.UpdateMapping("slots", map[string]uint8{"1A2B3C": 0, "4D5E6F": 2})
+
+	tests := []struct {
+		name     string
+		capacity int
+		device   string
+		slot     uint8
+		ok       bool
+	}{
+		{name: "free", capacity: 4, device: "1A2B3C", slot: 1, ok: true},
+		{name: "capacity unknown", device: "1A2B3C", slot: 1},
+		{name: "beyond the capacity", capacity: 4, device: "1A2B3C", slot: 4},
+		{name: "already there", capacity: 4, device: "1A2B3C", slot: 0},
+		{name: "taken", capacity: 4, device: "1A2B3C", slot: 2},
+		{name: "not attached", capacity: 4, device: "A1B2C3", slot: 1},
+	}
+	config.Current = config.Default()
+	for _, tt := range tests {
+		config.Current.Telemetry.Slots = tt.capacity
+		err := CheckSlot(tt.device, tt.slot)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: error %v", tt.name, err)
+		}
+	}
+
+	config.Current.Telemetry.Slots = 0
+	if _, err := Misplaced(); !errors.Is(err, ErrUnknownCapacity) {
+		t.Errorf("Misplaced: error %v, want %v", err, ErrUnknownCapacity)
+	}
+}
@@ -0,0 +1,225 @@
+package connection
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"unolink-client/config"
+	def "unolink-client/definitions"
+	"unolink-client/logging"
+)
+
+type CommandState int
+
+const (
+	CommandPending CommandState = iota
+	CommandConfirmed
+	CommandFailed
+)
+
+const (
+	TRACKER_CHECK_INTERVAL = 500 * time.Millisecond
+	CONFIRMED_DISPLAY_TIME = 5 * time.Second
+	SHUTDOWN_QUIET_TIME    = 3 * time.Second
+)
+
+func (s CommandState) String() string {
+	switch s {
+	case CommandPending:
+		return "pending"
+	case CommandConfirmed:
+		return "confirmed"
+	case CommandFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// Command is the last command issued to a device and what became of it.
+type Command struct {
+	Device   string
+	Action   string
+	State    CommandState
+	Attempts int
+	Issued   time.Time
+	Settled  time.Time
+
+	deadline time.Time
+	done     func(device string) bool // expected transition observed
+	send     func(device string)      // issues the command again
+}
+
+var (
+	commandsMu sync.Mutex
+	commands   = map[string]*Command{}
+)
+
+// track records a command just sent to devices. done tells when the expected
+// transition has been observed, send repeats the command for one device.
+func track(action string, devices []string, done func(string) bool, send func(string)) {
+	now := time.Now()
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+	for _, device := range devices {
+		commands[device] = &Command{
+			Device:   device,
+			Action:   action,
+			State:    CommandPending,
+			Attempts: 1,
+			Issued:   now,
+			deadline: now.Add(config.Current.Commands.Timeout),
+			done:     done,
+			send:     send,
+		}
+	}
+}
+
+// CommandStatus returns the last command issued to a device, if it is still
+// worth showing.
+func CommandStatus(device string) (Command, bool) {
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+	c, ok := commands[device]
+	if !ok {
+		return Command{}, false
+	}
+	return *c, true
+}
+
+// Commands returns the last command issued to every device still worth
+// showing.
+func Commands() map[string]Command {
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+	snapshot := make(map[string]Command, len(commands))
+	for device, c := range commands {
+		snapshot[device] = *c
+	}
+	return snapshot
+}
+
+// Retry issues again the last command of a device that failed.
+func Retry(device string) bool {
+	commandsMu.Lock()
+	c, ok := commands[device]
+	if !ok || c.State != CommandFailed {
+		commandsMu.Unlock()
+		return false
+	}
+	c.State = CommandPending
+	c.Attempts++
+	c.deadline = time.Now().Add(config.Current.Commands.Timeout)
+	send, action, attempts := c.send, c.Action, c.Attempts
+	commandsMu.Unlock()
+
+	logging.Info("command retried", "device", device, "action", action, "attempt", attempts)
+	send(device)
+	return true
+}
+
+// trackCommands confirms pending commands against the device list, the
+// telemetry mapping and the stream, retrying the ones whose effect does not
+// show up in time.
+func trackCommands(ctx context.Context) {
+	updates := Subscribe()
+	ticker := time.NewTicker(TRACKER_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case u := <-updates:
+			if _, ok := u.(Event); !ok {
+				continue
+			}
+		case <-ticker.C:
+		}
+		checkCommands()
+	}
+}
+
+func checkCommands() {
+	var resend []*Command
+	var settled []Command
+	now := time.Now()
+
+	commandsMu.Lock()
+	for device, c := range commands {
+		switch c.State {
+		case CommandConfirmed:
+			if now.Sub(c.Settled) > CONFIRMED_DISPLAY_TIME {
+				delete(commands, device)
+			}
+		case CommandPending:
+			if c.done(device) {
+				c.State = CommandConfirmed
+				c.Settled = now
+				settled = append(settled, *c)
+				logging.Info("command confirmed", "device", device, "action", c.Action, "attempts", c.Attempts, "after", now.Sub(c.Issued))
+			} else if now.After(c.deadline) {
+				if c.Attempts <= config.Current.Commands.Retries {
+					c.Attempts++
+					c.deadline = now.Add(config.Current.Commands.Timeout)
+					resend = append(resend, c)
+					logging.Warn("command unconfirmed, retrying", "device", device, "action", c.Action, "attempt", c.Attempts)
+				} else {
+					c.State = CommandFailed
+					c.Settled = now
+					settled = append(settled, *c)
+					logging.Error("command failed", "device", device, "action", c.Action, "attempts", c.Attempts)
+				}
+			}
+		}
+	}
+	commandsMu.Unlock()
+
+	for _, c := range settled {
+		publish(CommandSettled{Command: c})
+	}
+	for _, c := range resend {
+		go c.send(c.Device)
+	}
+}
+
+// fail settles the pending command of a device as failed without retrying
+// it, its effect being out of reach.
+func fail(device string, err error) {
+	commandsMu.Lock()
+	c, ok := commands[device]
+	if !ok || c.State != CommandPending {
+		commandsMu.Unlock()
+		return
+	}
+	c.State = CommandFailed
+	c.Settled = time.Now()
+	settled := *c
+	commandsMu.Unlock()
+
+	logging.Error("command failed", "device", device, "action", settled.Action, "attempts", settled.Attempts, "err", err)
+	publish(CommandSettled{Command: settled})
+}
+
+func hasSlot(device string) bool {
+	_, ok := def.Slot(device)
+	return ok
+}
+
+func hasNoSlot(device string) bool {
+	return !hasSlot(device)
+}
+
+// stoppedSending is true once the device left the lists and the stream has
+// been quiet for a while.
+func stoppedSending(device string) bool {
+	if def.Listed(device) {
+		return false
+	}
+	dev, ok := def.Lookup(device)
+	return !ok || time.Since(dev.LastSeen) > SHUTDOWN_QUIET_TIME
+}
+
+func notListed(device string) bool {
+	return !def.Listed(device)
+}
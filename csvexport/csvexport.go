@@ -0,0 +1,245 @@
+package csvexport
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	conn "unolink-client/connection"
+	"unolink-client/logging"
+	"unolink-client/training"
+	"unolink-client/units"
+
+	"github.com/ralflici/unolink-client/pkg/unolink"
+)
+
+const (
+	FLUSH_INTERVAL     = time.Second
+	SESSION_DIR_FORMAT = "20060102-150405"
+	// RECEIVED_FORMAT is understood by spreadsheets as a date and time
+	RECEIVED_FORMAT = "2006-01-02 15:04:05.000"
+
+	LAYOUT_DEVICE = "device"
+	LAYOUT_TYPE   = "type"
+)
+
+// COMMON_COLUMNS start every row: the arrival of the packet, where it came
+// from, its type and the device clock.
+var COMMON_COLUMNS = []string{"received", "base", "device", "type", "time"}
+
+// PACKET_TYPES lists the packet types in the order of their columns.
+var PACKET_TYPES = []unolink.PacketType{
+	unolink.Cumulative, unolink.Instantaneous, unolink.Position,
+	unolink.OtherData1, unolink.OtherData2, unolink.OtherData3,
+}
+
+// COLUMNS are the values of every packet type, in the order of values.
+var COLUMNS = map[unolink.PacketType][]string{
+	unolink.Cumulative:    {"tag_id", "energy", "distance", "equiv_distance"},
+	unolink.Instantaneous: {"speed", "hrm", "power", "vo2"},
+	unolink.Position:      {"lat", "lng"},
+	unolink.OtherData1:    {"pe_counter", "acc", "dec", "jump", "impact"},
+	unolink.OtherData2:    {"cum_distance_1", "cum_distance_2", "cum_distance_3", "cum_distance_4", "cum_distance_5"},
+	unolink.OtherData3:    {"hmld"},
+}
+
+// Record writes every decoded packet to CSV files under dir until ctx is
+// cancelled. Every training session gets a directory of its own, named after
+// its start and name; the packets received between sessions go to a
+// directory named after the time they started arriving. With LAYOUT_DEVICE
+// there is one file per device holding the columns of every packet type, a
+// row only filling those of its own; with LAYOUT_TYPE there is one file per
+// packet type holding every device.
+func Record(ctx context.Context, dir, layout string) error {
+	if layout != LAYOUT_DEVICE && layout != LAYOUT_TYPE {
+		return fmt.Errorf("invalid CSV layout %q, expected %s or %s", layout, LAYOUT_DEVICE, LAYOUT_TYPE)
+	}
+	logging.Info("csv export started", "dir", dir, "layout", layout)
+
+	r := &recorder{root: dir, layout: layout}
+	r.rotate(time.Now().Format(SESSION_DIR_FORMAT))
+	defer r.close()
+
+	updates := conn.SubscribeSamples("csv")
+	sessions := training.Subscribe()
+	if t, ok := training.Running(); ok {
+		r.rotate(t.Start.Format(SESSION_DIR_FORMAT) + "-" + training.FileName(t.Name))
+	}
+	flush := time.NewTicker(FLUSH_INTERVAL)
+	defer flush.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-flush.C:
+			if err := r.flush(); err != nil {
+				return err
+			}
+		case u := <-sessions:
+			switch u := u.(type) {
+			case training.SessionStarted:
+				r.rotate(u.Session.Start.Format(SESSION_DIR_FORMAT) + "-" + training.FileName(u.Session.Name))
+			case training.SessionStopped:
+				r.rotate(time.Now().Format(SESSION_DIR_FORMAT))
+			}
+		case u := <-updates:
+			if u, ok := u.(conn.PacketDecoded); ok && u.Packet != nil {
+				if err := r.write(u); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+type file struct {
+	f *os.File
+	w *csv.Writer
+}
+
+// recorder writes to the files of dir, a directory under root created with
+// the first of them.
+type recorder struct {
+	root   string
+	dir    string
+	layout string
+	files  map[string]*file
+}
+
+// rotate closes the current files and moves on to the directory name.
+func (r *recorder) rotate(name string) {
+	if r.files != nil {
+		r.close()
+	}
+	r.dir = filepath.Join(r.root, name)
+	r.files = map[string]*file{}
+}
+
+func (r *recorder) write(u conn.PacketDecoded) error {
+	t := u.Packet.PacketHeader().Type
+	row := []string{
+		u.Device.LastSeen.Format(RECEIVED_FORMAT),
+		u.Base,
+		u.Device.Id.String(),
+		t.String(),
+		strconv.FormatUint(uint64(u.Packet.PacketHeader().Time), 10),
+	}
+
+	var name string
+	var header []string
+	switch r.layout {
+	case LAYOUT_DEVICE:
+		name = u.Device.Id.String()
+		header = append([]string(nil), COMMON_COLUMNS...)
+		for _, pt := range PACKET_TYPES {
+			header = append(header, columns(pt)...)
+			if pt == t {
+				row = append(row, values(u.Packet)...)
+			} else {
+				row = append(row, make([]string, len(COLUMNS[pt]))...)
+			}
+		}
+	case LAYOUT_TYPE:
+		name = t.String()
+		header = append(append([]string(nil), COMMON_COLUMNS...), columns(t)...)
+		row = append(row, values(u.Packet)...)
+	}
+
+	f, err := r.open(name, header)
+	if err != nil {
+		return err
+	}
+	return f.w.Write(row)
+}
+
+// open returns the file called name, creating it with its header row.
+func (r *recorder) open(name string, header []string) (*file, error) {
+	if f, ok := r.files[name]; ok {
+		return f, nil
+	}
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(r.dir, name+".csv")
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(f)
+	if err := w.Write(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	logging.Debug("csv file created", "path", path)
+	r.files[name] = &file{f: f, w: w}
+	return r.files[name], nil
+}
+
+func (r *recorder) flush() error {
+	for name, f := range r.files {
+		f.w.Flush()
+		if err := f.w.Error(); err != nil {
+			return fmt.Errorf("csv %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (r *recorder) close() {
+	if err := r.flush(); err != nil {
+		logging.Error("cannot flush csv files", "err", err)
+	}
+	for _, f := range r.files {
+		f.f.Close()
+	}
+	if len(r.files) > 0 {
+		logging.Info("csv files closed", "dir", r.dir, "files", len(r.files))
+	}
+}
+
+// columns returns the header of the values of a packet type, suffixed with
+// their units.
+func columns(t unolink.PacketType) []string {
+	c := make([]string, len(COLUMNS[t]))
+	for i, name := range COLUMNS[t] {
+		c[i] = units.Column(name)
+	}
+	return c
+}
+
+// values formats the values of a packet, in the order of COLUMNS, in the
+// selected units.
+func values(p unolink.Packet) []string {
+	u := func(v uint64) string { return strconv.FormatUint(v, 10) }
+	f := func(name string, v float32) string {
+		return strconv.FormatFloat(units.Convert(name, float64(v)), 'f', -1, 32)
+	}
+	// the counters do not fit the precision of a float32
+	n := func(name string, v uint64) string {
+		return strconv.FormatFloat(units.Convert(name, float64(v)), 'f', -1, 64)
+	}
+
+	switch p := p.(type) {
+	case unolink.CumulativePacket:
+		return []string{u(uint64(p.TagId)), f("energy", p.Energy), f("distance", p.Distance), f("equiv_distance", p.EquivDistance)}
+	case unolink.InstantaneousPacket:
+		return []string{f("speed", p.Speed), u(uint64(p.Hrm)), f("power", p.Power), f("vo2", p.Vo2)}
+	case unolink.PositionPacket:
+		return []string{u(uint64(p.Lat)), u(uint64(p.Lng))}
+	case unolink.OtherData1Packet:
+		return []string{u(uint64(p.PeCounter)), u(uint64(p.Acc)), u(uint64(p.Dec)), u(uint64(p.Jump)), u(uint64(p.Impact))}
+	case unolink.OtherData2Packet:
+		row := make([]string, len(p.CumDistance))
+		for i, d := range p.CumDistance {
+			row[i] = n("cum_distance", uint64(d))
+		}
+		return row
+	case unolink.OtherData3Packet:
+		return []string{n("hmld", uint64(p.Hmld))}
+	}
+	return nil
+}
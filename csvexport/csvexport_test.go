@@ -0,0 +1,189 @@
+package csvexport
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"unolink-client/config"
+	conn "unolink-client/connection"
+	def "unolink-client/definitions"
+
+	"github.com/ralflici/unolink-client/pkg/unolink"
+)
+
+// Golden packets of device 0A0B0C at time 0x010203.
+const (
+	INSTANTANEOUS = "230C0B0A030201EE4B980000E84000000E4200000000"
+	OTHER_DATA_2  = "2B0C0B0A030201B00400200300C20100780000EFCDAB"
+	OTHER_DATA_3  = "2C0C0B0A030201E11000000000000000000000000000"
+)
+
+var received = time.Date(2026, 1, 1, 10, 0, 0, 0, time.Local)
+
+func decoded(t *testing.T, packet string) conn.PacketDecoded {
+	t.Helper()
+	b, err := hex.DecodeString(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := unolink.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := def.DeviceState{Id: def.RadioAddress(p.PacketHeader().Device), LastSeen: received}
+	return conn.PacketDecoded{Base: "north", Device: device, Packet: p}
+}
+
+// read returns the rows of a CSV file, its header first.
+func read(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+// files lists the files under dir, relative to it.
+func files(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestLayouts(t *testing.T) {
+	common := []string{"2026-01-01 10:00:00.000", "north", "0A0B0C"}
+	tests := []struct {
+		layout string
+		files  map[string][][]string
+	}{
+		{
+			layout: LAYOUT_DEVICE,
+			files: map[string][][]string{
+				"morning/0A0B0C.csv": {
+					{"received", "base", "device", "type", "time",
+						"tag_id", "energy_kcal", "distance_m", "equiv_distance_m",
+						"speed_ms", "hrm_bpm", "power_w", "vo2_mlkgmin",
+						"lat", "lng",
+						"pe_counter", "acc", "dec", "jump", "impact",
+						"cum_distance_1_m", "cum_distance_2_m", "cum_distance_3_m", "cum_distance_4_m", "cum_distance_5_m",
+						"hmld_m"},
+					append(append(common, "instantaneous", "66051", "", "", "", ""),
+						"9.999794", "152", "7.25", "35.5", "", "", "", "", "", "", "", "", "", "", "", "", ""),
+					append(append(common, "other-data-2", "66051", "", "", "", "", "", "", "", "", "", "", "", "", "", "", ""),
+						"1200", "800", "450", "120", "11259375", ""),
+					append(append(common, "other-data-3", "66051"),
+						"", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "4321"),
+				},
+			},
+		},
+		{
+			layout: LAYOUT_TYPE,
+			files: map[string][][]string{
+				"morning/instantaneous.csv": {
+					{"received", "base", "device", "type", "time", "speed_ms", "hrm_bpm", "power_w", "vo2_mlkgmin"},
+					append(common, "instantaneous", "66051", "9.999794", "152", "7.25", "35.5"),
+				},
+				"morning/other-data-2.csv": {
+					{"received", "base", "device", "type", "time", "cum_distance_1_m", "cum_distance_2_m", "cum_distance_3_m", "cum_distance_4_m", "cum_distance_5_m"},
+					append(common, "other-data-2", "66051", "1200", "800", "450", "120", "11259375"),
+				},
+				"morning/other-data-3.csv": {
+					{"received", "base", "device", "type", "time", "hmld_m"},
+					append(common, "other-data-3", "66051", "4321"),
+				},
+			},
+		},
+	}
+
+	config.Current = config.Default()
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			dir := t.TempDir()
+			r := &recorder{root: dir, layout: tt.layout}
+			r.rotate("morning")
+			for _, p := range []string{INSTANTANEOUS, OTHER_DATA_2, OTHER_DATA_3} {
+				if err := r.write(decoded(t, p)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r.close()
+
+			var want []string
+			for name := range tt.files {
+				want = append(want, name)
+			}
+			sort.Strings(want)
+			if got := files(t, dir); !reflect.DeepEqual(got, want) {
+				t.Fatalf("files %v, want %v", got, want)
+			}
+			for name, rows := range tt.files {
+				if got := read(t, filepath.Join(dir, name)); !reflect.DeepEqual(got, rows) {
+					t.Errorf("%s:\n%q\nwant\n%q", name, got, rows)
+				}
+			}
+		})
+	}
+}
+
+func TestRotate(t *testing.T) {
+	config.Current = config.Default()
+	dir := t.TempDir()
+	r := &recorder{root: dir, layout: LAYOUT_TYPE}
+
+	// no file is created before a packet arrives
+	r.rotate("20260101-090000")
+	r.rotate("20260101-100000-morning")
+	if err := r.write(decoded(t, INSTANTANEOUS)); err != nil {
+		t.Fatal(err)
+	}
+	r.rotate("20260101-113000")
+	if err := r.write(decoded(t, INSTANTANEOUS)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.write(decoded(t, OTHER_DATA_3)); err != nil {
+		t.Fatal(err)
+	}
+	r.close()
+
+	want := []string{
+		"20260101-100000-morning/instantaneous.csv",
+		"20260101-113000/instantaneous.csv",
+		"20260101-113000/other-data-3.csv",
+	}
+	if got := files(t, dir); !reflect.DeepEqual(got, want) {
+		t.Fatalf("files %v, want %v", got, want)
+	}
+	if rows := read(t, filepath.Join(dir, want[0])); len(rows) != 2 {
+		t.Errorf("%s: %d rows, want a header and a packet", want[0], len(rows))
+	}
+}
+
+func TestRecordLayout(t *testing.T) {
+	if err := Record(context.Background(), t.TempDir(), "session"); err == nil {
+		t.Error("invalid layout accepted")
+	}
+}
@@ -1,28 +1,38 @@
 package definitions
 
 import (
-	"encoding/binary"
 	"fmt"
-	"math"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"unolink-client/logging"
+
+	"github.com/ralflici/unolink-client/pkg/unolink"
 )
 
+// mu guards the state below. The exported functions take it themselves;
+// GetDevice and CreateDevice expect the caller to hold it.
+var mu sync.Mutex
+
 var (
 	Devices          []DeviceState
-	List             []ListDevices
-	TelemetryMapping map[string]uint8
+	Lists            = map[string][]ListDevices{}     // last device list of each base station
+	Mappings         = map[string]map[string]uint8{} // last telemetry mapping of each base station
+	TelemetryMapping map[string]uint8                // union of Mappings, by device ID
 )
 
 const (
-    SPEED_CONVERSION_FACTOR = 1.94384 * 1000
-
-	Cumulative    = 0x22
-	Instantaneous = 0x23
-	Position      = 0x24
-	OtherData1    = 0x28
-	OtherData2    = 0x2B
-	OtherData3    = 0x2C
+	SPEED_CONVERSION_FACTOR = unolink.SpeedConversionFactor
+	BATTERY_UNKNOWN         = 255 // battery of a device not listed yet
+
+	Cumulative    = unolink.Cumulative
+	Instantaneous = unolink.Instantaneous
+	Position      = unolink.Position
+	OtherData1    = unolink.OtherData1
+	OtherData2    = unolink.OtherData2
+	OtherData3    = unolink.OtherData3
 )
 
 type RadioAddress [3]uint8
@@ -47,7 +57,6 @@ func RadioAddressFromString(s string) (RadioAddress, error) {
 		// Parse the hex value as base 16 and convert it to a byte
 		hexValue, err := strconv.ParseUint(s[i:i+2], 16, 8)
 		if err != nil {
-			fmt.Println("Error parsing hexadecimal string:", err)
 			return RadioAddress{}, err
 		}
 		// Append the byte to the slice
@@ -70,6 +79,18 @@ func (d *PacketCounter) Total() uint32 {
 		d.NumOtherData1 + d.NumOtherData2 + d.NumOtherData3
 }
 
+// Sub returns the packets counted since o was taken.
+func (d PacketCounter) Sub(o PacketCounter) PacketCounter {
+	return PacketCounter{
+		NumInstantaneous: d.NumInstantaneous - o.NumInstantaneous,
+		NumCumulative:    d.NumCumulative - o.NumCumulative,
+		NumPosition:      d.NumPosition - o.NumPosition,
+		NumOtherData1:    d.NumOtherData1 - o.NumOtherData1,
+		NumOtherData2:    d.NumOtherData2 - o.NumOtherData2,
+		NumOtherData3:    d.NumOtherData3 - o.NumOtherData3,
+	}
+}
+
 func (d *PacketCounter) Clear() {
 	d.NumCumulative = 0
 	d.NumInstantaneous = 0
@@ -87,9 +108,12 @@ func (d *PacketCounter) String() string {
 
 type DeviceState struct {
 	Id            RadioAddress
+	Base          string    // base station the device is currently attached to
+	LastSeen      time.Time // arrival of the last stream packet
 	Slot          uint8
 	LiveOn        bool
     Battery       uint8
+	Firmware      string // version reported in the device list
 	Counter       PacketCounter
 	Time          uint32
 	Speed         float32
@@ -123,55 +147,51 @@ func (d *DeviceState) PrintCounter() {
 	d.Counter.Clear()
 }
 
-func (d *DeviceState) UpdateCumulative(packet []byte) {
+func (d *DeviceState) UpdateCumulative(p unolink.CumulativePacket) {
 	d.Counter.NumCumulative++
-	d.Time = binary.LittleEndian.Uint32(append(packet[4:7], []byte{0x00}...))
-	d.TagId = binary.LittleEndian.Uint16(packet[7:9])
-	d.Energy = math.Float32frombits(binary.LittleEndian.Uint32(packet[9:13]))
-	d.Distance = math.Float32frombits(binary.LittleEndian.Uint32(packet[13:17]))
-	d.EquivDistance = math.Float32frombits(binary.LittleEndian.Uint32(packet[17:21]))
+	d.Time = p.Time
+	d.TagId = p.TagId
+	d.Energy = p.Energy
+	d.Distance = p.Distance
+	d.EquivDistance = p.EquivDistance
 }
 
-func (d *DeviceState) UpdateInstantaneous(packet []byte) {
+func (d *DeviceState) UpdateInstantaneous(p unolink.InstantaneousPacket) {
 	d.Counter.NumInstantaneous++
-	d.Time = binary.LittleEndian.Uint32(append(packet[4:7], []byte{0x00}...))
-	d.Speed = float32(binary.LittleEndian.Uint16(packet[7:9])) / SPEED_CONVERSION_FACTOR
-	d.Hrm = packet[9]
-	d.Power = math.Float32frombits(binary.LittleEndian.Uint32(packet[10:14]))
-	d.Vo2 = math.Float32frombits(binary.LittleEndian.Uint32(packet[14:18]))
+	d.Time = p.Time
+	d.Speed = p.Speed
+	d.Hrm = p.Hrm
+	d.Power = p.Power
+	d.Vo2 = p.Vo2
 }
 
-func (d *DeviceState) UpdatePosition(packet []byte) {
+func (d *DeviceState) UpdatePosition(p unolink.PositionPacket) {
 	d.Counter.NumPosition++
-	d.Time = binary.LittleEndian.Uint32(append(packet[4:7], []byte{0x00}...))
-	d.Lat = binary.LittleEndian.Uint32(packet[7:11])
-	d.Lng = binary.LittleEndian.Uint32(packet[11:15])
+	d.Time = p.Time
+	d.Lat = p.Lat
+	d.Lng = p.Lng
 }
 
-func (d *DeviceState) UpdateOtherData1(packet []byte) {
+func (d *DeviceState) UpdateOtherData1(p unolink.OtherData1Packet) {
 	d.Counter.NumOtherData1++
-	d.Time = binary.LittleEndian.Uint32(append(packet[4:7], []byte{0x00}...))
-	d.PeCounter = binary.LittleEndian.Uint16(packet[7:9])
-	d.Acc = binary.LittleEndian.Uint16(packet[9:11])
-	d.Dec = binary.LittleEndian.Uint16(packet[11:13])
-	d.Jump = binary.LittleEndian.Uint16(packet[13:15])
-	d.Impact = binary.LittleEndian.Uint16(packet[15:17])
+	d.Time = p.Time
+	d.PeCounter = p.PeCounter
+	d.Acc = p.Acc
+	d.Dec = p.Dec
+	d.Jump = p.Jump
+	d.Impact = p.Impact
 }
 
-func (d *DeviceState) UpdateOtherData2(packet []byte) {
+func (d *DeviceState) UpdateOtherData2(p unolink.OtherData2Packet) {
 	d.Counter.NumOtherData2++
-	d.Time = binary.LittleEndian.Uint32(append(packet[4:7], []byte{0x00}...))
-	d.CumDistance[0] = binary.LittleEndian.Uint32(append(packet[7:10], []byte{0x00}...))
-	d.CumDistance[1] = binary.LittleEndian.Uint32(append(packet[10:13], []byte{0x00}...))
-	d.CumDistance[2] = binary.LittleEndian.Uint32(append(packet[13:16], []byte{0x00}...))
-	d.CumDistance[3] = binary.LittleEndian.Uint32(append(packet[16:19], []byte{0x00}...))
-	d.CumDistance[4] = binary.LittleEndian.Uint32(append(packet[19:22], []byte{0x00}...))
+	d.Time = p.Time
+	d.CumDistance = p.CumDistance
 }
 
-func (d *DeviceState) UpdateOtherData3(packet []byte) {
+func (d *DeviceState) UpdateOtherData3(p unolink.OtherData3Packet) {
 	d.Counter.NumOtherData3++
-	d.Time = binary.LittleEndian.Uint32(append(packet[4:7], []byte{0x00}...))
-	d.Hmld = binary.LittleEndian.Uint32(append(packet[7:10], []byte{0x00}...))
+	d.Time = p.Time
+	d.Hmld = p.Hmld
 }
 
 func GetDevice(addr RadioAddress) *DeviceState {
@@ -183,40 +203,194 @@ func GetDevice(addr RadioAddress) *DeviceState {
     return nil
 }
 
-func UpdateDevices() {
-    for i := range List {
-        addr, err := RadioAddressFromString(List[i].Id)
+// listed reports whether the last list of base contains the device.
+func listed(base string, id string) bool {
+	for _, d := range Lists[base] {
+		if strings.EqualFold(d.Id, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Listed reports whether any base station lists the device.
+func Listed(id string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	for base := range Lists {
+		if listed(base, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateDevices merges the device list just received from base. A device
+// moves to base when its previous station does not list it anymore.
+func UpdateDevices(base string, list []ListDevices) {
+    mu.Lock()
+    defer mu.Unlock()
+    Lists[base] = list
+    for i := range list {
+        addr, err := RadioAddressFromString(list[i].Id)
         if err != nil {
-            fmt.Println("Error parsing radio address:", err)
+            logging.Warn("cannot parse radio address", "base", base, "id", list[i].Id, "err", err)
             continue
         }
         var dev = GetDevice(addr)
         if dev == nil {
             dev = CreateDevice(addr)
         }
+        if dev.Base == "" || (dev.Base != base && !listed(dev.Base, addr.String())) {
+            if dev.Base != "" {
+                logging.Info("device moved", "device", addr.String(), "from", dev.Base, "to", base)
+            }
+            dev.Base = base
+        }
+        if dev.Base != base {
+            continue
+        }
+
+        dev.Firmware = list[i].Version
 
-        b := strings.Replace(List[i].Batt, "%", "", 1)
+        b := strings.Replace(list[i].Batt, "%", "", 1)
         batt64, err := strconv.ParseUint(b, 10, 64)
         if err != nil {
-            fmt.Println("Error parsing battery value:", err)
+            logging.Warn("cannot parse battery value", "base", base, "id", list[i].Id, "batt", list[i].Batt, "err", err)
             continue
         }
         dev.Battery = uint8(batt64)
     }
+
+    // devices no longer listed here move to a station still listing them
+    for i := range Devices {
+        id := Devices[i].Id.String()
+        if Devices[i].Base != base || listed(base, id) {
+            continue
+        }
+        for other := range Lists {
+            if other != base && listed(other, id) {
+                logging.Info("device moved", "device", id, "from", base, "to", other)
+                Devices[i].Base = other
+                break
+            }
+        }
+    }
+}
+
+// UpdateMapping stores the telemetry mapping of base and rebuilds the merged
+// TelemetryMapping. When two stations map the same device, the slot of the
+// station owning it wins.
+func UpdateMapping(base string, mapping map[string]uint8) {
+	mu.Lock()
+	defer mu.Unlock()
+	Mappings[base] = mapping
+	merged := map[string]uint8{}
+	for b, m := range Mappings {
+		for id, slot := range m {
+			if _, dup := merged[id]; dup && owner(id) != b {
+				continue
+			}
+			merged[id] = slot
+		}
+	}
+	TelemetryMapping = merged
 }
 
-type ListDevices struct {
-	Batt    string `json:"batt"`
-	Id      string `json:"code"`
-	Version string `json:"fmw"`
+// Owner returns the base station a device is attached to, or "" if unknown.
+func Owner(id string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return owner(id)
 }
 
+func owner(id string) string {
+	addr, err := RadioAddressFromString(id)
+	if err != nil {
+		return ""
+	}
+	if dev := GetDevice(addr); dev != nil {
+		return dev.Base
+	}
+	return ""
+}
+
+// Snapshot returns a copy of every known device.
+func Snapshot() []DeviceState {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]DeviceState(nil), Devices...)
+}
+
+// ListedSnapshot returns a copy of every device a base station lists.
+func ListedSnapshot() []DeviceState {
+	mu.Lock()
+	defer mu.Unlock()
+	var devices []DeviceState
+	for _, d := range Devices {
+		if listed(d.Base, d.Id.String()) {
+			devices = append(devices, d)
+		}
+	}
+	return devices
+}
+
+// Lookup returns a copy of a device by its ID.
+func Lookup(id string) (DeviceState, bool) {
+	addr, err := RadioAddressFromString(id)
+	if err != nil {
+		return DeviceState{}, false
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if dev := GetDevice(addr); dev != nil {
+		return *dev, true
+	}
+	return DeviceState{}, false
+}
+
+// Mapping returns a copy of the merged telemetry mapping.
+func Mapping() map[string]uint8 {
+	mu.Lock()
+	defer mu.Unlock()
+	mapping := make(map[string]uint8, len(TelemetryMapping))
+	for id, slot := range TelemetryMapping {
+		mapping[id] = slot
+	}
+	return mapping
+}
+
+// BaseMappings returns a copy of the telemetry mapping of every base station.
+func BaseMappings() map[string]map[string]uint8 {
+	mu.Lock()
+	defer mu.Unlock()
+	bases := make(map[string]map[string]uint8, len(Mappings))
+	for base, m := range Mappings {
+		mapping := make(map[string]uint8, len(m))
+		for id, slot := range m {
+			mapping[id] = slot
+		}
+		bases[base] = mapping
+	}
+	return bases
+}
+
+// Slot returns the telemetry slot of a device, if it has one.
+func Slot(id string) (uint8, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	slot, ok := TelemetryMapping[id]
+	return slot, ok
+}
+
+type ListDevices = unolink.DeviceInfo
+
 func CreateDevice(addr [3]uint8) *DeviceState {
 	device := DeviceState{
 		Id: addr,
         Slot: 0,
         LiveOn: false,
-        Battery: 255,
+        Battery: BATTERY_UNKNOWN,
 		Counter: PacketCounter{
 			NumInstantaneous: 0,
 			NumCumulative:    0,
@@ -245,33 +419,45 @@ func CreateDevice(addr [3]uint8) *DeviceState {
 		Lng:           0,
 	}
 	Devices = append(Devices, device)
-	return &device
-}
-
-func updatePacket(last *uint32, current []uint8) {
-	*last = binary.LittleEndian.Uint32(current)
+	return &Devices[len(Devices)-1]
 }
 
-func DecodePacket(packet []byte) DeviceState {
+// DecodePacket decodes a packet received from base and applies it to its
+// device, returning the device and the decoded packet. Packets that cannot
+// be decoded only refresh LastSeen and are reported through the error.
+func DecodePacket(base string, packet []byte) (DeviceState, unolink.Packet, error) {
+	mu.Lock()
+	defer mu.Unlock()
 	deviceID := RadioAddress{packet[3], packet[2], packet[1]}
 	device := GetDevice(deviceID)
 	if device == nil {
 		device = CreateDevice(deviceID)
 	}
+	if device.Base != base {
+		if device.Base != "" {
+			logging.Info("device moved", "device", deviceID.String(), "from", device.Base, "to", base)
+		}
+		device.Base = base
+	}
+	device.LastSeen = time.Now()
 
-	switch packet[0] {
-	case Cumulative:
-        device.UpdateCumulative(packet[:])
-	case Instantaneous:
-        device.UpdateInstantaneous(packet[:])
-	case Position:
-        device.UpdatePosition(packet[:])
-	case OtherData1:
-        device.UpdateOtherData1(packet[:])
-	case OtherData2:
-        device.UpdateOtherData2(packet[:])
-	case OtherData3:
-        device.UpdateOtherData3(packet[:])
+	decoded, err := unolink.Decode(packet)
+	if err != nil {
+		return *device, nil, err
+	}
+	switch p := decoded.(type) {
+	case unolink.CumulativePacket:
+		device.UpdateCumulative(p)
+	case unolink.InstantaneousPacket:
+		device.UpdateInstantaneous(p)
+	case unolink.PositionPacket:
+		device.UpdatePosition(p)
+	case unolink.OtherData1Packet:
+		device.UpdateOtherData1(p)
+	case unolink.OtherData2Packet:
+		device.UpdateOtherData2(p)
+	case unolink.OtherData3Packet:
+		device.UpdateOtherData3(p)
 	}
-	return *device
+	return *device, decoded, nil
 }
@@ -0,0 +1,51 @@
+package definitions
+
+import "time"
+
+// FIELDS lists the metrics of a device as exposed outside the client, in the
+// order they are exported. ID and base station are not part of it.
+var FIELDS = []string{
+	"battery", "firmware", "last_seen", "time",
+	"speed", "hrm", "power", "vo2",
+	"energy", "distance", "equiv_distance", "tag_id",
+	"pe_counter", "acc", "dec", "jump", "impact",
+	"hmld", "cum_distance", "lat", "lng",
+	"packets",
+}
+
+// Fields returns the metrics of the device by name. Battery is nil until the
+// device list has been read and last_seen until a packet was received.
+func (d DeviceState) Fields() map[string]any {
+	var battery any
+	if d.Battery != BATTERY_UNKNOWN {
+		battery = d.Battery
+	}
+	var lastSeen any
+	if !d.LastSeen.IsZero() {
+		lastSeen = d.LastSeen.Format(time.RFC3339Nano)
+	}
+	return map[string]any{
+		"battery":        battery,
+		"firmware":       d.Firmware,
+		"last_seen":      lastSeen,
+		"time":           d.Time,
+		"speed":          d.Speed,
+		"hrm":            d.Hrm,
+		"power":          d.Power,
+		"vo2":            d.Vo2,
+		"energy":         d.Energy,
+		"distance":       d.Distance,
+		"equiv_distance": d.EquivDistance,
+		"tag_id":         d.TagId,
+		"pe_counter":     d.PeCounter,
+		"acc":            d.Acc,
+		"dec":            d.Dec,
+		"jump":           d.Jump,
+		"impact":         d.Impact,
+		"hmld":           d.Hmld,
+		"cum_distance":   d.CumDistance,
+		"lat":            d.Lat,
+		"lng":            d.Lng,
+		"packets":        d.Counter.Total(),
+	}
+}
@@ -0,0 +1,121 @@
+package display
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"unolink-client/alerts"
+	"unolink-client/config"
+	"unolink-client/training"
+	"unolink-client/units"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	// ALERTS_SHOWN is how many alerts the panel shows, the active ones
+	// first, and ALERTS_KEPT how many are remembered.
+	ALERTS_SHOWN = 5
+	ALERTS_KEPT  = 50
+	// BELL_DURATION is how long the view rings the bell, long enough for
+	// the renderer to write it.
+	BELL_DURATION = 200 * time.Millisecond
+)
+
+var (
+	infoAlertStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("39"))
+	clearedAlertStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("243"))
+)
+
+// bellDoneMsg stops the bell rung by raise.
+type bellDoneMsg struct{}
+
+// raise records a new alert, ringing the bell when configured.
+func (m *model) raise(a alerts.Alert) tea.Cmd {
+	m.alerts = append([]alerts.Alert{a}, m.alerts...)
+	if len(m.alerts) > ALERTS_KEPT {
+		m.alerts = m.alerts[:ALERTS_KEPT]
+	}
+	if !config.Current.Alerts.Bell {
+		return nil
+	}
+	m.bell = true
+	return tea.Tick(BELL_DURATION, func(time.Time) tea.Msg { return bellDoneMsg{} })
+}
+
+// bellView rings the bell while it is on. The renderer only writes the lines
+// that changed, so the bell rings as the first line is rewritten for it
+// rather than on every frame.
+func (m model) bellView() string {
+	if m.bell {
+		return "\a"
+	}
+	return ""
+}
+
+// clear marks an alert as cleared.
+func (m *model) clear(a alerts.Alert) {
+	for i := range m.alerts {
+		if m.alerts[i].Rule == a.Rule && m.alerts[i].Device == a.Device && m.alerts[i].Raised.Equal(a.Raised) {
+			m.alerts[i] = a
+			return
+		}
+	}
+}
+
+// alertsView shows the active alerts, the most severe and latest first,
+// then the latest cleared ones. It is empty until an alert was raised.
+func (m model) alertsView() string {
+	if len(m.alerts) == 0 {
+		return ""
+	}
+	var shown []alerts.Alert
+	for _, severity := range []alerts.Severity{alerts.SeverityCritical, alerts.SeverityWarning, alerts.SeverityInfo} {
+		for _, a := range m.alerts {
+			if a.Active() && a.Severity == severity {
+				shown = append(shown, a)
+			}
+		}
+	}
+	active := len(shown)
+	for _, a := range m.alerts {
+		if !a.Active() {
+			shown = append(shown, a)
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Alerts (%d active)\n", active)
+	for i, a := range shown {
+		if i == ALERTS_SHOWN {
+			fmt.Fprintf(&b, "  and %d more\n", len(shown)-ALERTS_SHOWN)
+			break
+		}
+		b.WriteString("  " + alertLine(a) + "\n")
+	}
+	return b.String()
+}
+
+func alertLine(a alerts.Alert) string {
+	device := strings.ToUpper(a.Device)
+	if name := training.Player(a.Device).Name; name != "" {
+		device += " " + name
+	}
+	line := fmt.Sprintf("%s  %-8s %s  %s", a.Raised.Format(time.TimeOnly), a.Severity, device, a.Rule)
+	if a.Field != "" {
+		u := units.Field(a.Field)
+		line += " (" + strings.TrimSpace(strconv.FormatFloat(a.Value, 'f', u.Decimals, 64)+" "+u.Symbol) + ")"
+	}
+	if !a.Active() {
+		return clearedAlertStyle.Render(line + "  cleared after " + a.Cleared.Sub(a.Raised).Round(time.Second).String())
+	}
+	switch a.Severity {
+	case alerts.SeverityCritical:
+		return criticalBannerStyle.Render(line)
+	case alerts.SeverityWarning:
+		return warningBannerStyle.Render(line)
+	}
+	return infoAlertStyle.Render(line)
+}
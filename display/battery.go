@@ -0,0 +1,125 @@
+package display
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"unolink-client/battery"
+	"unolink-client/config"
+	def "unolink-client/definitions"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// BANNER_DEVICES is how many low devices the battery banner names.
+const BANNER_DEVICES = 4
+
+var (
+	criticalBannerStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Bold(true)
+	warningBannerStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+)
+
+// batteryCell colors a battery level by its status.
+func batteryCell(level uint8) string {
+	if level == def.BATTERY_UNKNOWN {
+		return "-"
+	}
+	text := fmt.Sprintf("%d", level)
+	switch battery.StatusOf(level) {
+	case battery.StatusCritical:
+		return colored(styleInvalid, text)
+	case battery.StatusWarning:
+		return colored(styleWarning, text)
+	}
+	return text
+}
+
+// remainingCell shows how long the battery of a device should last.
+func (m model) remainingCell(id string) string {
+	b, ok := m.batteries[id]
+	if !ok {
+		return "-"
+	}
+	text := hours(b.Remaining)
+	if b.Remaining < config.Current.Battery.Session {
+		return colored(styleWarning, text)
+	}
+	return text
+}
+
+// batteryBanner names the devices whose battery is low, the critical ones
+// first, and is empty when there are none.
+func (m model) batteryBanner() string {
+	var critical, warning []string
+	for _, b := range m.low {
+		switch b.Status {
+		case battery.StatusCritical:
+			critical = append(critical, fmt.Sprintf("%s %d%%", b.ID, b.Level))
+		case battery.StatusWarning:
+			warning = append(warning, fmt.Sprintf("%s %d%%", b.ID, b.Level))
+		}
+	}
+	if len(critical) == 0 && len(warning) == 0 {
+		return ""
+	}
+	var line string
+	if len(critical) > 0 {
+		line = criticalBannerStyle.Render("Battery critical: " + bannerList(critical))
+	}
+	if len(warning) > 0 {
+		if line != "" {
+			line += "   "
+		}
+		line += warningBannerStyle.Render("Battery low: " + bannerList(warning))
+	}
+	return line + "\n"
+}
+
+func bannerList(devices []string) string {
+	if len(devices) > BANNER_DEVICES {
+		return strings.Join(devices[:BANNER_DEVICES], ", ") + fmt.Sprintf(" and %d more", len(devices)-BANNER_DEVICES)
+	}
+	return strings.Join(devices, ", ")
+}
+
+// kitCheckWarning tells how many devices may not last the planned session,
+// empty when all should.
+func (m model) kitCheckWarning() string {
+	if len(m.short) > 0 {
+		return fmt.Sprintf("Kit check: %d devices may not last %s (b for details)", len(m.short), hours(config.Current.Battery.Session))
+	}
+	return ""
+}
+
+// kitCheckView lists the devices unlikely to last the planned session, until
+// it is dismissed.
+func (m model) kitCheckView() string {
+	session := config.Current.Battery.Session
+	short := m.short
+	var b strings.Builder
+	fmt.Fprintf(&b, "Kit check for a %s session\n\n", hours(session))
+	if len(short) == 0 {
+		b.WriteString("Every device should last the session\n")
+	} else {
+		w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tBattery\tRate (%/h)\tRemaining\tEstimate")
+		for _, d := range short {
+			rate, estimate := "-", "runtime"
+			if d.Rate > 0 {
+				rate, estimate = fmt.Sprintf("%.1f", d.Rate), "discharge"
+			}
+			fmt.Fprintf(w, "%s\t%d%%\t%s\t%s\t%s\n", d.ID, d.Level, rate, hours(d.Remaining), estimate)
+		}
+		w.Flush()
+	}
+	b.WriteString("\nenter: back to the devices")
+	return baseStyle.Render(b.String()) + "\n"
+}
+
+// hours formats a duration as hours and minutes.
+func hours(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh%02d", int(d.Hours()), int(d.Minutes())%60)
+}
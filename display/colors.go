@@ -0,0 +1,71 @@
+package display
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// The table measures and truncates its cells as plain text, which breaks on
+// escape sequences. Colored cells are marked with zero width characters
+// instead, holding the index of their style, and colorize styles them once
+// the table is rendered.
+const (
+	markStart = "\u200b"
+	markIndex = "\u200c"
+	markText  = "\u200d"
+	markEnd   = "\u200e"
+)
+
+// cell styles, by index in the marks
+const (
+	styleInvalid = iota
+	styleWarning
+	styleUp
+	styleDown
+	styleZone // the first heart rate zone, followed by the others
+)
+
+var cellStyles = []lipgloss.Style{
+	styleInvalid:  lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Bold(true),
+	styleWarning:  lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+	styleUp:       lipgloss.NewStyle().Foreground(lipgloss.Color("46")),
+	styleDown:     lipgloss.NewStyle().Foreground(lipgloss.Color("196")),
+	styleZone:     lipgloss.NewStyle().Foreground(lipgloss.Color("245")),
+	styleZone + 1: lipgloss.NewStyle().Foreground(lipgloss.Color("39")),
+	styleZone + 2: lipgloss.NewStyle().Foreground(lipgloss.Color("46")),
+	styleZone + 3: lipgloss.NewStyle().Foreground(lipgloss.Color("226")),
+	styleZone + 4: lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+	styleZone + 5: lipgloss.NewStyle().Foreground(lipgloss.Color("196")),
+}
+
+var (
+	markedCell = regexp.MustCompile(markStart + "(" + markIndex + "*)" + markText + "([^" + markEnd + "]*)" + markEnd)
+	// marks left over by a truncated cell
+	strayMarks = strings.NewReplacer(markStart, "", markIndex, "", markText, "", markEnd, "")
+)
+
+// colored marks text to be rendered with the style at index style.
+func colored(style int, text string) string {
+	return markStart + strings.Repeat(markIndex, style) + markText + text + markEnd
+}
+
+// zoneStyle returns the style of a heart rate zone, the last one standing
+// for any higher zone.
+func zoneStyle(zone int) int {
+	return min(styleZone+zone, len(cellStyles)-1)
+}
+
+// colorize renders the cells marked by colored in view.
+func colorize(view string) string {
+	view = markedCell.ReplaceAllStringFunc(view, func(cell string) string {
+		m := markedCell.FindStringSubmatch(cell)
+		style := strings.Count(m[1], markIndex)
+		if style >= len(cellStyles) {
+			return m[2]
+		}
+		return cellStyles[style].Render(m[2])
+	})
+	return strayMarks.Replace(view)
+}
@@ -3,16 +3,24 @@ package display
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
-	"sync"
 	"time"
 
+	"unolink-client/alerts"
+	"unolink-client/battery"
+	"unolink-client/config"
 	conn "unolink-client/connection"
 	def "unolink-client/definitions"
+	"unolink-client/inventory"
+	"unolink-client/logging"
+	"unolink-client/training"
+	"unolink-client/units"
 
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
@@ -29,8 +37,17 @@ type keyMap struct {
 	Shutdown        key.Binding
 	ShutdownAll     key.Binding
 	ToggleTelemetry key.Binding
-	TelemetryParty  key.Binding
+	GroupTelemetry  key.Binding
 	StopTelemetry   key.Binding
+	Retry           key.Binding
+	StartSession    key.Binding
+	Lap             key.Binding
+	StopSession     key.Binding
+	KitCheck        key.Binding
+	RankMetric      key.Binding
+	Compact         key.Binding
+	AssignSlot      key.Binding
+	Rebalance       key.Binding
 	Quit            key.Binding
 }
 
@@ -49,7 +66,7 @@ func (k keyMap) ShortHelp() []key.Binding {
 		// k.Shutdown,
 		// k.ShutdownAll,
 		// k.ToggleTelemetry,
-		// k.TelemetryParty,
+		// k.GroupTelemetry,
 		// k.StopTelemetry,
 		k.Quit,
 	}
@@ -62,26 +79,103 @@ func (k keyMap) FullHelp() [][]key.Binding {
 		{k.Up, k.Down, k.ToggleContent},
 		{k.Activate, k.Deactivate, k.Shutdown},
 		{k.ActivateAll, k.DeactivateAll, k.ShutdownAll},
-		{k.ToggleTelemetry, k.TelemetryParty, k.StopTelemetry},
+		{k.ToggleTelemetry, k.GroupTelemetry, k.StopTelemetry},
+		{k.StartSession, k.Lap, k.StopSession},
+		{k.Retry, k.KitCheck},
+		{k.RankMetric, k.Compact},
+		{k.AssignSlot, k.Rebalance},
 	}
 }
 
+// model only reads what it receives as messages: the devices from the
+// connection layer, and the batteries, commands and inventory kept by other
+// packages from a shared message taken every SHARED_REFRESH outside the
+// render path. It rebuilds the table at most once per render interval.
 type model struct {
 	ctx     context.Context
-	wg      *sync.WaitGroup
-	errCh   <-chan error
 	table   table.Model
 	keys    keyMap
 	help    help.Model
 	log     string
-	devices *[]def.DeviceState
+	devices []def.DeviceState
+	index   map[def.RadioAddress]int
+	mapping map[string]uint8
+	bases   map[string]map[string]uint8 // telemetry mapping of every base station
+	links   map[string]map[string]bool  // base -> link -> up
 	cursor  int
-	content bool // true = states, false = counters
+	content content
+	dirty   bool
+
+	// the running session, the summary of the last one until dismissed and
+	// the name being typed for a new session or lap
+	session    *training.Session
+	summary    *training.Session
+	prompt     textinput.Model
+	prompting  prompt
+	slotDevice string // the device a slot is typed for
+
+	kitCheck bool // the kit check is shown until dismissed
+
+	alerts []alerts.Alert // the latest first
+	bell   bool           // the view rings the bell until bellDoneMsg
+
+	// the state of the other packages as of the last sharedMsg
+	batteries map[string]battery.Device // by device ID
+	low       []battery.Device          // the batteries under the warning level, by ID
+	short     []battery.Device          // the batteries not lasting the planned session
+	commands  map[string]conn.Command   // by device ID
+	inventory []inventory.Entry
+
+	// the metric the leaderboard ranks by, as index in RANK_METRICS, and the
+	// positions of RANK_WINDOW ago
+	rank         int
+	compact      bool
+	rankBaseline map[string]int
+	rankSince    time.Time
+
+	// packet counters are shown per window of UIRefresh
+	windowStart  time.Time
+	windowLength time.Duration
+	baseline     map[def.RadioAddress]def.PacketCounter
+	window       map[def.RadioAddress]def.PacketCounter
 }
 
 type tickMsg time.Time
 
-const UpdateInterval = 1 * time.Second
+// SHARED_REFRESH is how often the state of the other packages is taken.
+const SHARED_REFRESH = 500 * time.Millisecond
+
+// sharedMsg carries a snapshot of the state of the other packages.
+type sharedMsg struct {
+	batteries []battery.Device
+	commands  map[string]conn.Command
+	inventory []inventory.Entry
+}
+
+func shared() sharedMsg {
+	msg := sharedMsg{batteries: battery.Snapshot(), commands: conn.Commands()}
+	if config.Current.Inventory.File != "" {
+		msg.inventory = inventory.Snapshot()
+	}
+	return msg
+}
+
+// share stores the snapshot of the other packages in the model, with what
+// the banner and the kit check derive from it.
+func (m *model) share(msg sharedMsg) {
+	m.batteries = make(map[string]battery.Device, len(msg.batteries))
+	m.low = nil
+	for _, b := range msg.batteries {
+		m.batteries[b.ID] = b
+		if b.Status > battery.StatusOK {
+			m.low = append(m.low, b)
+		}
+	}
+	m.short = battery.KitCheck(msg.batteries, config.Current.Battery.Session)
+	m.commands = msg.commands
+	m.inventory = msg.inventory
+	m.dirty = true
+}
 
 var (
 	// styles
@@ -140,9 +234,45 @@ var keys = keyMap{
 		key.WithKeys("s"),
 		key.WithHelp("s", "stop telemetry"),
 	),
-	TelemetryParty: key.NewBinding(
+	GroupTelemetry: key.NewBinding(
 		key.WithKeys("t"),
-		key.WithHelp("t", "telemetry for all"),
+		key.WithHelp("t", "telemetry for a group"),
+	),
+	Retry: key.NewBinding(
+		key.WithKeys("r"),
+		key.WithHelp("r", "retry failed command"),
+	),
+	StartSession: key.NewBinding(
+		key.WithKeys("n"),
+		key.WithHelp("n", "new session"),
+	),
+	Lap: key.NewBinding(
+		key.WithKeys("l"),
+		key.WithHelp("l", "new lap"),
+	),
+	StopSession: key.NewBinding(
+		key.WithKeys("e"),
+		key.WithHelp("e", "end session"),
+	),
+	KitCheck: key.NewBinding(
+		key.WithKeys("b"),
+		key.WithHelp("b", "battery kit check"),
+	),
+	RankMetric: key.NewBinding(
+		key.WithKeys("m"),
+		key.WithHelp("m", "leaderboard metric"),
+	),
+	Compact: key.NewBinding(
+		key.WithKeys("c"),
+		key.WithHelp("c", "compact leaderboard"),
+	),
+	AssignSlot: key.NewBinding(
+		key.WithKeys("g"),
+		key.WithHelp("g", "assign slot"),
+	),
+	Rebalance: key.NewBinding(
+		key.WithKeys("G"),
+		key.WithHelp("shift + g", "rebalance slots"),
 	),
 	Quit: key.NewBinding(
 		key.WithKeys("q", "esc", "ctrl+c"),
@@ -150,10 +280,11 @@ var keys = keyMap{
 	),
 }
 
-func initalModel(ctx context.Context, wg *sync.WaitGroup, errCh <-chan error) model {
+func initalModel(ctx context.Context) model {
 	columns := []table.Column{
 		{Title: "Live", Width: 4},
 		{Title: "ID", Width: 6},
+		{Title: "Base", Width: 10},
 		{Title: "Slot", Width: 4},
 		{Title: "IN", Width: 3},
 		{Title: "CU", Width: 3},
@@ -162,6 +293,7 @@ func initalModel(ctx context.Context, wg *sync.WaitGroup, errCh <-chan error) mo
 		{Title: "O3", Width: 3},
 		{Title: "TOT", Width: 3},
 		{Title: "Elapsed", Width: 12},
+		{Title: "Command", Width: 18},
 	}
 
 	t := table.New(
@@ -183,35 +315,69 @@ func initalModel(ctx context.Context, wg *sync.WaitGroup, errCh <-chan error) mo
 	t.SetStyles(s)
 
 	var h = help.New()
-	h.ShowAll = false
+	h.ShowAll = config.Current.UI.ShowHelp
 	h.Width = t.Width()
 
-	return model{
-		ctx:     ctx,
-		wg:      wg,
-		errCh:   errCh,
-		table:   t,
-		keys:    keys,
-		help:    h,
-		log:     "Starting the client...",
-		devices: &def.Devices,
-		content: false,
+	m := model{
+		ctx:         ctx,
+		table:       t,
+		keys:        keys,
+		help:        h,
+		log:         "Starting the client...",
+		index:       map[def.RadioAddress]int{},
+		mapping:     def.Mapping(),
+		bases:       def.BaseMappings(),
+		links:       map[string]map[string]bool{},
+		content:     parseContent(config.Current.UI.Content),
+		rank:        parseRank(config.Current.UI.Rank),
+		prompt:      textinput.New(),
+		windowStart: time.Now(),
+		baseline:    map[def.RadioAddress]def.PacketCounter{},
+		window:      map[def.RadioAddress]def.PacketCounter{},
+	}
+	for _, d := range def.Snapshot() {
+		m.upsert(d)
 	}
+	m.share(shared())
+	if s, ok := training.Running(); ok {
+		m.session = &s
+	}
+	return m
+}
+
+// upsert stores the latest state of a device, keeping the table order.
+func (m *model) upsert(d def.DeviceState) {
+	if i, ok := m.index[d.Id]; ok {
+		m.devices[i] = d
+	} else {
+		m.index[d.Id] = len(m.devices)
+		m.devices = append(m.devices, d)
+		m.baseline[d.Id] = d.Counter
+	}
+	m.dirty = true
+}
+
+// rollWindow closes the current packet counter window once it has lasted
+// UIRefresh.
+func (m *model) rollWindow() {
+	if time.Since(m.windowStart) < config.Current.Intervals.UIRefresh {
+		return
+	}
+	for _, d := range m.devices {
+		m.window[d.Id] = d.Counter.Sub(m.baseline[d.Id])
+		m.baseline[d.Id] = d.Counter
+	}
+	m.windowLength = time.Since(m.windowStart)
+	m.windowStart = time.Now()
+	m.dirty = true
 }
 
 var baseStyle = lipgloss.NewStyle().
 	BorderStyle(lipgloss.NormalBorder()).
 	BorderForeground(lipgloss.Color("247"))
 
-var start = time.Now()
-
 func (m model) Init() tea.Cmd {
-	m.table = m.updateTable()
-	return tickCmd(m)
-}
-
-func (m model) removeDevice(index int) {
-	*m.devices = append((*m.devices)[:index], (*m.devices)[index+1:]...)
+	return tickCmd()
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -224,11 +390,6 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 
 	select {
-	// case <-m.ctx.Done():
-	case err := <-m.errCh:
-        m.log = lipgloss.NewStyle().Foreground(lipgloss.Color("1")).Render("Terminating the execution due to the following error:\n" + err.Error())
-		// fmt.Println("Closing the table")
-		return m, tea.Quit
 	case <-m.ctx.Done():
 		m.log = "Terminating the execution"
 		return m, tea.Quit
@@ -239,14 +400,85 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.help.Width = msg.Width
 
 		case tea.KeyMsg:
+			if m.prompting != promptNone {
+				return m.updatePrompt(msg)
+			}
+			if m.summary != nil && (msg.String() == "enter" || msg.String() == "esc") {
+				m.summary = nil
+				return m, nil
+			}
+			if m.kitCheck && (msg.String() == "enter" || msg.String() == "esc") {
+				m.kitCheck = false
+				return m, nil
+			}
 			switch msg.String() {
 			case "?":
 				m.help.ShowAll = !m.help.ShowAll
 			case "tab":
-				m.content = !m.content
+				m.content = m.content.next()
 				m.table = m.updateTable()
+			case "n":
+				if m.session != nil {
+					m.log = "Session " + m.session.Name + " is already running"
+					return m, nil
+				}
+				if warning := m.kitCheckWarning(); warning != "" {
+					m.log = warning
+				}
+				return m, m.startPrompt(promptSession)
+			case "b":
+				logging.Info("user action", "action", "kit_check")
+				m.kitCheck = true
+			case "m":
+				if m.content == contentLeaderboard {
+					m.nextRank()
+					m.table = m.updateTable()
+				}
+			case "c":
+				if m.content == contentLeaderboard {
+					m.compact = !m.compact
+					m.table = m.updateTable()
+				}
+			case "g":
+				if m.content != contentSlots {
+					return m, nil
+				}
+				row := m.table.SelectedRow()
+				if row == nil {
+					m.log = "There are no devices in telemetry"
+					return m, nil
+				}
+				m.slotDevice = row[1]
+				return m, m.startPrompt(promptSlot)
+			case "G":
+				if m.content != contentSlots {
+					return m, nil
+				}
+				logging.Info("user action", "action", "rebalance_slots")
+				moved, err := conn.Misplaced()
+				switch {
+				case err != nil:
+					m.log = err.Error()
+				case len(moved) == 0:
+					m.log = "Every device is in a slot of its own"
+				default:
+					m.log = "Rebalancing devices: " + strings.Join(moved, ", ")
+					go conn.Rebalance(moved)
+				}
+			case "l":
+				if m.session == nil {
+					m.log = "No session is running"
+					return m, nil
+				}
+				return m, m.startPrompt(promptLap)
+			case "e":
+				logging.Info("user action", "action", "stop_session")
+				if err := training.Stop(); err != nil {
+					m.log = err.Error()
+				}
 			case "q", "ctrl+c":
                 m.log = lipgloss.NewStyle().Foreground(lipgloss.Color("1")).Render("Wait for termination")
+				logging.Info("user action", "action", "quit")
 				return m, tea.Quit
 			case "a":
 				return m, tea.Batch(
@@ -255,6 +487,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						if row == nil {
 							return tea.Printf("There are no devices")
 						} else {
+							logging.Info("user action", "action", "activate", "device", row[1])
 							go conn.Activate([]string{row[1]})
 							m.log = "Activating device: " + row[1]
 							return nil
@@ -268,6 +501,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						if len(rows) == 0 {
 							return tea.Printf("There are no devices")
 						} else {
+							logging.Info("user action", "action", "activate_all")
 							go conn.Activate(extractAddressesFromRows(rows))
 							m.log = "Activating all devices"
 							return nil
@@ -281,6 +515,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						if row == nil {
 							return tea.Printf("There are no devices")
 						} else {
+							logging.Info("user action", "action", "deactivate", "device", row[1])
 							go conn.Deactivate([]string{row[1]})
 							m.log = "Deactivating device: " + row[1]
 							return nil
@@ -294,6 +529,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						if len(rows) == 0 {
 							return tea.Printf("There are no devices")
 						} else {
+							logging.Info("user action", "action", "deactivate_all")
 							go conn.Deactivate(extractAddressesFromRows(rows))
 							m.log = "Deactivating all devices"
 							return nil
@@ -307,6 +543,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						if row == nil {
 							return tea.Printf("Nothing is selected")
 						} else {
+							logging.Info("user action", "action", "shutdown", "device", row[1])
 							go conn.Shutdown([]string{row[1]})
 							m.log = "Shutting down device: " + row[1]
 							// m.removeDevice(m.table.Cursor())
@@ -321,31 +558,39 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						if len(rows) == 0 {
 							return tea.Printf("There are no devices")
 						} else {
+							logging.Info("user action", "action", "shutdown_all")
 							go conn.Shutdown(extractAddressesFromRows(rows))
 							m.log = "Shutting down all devices"
-							// (*m.devices) = []def.DeviceState{}
+							// m.devices = []def.DeviceState{}
 							return nil
 						}
 					}(),
 				)
 			case "t":
-				m.log = "Starting telemetry for all devices"
-				go conn.TelemetryParty()
-				// return m, tea.Batch(
-				// 	func() tea.Cmd {
-				// 		var rows = m.table.Rows()
-				// 		if len(rows) == 0 {
-				// 			return tea.Printf("There are no devices")
-				// 		} else {
-				// 			go conn.StartTelemetry(extractAddressesFromRows(rows))
-				// 			m.log = "Starting telemetry for all devices"
-				// 			return nil
-				// 		}
-				// 	}(),
-				// )
+				if groups := training.Groups(); len(groups) > 0 {
+					m.log = "Groups: " + GROUP_ALL + ", " + strings.Join(groups, ", ")
+				}
+				return m, m.startPrompt(promptGroup)
 			case "s":
 				m.log = "Stopping telemetry for all devices"
+				logging.Info("user action", "action", "stop_telemetry")
 				go conn.StopTelemetry()
+			case "r":
+				return m, tea.Batch(
+					func() tea.Cmd {
+						var row = m.table.SelectedRow()
+						if row == nil {
+							return tea.Printf("There are no devices")
+						} else if conn.Retry(row[1]) {
+							logging.Info("user action", "action", "retry", "device", row[1])
+							m.log = "Retrying last command for device: " + row[1]
+							return nil
+						} else {
+							m.log = "No failed command for device: " + row[1]
+							return nil
+						}
+					}(),
+				)
 			case "enter":
 				return m, tea.Batch(
 					func() tea.Cmd {
@@ -354,68 +599,165 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 							return tea.Printf("There are no devices")
 						} else {
 							m.log = "Toggling telemetry for device: " + row[1]
-							go conn.ToggleTelemetry(row[1])
+							logging.Info("user action", "action", "toggle_telemetry", "device", row[1])
+							go conn.ToggleTelemetry(row[1], training.Player(row[1]).VO2Max)
 							return nil
 						}
 					}(),
 				)
 			}
+		case conn.PacketDecoded:
+			m.upsert(msg.Device)
+			return m, nil
+		case conn.DevicesChanged:
+			for _, d := range msg.Devices {
+				m.upsert(d)
+			}
+			return m, nil
+		case sharedMsg:
+			m.share(msg)
+			return m, nil
+		case conn.MappingChanged:
+			m.mapping = msg.Mapping
+			m.bases = msg.Bases
+			m.dirty = true
+			return m, nil
+		case training.SessionStarted:
+			m.session = &msg.Session
+			m.summary = nil
+			m.log = "Session started: " + msg.Session.Name
+			m.dirty = true
+			return m, nil
+		case training.LapStarted:
+			m.session = &msg.Session
+			if lap, ok := msg.Session.CurrentLap(); ok {
+				m.log = "Lap started: " + lap.Name
+			}
+			m.dirty = true
+			return m, nil
+		case training.TotalsChanged:
+			m.session = &msg.Session
+			m.dirty = true
+			return m, nil
+		case training.SessionStopped:
+			m.session = nil
+			m.summary = &msg.Session
+			m.log = "Session ended: " + msg.Session.Name
+			m.dirty = true
+			return m, nil
+		case alerts.Raised:
+			m.log = "Alert: " + msg.Alert.Rule + " for " + strings.ToUpper(msg.Alert.Device)
+			return m, m.raise(msg.Alert)
+		case alerts.Cleared:
+			m.clear(msg.Alert)
+			return m, nil
+		case bellDoneMsg:
+			m.bell = false
+			return m, nil
+		case conn.ConnectionChanged:
+			if m.links[msg.Base] == nil {
+				m.links[msg.Base] = map[string]bool{}
+			}
+			m.links[msg.Base][msg.Link] = msg.Up
+			if !msg.Up && msg.Err != nil {
+				m.log = lipgloss.NewStyle().Foreground(lipgloss.Color("1")).Render(msg.Base + " " + msg.Link + ": " + msg.Err.Error())
+			}
+			return m, nil
 		case tickMsg:
-			m.table = m.updateTable()
-			return m, tickCmd(m)
+			m.rollWindow()
+			m.rollRanks()
+			if m.dirty {
+				m.table = m.updateTable()
+				m.dirty = false
+			}
+			return m, tickCmd()
 		}
 		m.table, cmd = m.table.Update(msg)
 		return m, cmd
 	}
 }
 
+// unitColumn is a column of the metric called name, its title followed by
+// the selected unit and widened to fit it.
+func unitColumn(title, name string, width int) table.Column {
+	title = units.Title(title, name)
+	return table.Column{Title: title, Width: max(width, len(title))}
+}
+
 func (m model) updateTable() table.Model {
 	// fmt.Println("START Rows: ", m.table.Rows())
 	var rows []table.Row
 	var columns []table.Column
-	if m.content {
-		for i := range *m.devices {
-			(*m.devices)[i].Slot, (*m.devices)[i].LiveOn = def.TelemetryMapping[(*m.devices)[i].Id.String()]
+	switch m.content {
+	case contentSession:
+		rows, columns = m.sessionTable()
+		m.table.SetRows(nil)
+		m.table.SetColumns(columns)
+		m.table.SetRows(rows)
+	case contentLeaderboard:
+		rows, columns = m.leaderboardTable()
+		m.table.SetRows(nil)
+		m.table.SetColumns(columns)
+		m.table.SetRows(rows)
+	case contentSlots:
+		rows, columns = m.slotsTable()
+		m.table.SetRows(nil)
+		m.table.SetColumns(columns)
+		m.table.SetRows(rows)
+	case contentInventory:
+		rows, columns = m.inventoryTable()
+		m.table.SetRows(nil)
+		m.table.SetColumns(columns)
+		m.table.SetRows(rows)
+	case contentStates:
+		for i := range m.devices {
+			m.devices[i].Slot, m.devices[i].LiveOn = m.mapping[m.devices[i].Id.String()]
 			row := table.Row{
 				func() string {
-					if (*m.devices)[i].LiveOn {
+					if m.devices[i].LiveOn {
 						return "[✓]"
 					} else {
 						return "[ ]"
 					}
 				}(),
-				strings.ToUpper((*m.devices)[i].Id.String()),
-				fmt.Sprintf("%d", (*m.devices)[i].Slot),
-				fmt.Sprintf("%d", (*m.devices)[i].Battery),
-				fmt.Sprintf("%d", (*m.devices)[i].Time),
-				fmt.Sprintf("%.3f", (*m.devices)[i].Speed),
-				fmt.Sprintf("%d", (*m.devices)[i].Hrm),
-				fmt.Sprintf("%.3f", (*m.devices)[i].Power),
-				fmt.Sprintf("%.3f", (*m.devices)[i].Vo2),
-				fmt.Sprintf("%.3f", (*m.devices)[i].Energy),
-				fmt.Sprintf("%.3f", (*m.devices)[i].Distance),
-				fmt.Sprintf("%.3f", (*m.devices)[i].EquivDistance)}
-			// fmt.Sprintf("%d", (*m.devices)[i].Acc),
-			// fmt.Sprintf("%d", (*m.devices)[i].Dec),
-			// fmt.Sprintf("%d", (*m.devices)[i].Jump),
-			// fmt.Sprintf("%d", (*m.devices)[i].Impact),
-			// fmt.Sprintf("%d", (*m.devices)[i].Hmld)}
+				strings.ToUpper(m.devices[i].Id.String()),
+				m.devices[i].Base,
+				fmt.Sprintf("%d", m.devices[i].Slot),
+				batteryCell(m.devices[i].Battery),
+				m.remainingCell(m.devices[i].Id.String()),
+				fmt.Sprintf("%d", m.devices[i].Time),
+				units.Format("speed", float64(m.devices[i].Speed)),
+				hrmCell(m.devices[i].Id.String(), m.devices[i].Hrm),
+				units.Format("power", float64(m.devices[i].Power)),
+				units.Format("vo2", float64(m.devices[i].Vo2)),
+				units.Format("energy", float64(m.devices[i].Energy)),
+				units.Format("distance", float64(m.devices[i].Distance)),
+				units.Format("equiv_distance", float64(m.devices[i].EquivDistance)),
+				m.commandCell(m.devices[i].Id.String())}
+			// fmt.Sprintf("%d", m.devices[i].Acc),
+			// fmt.Sprintf("%d", m.devices[i].Dec),
+			// fmt.Sprintf("%d", m.devices[i].Jump),
+			// fmt.Sprintf("%d", m.devices[i].Impact),
+			// fmt.Sprintf("%d", m.devices[i].Hmld)}
 			rows = append(rows, row)
-			// (*m.devices)[i].Counter.Clear()
+			// m.devices[i].Counter.Clear()
 		}
 		columns = []table.Column{
 			{Title: "Live", Width: 4},
 			{Title: "ID", Width: 6},
+			{Title: "Base", Width: 10},
 			{Title: "Slot", Width: 4},
 			{Title: "SoC", Width: 3},
+			{Title: "Left", Width: 5},
 			{Title: "Time", Width: 8},
-			{Title: "Speed", Width: 8},
+			unitColumn("Speed", "speed", 8),
 			{Title: "HRM", Width: 3},
-			{Title: "Power", Width: 8},
-			{Title: "VO2", Width: 8},
-			{Title: "Energy", Width: 8},
-			{Title: "Dist", Width: 8},
-			{Title: "EqDist", Width: 8},
+			unitColumn("Power", "power", 8),
+			unitColumn("VO2", "vo2", 8),
+			unitColumn("Energy", "energy", 8),
+			unitColumn("Dist", "distance", 8),
+			unitColumn("EqDist", "equiv_distance", 8),
+			{Title: "Command", Width: 18},
 			// {Title: "Acc", Width: 8},
 			// {Title: "Dec", Width: 8},
 			// {Title: "Jump", Width: 8},
@@ -425,43 +767,47 @@ func (m model) updateTable() table.Model {
 		// update in this order to ensure rows have less elements than columns
 		m.table.SetColumns(columns)
 		m.table.SetRows(rows)
-	} else {
-		for i := range *m.devices {
-			(*m.devices)[i].Slot, (*m.devices)[i].LiveOn = def.TelemetryMapping[(*m.devices)[i].Id.String()]
+	default:
+		for i := range m.devices {
+			m.devices[i].Slot, m.devices[i].LiveOn = m.mapping[m.devices[i].Id.String()]
+			counter := m.window[m.devices[i].Id]
 			// var style = maxPacketsStyle
-			// if (*m.devices)[i].Counter.Total() < 10 {
+			// if m.devices[i].Counter.Total() < 10 {
 			// 	style = fewPacketsStyle
-			// } else if (*m.devices)[i].Counter.Total() < 32 {
+			// } else if m.devices[i].Counter.Total() < 32 {
 			// 	style = enoughPacketsStyle
 			// }
-			// if !(*m.devices)[i].LiveOn {
+			// if !m.devices[i].LiveOn {
 			// 	style = normalStyle
 			// }
 			row := table.Row{
 				func() string {
-					if (*m.devices)[i].LiveOn {
+					if m.devices[i].LiveOn {
 						return "[✓]"
 					} else {
 						return "[ ]"
 					}
 				}(),
-				strings.ToUpper((*m.devices)[i].Id.String()),
-				fmt.Sprintf("%d", (*m.devices)[i].Slot),
-				fmt.Sprintf("%d", (*m.devices)[i].Counter.NumInstantaneous),
-				fmt.Sprintf("%d", (*m.devices)[i].Counter.NumCumulative),
-				// fmt.Sprintf("%d", (*m.devices)[i].Counter.NumPosition),
-				fmt.Sprintf("%d", (*m.devices)[i].Counter.NumOtherData1),
-				fmt.Sprintf("%d", (*m.devices)[i].Counter.NumOtherData2),
-				fmt.Sprintf("%d", (*m.devices)[i].Counter.NumOtherData3),
-				fmt.Sprintf("%d", (*m.devices)[i].Counter.Total()),
-				fmt.Sprintf("%s", time.Since(start))}
+				strings.ToUpper(m.devices[i].Id.String()),
+				m.devices[i].Base,
+				fmt.Sprintf("%d", m.devices[i].Slot),
+				fmt.Sprintf("%d", counter.NumInstantaneous),
+				fmt.Sprintf("%d", counter.NumCumulative),
+				// fmt.Sprintf("%d", counter.NumPosition),
+				fmt.Sprintf("%d", counter.NumOtherData1),
+				fmt.Sprintf("%d", counter.NumOtherData2),
+				fmt.Sprintf("%d", counter.NumOtherData3),
+				fmt.Sprintf("%d", counter.Total()),
+				fmt.Sprintf("%s", m.windowLength),
+				m.commandCell(m.devices[i].Id.String())}
 			rows = append(rows, row)
-			// (*m.devices)[i].Counter.Clear()
+			// m.devices[i].Counter.Clear()
 		}
 		// start = time.Now()
 		columns = []table.Column{
 			{Title: "Live", Width: 4},
 			{Title: "ID", Width: 6},
+			{Title: "Base", Width: 10},
 			{Title: "Slot", Width: 4},
 			{Title: "IN", Width: 3},
 			{Title: "CU", Width: 3},
@@ -470,6 +816,7 @@ func (m model) updateTable() table.Model {
 			{Title: "O3", Width: 3},
 			{Title: "TOT", Width: 3},
 			{Title: "Elapsed", Width: 12},
+			{Title: "Command", Width: 18},
 		}
 		// update in this order to ensure rows have less elements than columns
 		m.table.SetRows(rows)
@@ -479,37 +826,129 @@ func (m model) updateTable() table.Model {
 	return m.table
 }
 
+// commandCell describes the last command issued to a device.
+func (m model) commandCell(id string) string {
+	c, ok := m.commands[id]
+	if !ok {
+		return ""
+	}
+	switch c.State {
+	case conn.CommandPending:
+		return "… " + c.Action
+	case conn.CommandConfirmed:
+		return "✓ " + c.Action
+	default:
+		return "✗ " + c.Action
+	}
+}
+
+var (
+	linkUpStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("46"))
+	linkDownStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+)
+
+// linksView shows the state of the links to every base station.
+func (m model) linksView() string {
+	if len(m.links) == 0 {
+		return ""
+	}
+	var bases []string
+	for base := range m.links {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+
+	var parts []string
+	for _, base := range bases {
+		part := base + ":"
+		for _, link := range []string{"rest", "stream", "push"} {
+			up, ok := m.links[base][link]
+			if !ok {
+				continue
+			}
+			if up {
+				part += " " + link + " " + linkUpStyle.Render("●")
+			} else {
+				part += " " + link + " " + linkDownStyle.Render("○")
+			}
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "   ") + "\n"
+}
+
 func (m model) View() string {
 	// if strings.Contains(m.log, "error") {
 	// 	return m.log + "\n"
 	// } else {
-		return m.log + "\n" +
-			baseStyle.Render(m.table.View()) + "\n" +
+		body := baseStyle.Render(colorize(m.table.View())) + "\n"
+		if m.content == contentSession {
+			body += m.deviceDetail()
+		}
+		if m.content == contentInventory {
+			body = m.firmwareSummary() + body
+		}
+		if m.content == contentLeaderboard {
+			body = m.leaderboardView() + body
+		}
+		if m.content == contentSlots {
+			body = m.slotGrid() + body
+		}
+		body += m.alertsView()
+		if m.kitCheck {
+			body = m.kitCheckView()
+		}
+		if m.summary != nil {
+			body = m.summaryView()
+		}
+		if m.prompting != promptNone {
+			body += m.prompt.View() + "\n"
+		}
+		return m.bellView() + m.log + "\n" +
+			m.linksView() +
+			m.batteryBanner() +
+			m.sessionView() +
+			body +
 			m.help.View(m.keys) + "\n"
 	// }
 }
 
-func tickCmd(m model) tea.Cmd {
-	for i := range *m.devices {
-		if time.Since(start) > UpdateInterval {
-			(*m.devices)[i].Counter.Clear()
-		}
-	}
-	start = time.Now()
-	return tea.Tick(UpdateInterval, func(t time.Time) tea.Msg {
+func tickCmd() tea.Cmd {
+	return tea.Tick(config.Current.Intervals.Render, func(t time.Time) tea.Msg {
 		return tickMsg(t)
 	})
 }
 
-func RenderTable(ctx context.Context, wg *sync.WaitGroup, errCh chan error, quitCh chan struct{}) {
-	defer wg.Done()
-	p := tea.NewProgram(initalModel(ctx, wg, errCh))
+// Run shows the device table until the user quits or ctx is cancelled. The
+// updates published by the connection layer and the sessions are delivered to the model as
+// messages.
+func Run(ctx context.Context) error {
+	updates := conn.Subscribe()
+	sessions := training.Subscribe()
+	raised := alerts.Subscribe()
+	p := tea.NewProgram(initalModel(ctx))
+	go func() {
+		ticker := time.NewTicker(SHARED_REFRESH)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				p.Quit()
+				return
+			case <-ticker.C:
+				p.Send(shared())
+			case u := <-updates:
+				p.Send(u)
+			case u := <-sessions:
+				p.Send(u)
+			case u := <-raised:
+				p.Send(u)
+			}
+		}
+	}()
 	if _, err := p.Run(); err != nil {
-		fmt.Println("Error running program:", err)
-		tea.Quit()
-		errCh <- err
+		logging.Error("display stopped", "err", err)
+		return err
 	}
-    close(quitCh)
-    close(errCh)
-    // fmt.Println("Quit display")
+	return nil
 }
@@ -0,0 +1,62 @@
+package display
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	conn "unolink-client/connection"
+	"unolink-client/training"
+)
+
+// GROUP_ALL is the group of every listed device.
+const GROUP_ALL = "all"
+
+// startGroup starts telemetry in the background for the devices of a roster
+// group, or of every listed device, returning what was started and skipped.
+func (m model) startGroup(name string) (string, error) {
+	if name == "" {
+		name = GROUP_ALL
+	}
+	var devices []string
+	if name == GROUP_ALL {
+		for _, d := range m.devices {
+			devices = append(devices, d.Id.String())
+		}
+	} else {
+		devices = training.Group(name)
+		if len(devices) == 0 {
+			groups := training.Groups()
+			if len(groups) == 0 {
+				return "", fmt.Errorf("no group %q, the roster has none", name)
+			}
+			return "", fmt.Errorf("no group %q, expected %s or one of %s", name, GROUP_ALL, strings.Join(groups, ", "))
+		}
+	}
+	if len(devices) == 0 {
+		return "", fmt.Errorf("there are no devices")
+	}
+
+	plan, err := conn.PlanGroup(devices)
+	if err != nil {
+		return "", err
+	}
+	if len(plan.Start) > 0 {
+		vo2max := make(map[string]float64, len(plan.Start))
+		for _, device := range plan.Start {
+			vo2max[device] = training.Player(device).VO2Max
+		}
+		go conn.StartTelemetry(vo2max)
+	}
+
+	msg := fmt.Sprintf("Starting telemetry for %d of %d devices of %s", len(plan.Start), len(devices), name)
+	if len(plan.Skipped) > 0 {
+		skipped := make([]string, 0, len(plan.Skipped))
+		for device, reason := range plan.Skipped {
+			skipped = append(skipped, device+" "+reason)
+		}
+		sort.Strings(skipped)
+		msg += ", skipped " + strings.Join(skipped, "; ")
+	}
+	return msg, nil
+}
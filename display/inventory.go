@@ -0,0 +1,104 @@
+package display
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"unolink-client/config"
+	"unolink-client/inventory"
+
+	"github.com/charmbracelet/bubbles/table"
+)
+
+// INVENTORY_TIME_FORMAT is how the first and last sightings are shown.
+const INVENTORY_TIME_FORMAT = "2006-01-02 15:04"
+
+// inventoryTable lists every device ever seen, grouped by firmware.
+func (m model) inventoryTable() ([]table.Row, []table.Column) {
+	columns := []table.Column{
+		{Title: "ID", Width: 6},
+		{Title: "Firmware", Width: 10},
+		{Title: "Base", Width: 10},
+		{Title: "First seen", Width: 16},
+		{Title: "Last seen", Width: 16},
+		{Title: "SoC", Width: 3},
+		{Title: "Runtime", Width: 7},
+		{Title: "Health", Width: 6},
+	}
+	var rows []table.Row
+	for _, e := range m.inventory {
+		rows = append(rows, table.Row{
+			strings.ToUpper(e.ID),
+			firmwareCell(e.Firmware),
+			e.Base,
+			e.FirstSeen.Format(INVENTORY_TIME_FORMAT),
+			e.LastSeen.Format(INVENTORY_TIME_FORMAT),
+			fmt.Sprintf("%d", e.Battery),
+			runtimeCell(e.Runtime),
+			healthCell(e.Health()),
+		})
+	}
+	return rows, columns
+}
+
+// firmwareCell flags the firmware versions older than the minimum.
+func firmwareCell(version string) string {
+	if version == "" {
+		return "-"
+	}
+	if inventory.Outdated(version) {
+		return colored(styleInvalid, version)
+	}
+	return version
+}
+
+func runtimeCell(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return hours(d)
+}
+
+// healthCell flags the batteries lasting less than three quarters of the
+// configured runtime, and those lasting less than half of it.
+func healthCell(health float64) string {
+	if health == 0 {
+		return "-"
+	}
+	text := fmt.Sprintf("%.0f%%", health*100)
+	switch {
+	case health < 0.5:
+		return colored(styleInvalid, text)
+	case health < 0.75:
+		return colored(styleWarning, text)
+	}
+	return text
+}
+
+// firmwareSummary counts the devices of each firmware version, naming the
+// minimum when some are older.
+func (m model) firmwareSummary() string {
+	groups := inventory.Groups(m.inventory)
+	if len(groups) == 0 {
+		return "No device in the inventory yet\n"
+	}
+	parts := make([]string, len(groups))
+	outdated := 0
+	for i, g := range groups {
+		version := g.Firmware
+		if version == "" {
+			version = "unknown"
+		}
+		parts[i] = fmt.Sprintf("%s: %d", version, g.Devices)
+		if g.Outdated {
+			outdated += g.Devices
+			parts[i] = criticalBannerStyle.Render(parts[i])
+		}
+	}
+	line := "Firmware  " + strings.Join(parts, "   ")
+	if outdated > 0 {
+		line += warningBannerStyle.Render(fmt.Sprintf("   (%d outdated, minimum %s)", outdated, config.Current.Inventory.MinFirmware))
+	}
+	return line + "\n"
+}
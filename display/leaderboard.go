@@ -0,0 +1,178 @@
+package display
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"unolink-client/config"
+	def "unolink-client/definitions"
+	"unolink-client/training"
+	"unolink-client/units"
+
+	"github.com/charmbracelet/bubbles/table"
+)
+
+// RANK_WINDOW is how long the positions are compared over for the arrows.
+const RANK_WINDOW = 10 * time.Second
+
+// rankMetric is a metric the leaderboard can rank the devices by, from their
+// state or their totals in the running session. Field is the name of the
+// metric for its unit.
+type rankMetric struct {
+	Name  string
+	Title string
+	Field string
+	Value func(d def.DeviceState, t training.Totals) float64
+}
+
+var RANK_METRICS = []rankMetric{
+	{"speed", "Speed", "speed", func(d def.DeviceState, t training.Totals) float64 { return float64(d.Speed) }},
+	{"max_speed", "Max speed", "max_speed", func(d def.DeviceState, t training.Totals) float64 { return t.MaxSpeed }},
+	{"distance", "Distance", "distance", func(d def.DeviceState, t training.Totals) float64 { return t.Distance }},
+	{"high_speed_distance", "HSD", "high_speed_distance", func(d def.DeviceState, t training.Totals) float64 { return t.HighSpeedDistance }},
+	{"accelerations", "Acc", "", func(d def.DeviceState, t training.Totals) float64 { return float64(t.Accelerations) }},
+	{"energy", "Energy", "energy", func(d def.DeviceState, t training.Totals) float64 { return float64(d.Energy) }},
+}
+
+// parseRank returns the index of a metric in RANK_METRICS, the first when
+// unknown.
+func parseRank(name string) int {
+	for i, r := range RANK_METRICS {
+		if r.Name == name {
+			return i
+		}
+	}
+	return 0
+}
+
+// ranked is a device of the leaderboard.
+type ranked struct {
+	id     string
+	player string
+	values []float64 // one per metric of RANK_METRICS
+}
+
+// ranking returns the devices sorted by the selected metric, the best first.
+func (m model) ranking() []ranked {
+	list := make([]ranked, 0, len(m.devices))
+	for _, d := range m.devices {
+		id := d.Id.String()
+		var t training.Totals
+		if m.session != nil {
+			if totals, ok := m.session.Devices[id]; ok {
+				t = *totals
+			}
+		}
+		r := ranked{id: id, player: training.Player(id).Name, values: make([]float64, len(RANK_METRICS))}
+		for i, metric := range RANK_METRICS {
+			r.values[i] = metric.Value(d, t)
+		}
+		list = append(list, r)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if a, b := list[i].values[m.rank], list[j].values[m.rank]; a != b {
+			return a > b
+		}
+		return list[i].id < list[j].id
+	})
+	return list
+}
+
+// positions maps every device to its position in the ranking, from 1.
+func (m model) positions() map[string]int {
+	positions := map[string]int{}
+	for i, r := range m.ranking() {
+		positions[r.id] = i + 1
+	}
+	return positions
+}
+
+// rollRanks keeps the positions of RANK_WINDOW ago for the arrows.
+func (m *model) rollRanks() {
+	if m.content != contentLeaderboard || time.Since(m.rankSince) < RANK_WINDOW {
+		return
+	}
+	m.rankBaseline = m.positions()
+	m.rankSince = time.Now()
+	m.dirty = true
+}
+
+// nextRank ranks by the next metric, starting the arrows over.
+func (m *model) nextRank() {
+	m.rank = (m.rank + 1) % len(RANK_METRICS)
+	m.rankBaseline = nil
+	m.rankSince = time.Now()
+}
+
+// leaderboardTable ranks the devices by the selected metric with every other
+// metric, or only the top ones by the selected metric in compact mode.
+func (m model) leaderboardTable() ([]table.Row, []table.Column) {
+	columns := []table.Column{
+		{Title: "#", Width: 3},
+		{Title: "ID", Width: 6},
+		{Title: "", Width: 3},
+		{Title: "Player", Width: 14},
+	}
+	shown := make([]int, 0, len(RANK_METRICS))
+	if m.compact {
+		shown = append(shown, m.rank)
+	} else {
+		for i := range RANK_METRICS {
+			shown = append(shown, i)
+		}
+	}
+	for _, i := range shown {
+		metric := RANK_METRICS[i]
+		title := metric.Title
+		if i == m.rank {
+			title = "▸" + title
+		}
+		if metric.Field == "" {
+			columns = append(columns, table.Column{Title: title, Width: max(len(title), 5)})
+		} else {
+			columns = append(columns, unitColumn(title, metric.Field, 7))
+		}
+	}
+
+	var rows []table.Row
+	for i, r := range m.ranking() {
+		if m.compact && i == config.Current.UI.Top {
+			break
+		}
+		row := table.Row{fmt.Sprintf("%d", i+1), r.id, rankArrow(m.rankBaseline[r.id], i+1), r.player}
+		for _, j := range shown {
+			if field := RANK_METRICS[j].Field; field != "" {
+				row = append(row, units.Format(field, r.values[j]))
+			} else {
+				row = append(row, fmt.Sprintf("%.0f", r.values[j]))
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, columns
+}
+
+// rankArrow shows how many positions a device gained or lost since its
+// previous position, nothing while unknown.
+func rankArrow(prev, pos int) string {
+	switch {
+	case prev == 0 || prev == pos:
+		return ""
+	case pos < prev:
+		return colored(styleUp, fmt.Sprintf("▲%d", prev-pos))
+	}
+	return colored(styleDown, fmt.Sprintf("▼%d", pos-prev))
+}
+
+// leaderboardView names the metric ranked by above the leaderboard.
+func (m model) leaderboardView() string {
+	line := "Leaderboard by " + RANK_METRICS[m.rank].Title
+	if m.compact {
+		line += fmt.Sprintf(", top %d", config.Current.UI.Top)
+	}
+	if m.session == nil && RANK_METRICS[m.rank].Name != "speed" && RANK_METRICS[m.rank].Name != "energy" {
+		line += " (no session running)"
+	}
+	return sessionStyle.Render(line) + "   m: metric  c: compact\n"
+}
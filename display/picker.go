@@ -0,0 +1,93 @@
+package display
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"unolink-client/config"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var ErrNoProfile = errors.New("no profile selected")
+
+type pickerModel struct {
+	names    []string
+	cursor   int
+	chosen   string
+	canceled bool
+}
+
+var (
+	pickerTitleStyle    = lipgloss.NewStyle().Bold(true)
+	pickerSelectedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("229")).Background(lipgloss.Color("57"))
+	pickerDetailStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("247"))
+)
+
+func (m pickerModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m pickerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "up", "k":
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case "down", "j":
+			if m.cursor < len(m.names)-1 {
+				m.cursor++
+			}
+		case "enter":
+			m.chosen = m.names[m.cursor]
+			return m, tea.Quit
+		case "q", "esc", "ctrl+c":
+			m.canceled = true
+			return m, tea.Quit
+		}
+	}
+	return m, nil
+}
+
+func (m pickerModel) View() string {
+	if m.chosen != "" || m.canceled {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(pickerTitleStyle.Render("Select a base station") + "\n\n")
+	for i, name := range m.names {
+		p := config.Current.Profiles[name]
+		line := fmt.Sprintf("%-16s", name)
+		detail := pickerDetailStyle.Render(p.Host)
+		if i == m.cursor {
+			b.WriteString("> " + pickerSelectedStyle.Render(line) + " " + detail + "\n")
+		} else {
+			b.WriteString("  " + line + " " + detail + "\n")
+		}
+	}
+	b.WriteString("\n" + pickerDetailStyle.Render("↑/↓ move • enter select • q quit") + "\n")
+	return b.String()
+}
+
+// PickProfile asks the user to choose one of the configured profiles, with
+// last preselected when it still exists.
+func PickProfile(names []string, last string) (string, error) {
+	m := pickerModel{names: names}
+	for i, name := range names {
+		if name == last {
+			m.cursor = i
+		}
+	}
+
+	final, err := tea.NewProgram(m).Run()
+	if err != nil {
+		return "", err
+	}
+	if final.(pickerModel).chosen == "" {
+		return "", ErrNoProfile
+	}
+	return final.(pickerModel).chosen, nil
+}
@@ -0,0 +1,384 @@
+package display
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"unolink-client/config"
+	"unolink-client/logging"
+	"unolink-client/training"
+	"unolink-client/units"
+
+	"github.com/charmbracelet/bubbles/table"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// content is what the table shows, cycled with tab.
+type content int
+
+const (
+	contentCounters content = iota
+	contentStates
+	contentSession
+	contentLeaderboard
+	contentSlots
+	contentInventory
+)
+
+func parseContent(s string) content {
+	switch s {
+	case "states":
+		return contentStates
+	case "session":
+		return contentSession
+	case "leaderboard":
+		return contentLeaderboard
+	case "slots":
+		return contentSlots
+	case "inventory":
+		return contentInventory
+	}
+	return contentCounters
+}
+
+// next is the content after c, skipping the inventory when it is disabled.
+func (c content) next() content {
+	c = (c + 1) % (contentInventory + 1)
+	if c == contentInventory && config.Current.Inventory.File == "" {
+		return contentCounters
+	}
+	return c
+}
+
+// prompt is what the name being typed is for.
+type prompt int
+
+const (
+	promptNone prompt = iota
+	promptSession
+	promptLap
+	promptSlot
+	promptGroup
+)
+
+var sessionStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+
+func (m *model) startPrompt(p prompt) tea.Cmd {
+	m.prompting = p
+	m.prompt.Reset()
+	switch p {
+	case promptSession:
+		m.prompt.Prompt = "Session name: "
+		m.prompt.Placeholder = time.Now().Format(training.NAME_FORMAT)
+	case promptLap:
+		m.prompt.Prompt = "Lap name: "
+		m.prompt.Placeholder = fmt.Sprintf("Lap %d", len(m.session.Laps)+1)
+	case promptSlot:
+		m.prompt.Prompt = "Slot for " + m.slotDevice + ": "
+		m.prompt.Placeholder = ""
+	case promptGroup:
+		m.prompt.Prompt = "Telemetry for group: "
+		m.prompt.Placeholder = GROUP_ALL
+	}
+	return m.prompt.Focus()
+}
+
+// updatePrompt edits the name until enter starts the session or the lap,
+// assigns the slot or starts the group, or esc gives up.
+func (m model) updatePrompt(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.prompting = promptNone
+		m.prompt.Blur()
+		return m, nil
+	case "enter":
+		name := strings.TrimSpace(m.prompt.Value())
+		var err error
+		switch m.prompting {
+		case promptSession:
+			logging.Info("user action", "action", "start_session", "name", name)
+			err = training.Start(name)
+		case promptLap:
+			logging.Info("user action", "action", "new_lap", "name", name)
+			err = training.NewLap(name)
+		case promptSlot:
+			logging.Info("user action", "action", "assign_slot", "device", m.slotDevice, "slot", name)
+			err = assignSlot(m.slotDevice, name)
+			if err == nil {
+				m.log = "Assigning slot " + name + " to device: " + m.slotDevice
+			}
+		case promptGroup:
+			logging.Info("user action", "action", "group_telemetry", "group", name)
+			var started string
+			if started, err = m.startGroup(name); err == nil {
+				m.log = started
+			}
+		}
+		if err != nil {
+			m.log = err.Error()
+		}
+		m.prompting = promptNone
+		m.prompt.Blur()
+		return m, nil
+	}
+	var cmd tea.Cmd
+	m.prompt, cmd = m.prompt.Update(msg)
+	return m, cmd
+}
+
+// sessionView tells which session and lap are running.
+func (m model) sessionView() string {
+	if m.session == nil {
+		return "No session\n"
+	}
+	line := fmt.Sprintf("Session %s  %s", m.session.Name, clock(m.session.Elapsed()))
+	if lap, ok := m.session.CurrentLap(); ok {
+		line += fmt.Sprintf("   Lap %s  %s", lap.Name, clock(time.Since(lap.Start)))
+	}
+	return sessionStyle.Render(line) + "\n"
+}
+
+// sessionTable shows the totals of the running session by device.
+func (m model) sessionTable() ([]table.Row, []table.Column) {
+	columns := []table.Column{
+		{Title: "Live", Width: 4},
+		{Title: "ID", Width: 6},
+		{Title: "Player", Width: 14},
+		unitColumn("Dist", "distance", 7),
+		unitColumn("HSD", "high_speed_distance", 6),
+		unitColumn("Max", "max_speed", 5),
+		{Title: "Acc", Width: 4},
+		{Title: "Dec", Width: 4},
+		{Title: "Jump", Width: 4},
+		{Title: "Imp", Width: 4},
+		unitColumn("HMLD", "hmld", 6),
+		{Title: "TRIMP", Width: 5},
+		{Title: "Edw", Width: 5},
+		{Title: "HRQ", Width: 4},
+		{Title: "Spr", Width: 3},
+		unitColumn("SprD", "sprint_distance", 5),
+		{Title: "Band", Width: 5},
+	}
+	zones := len(m.zones())
+	for i := 0; i < zones; i++ {
+		columns = append(columns, table.Column{Title: fmt.Sprintf("Z%d", i+1), Width: 5})
+	}
+
+	var rows []table.Row
+	for _, d := range m.devices {
+		id := d.Id.String()
+		live := "[ ]"
+		if _, ok := m.mapping[id]; ok {
+			live = "[✓]"
+		}
+		var t training.Totals
+		var player string
+		if m.session != nil {
+			if totals, ok := m.session.Devices[id]; ok {
+				t = *totals
+			}
+			player = m.session.Players[id].Name
+		}
+		row := table.Row{
+			live, id, player,
+			units.Format("distance", t.Distance),
+			units.Format("high_speed_distance", t.HighSpeedDistance),
+			units.Format("max_speed", t.MaxSpeed),
+			fmt.Sprintf("%d", t.Accelerations),
+			fmt.Sprintf("%d", t.Decelerations),
+			fmt.Sprintf("%d", t.Jumps),
+			fmt.Sprintf("%d", t.Impacts),
+			units.Format("hmld", t.HMLD),
+			fmt.Sprintf("%.0f", t.TRIMP),
+			fmt.Sprintf("%.0f", t.Edwards()),
+			qualityCell(t),
+			fmt.Sprintf("%d", len(t.Sprints)),
+			units.Format("sprint_distance", t.SprintDistance()),
+			deviationCell(t),
+		}
+		for i := 0; i < zones; i++ {
+			var d time.Duration
+			if i < len(t.HRZones) {
+				d = t.HRZones[i]
+			}
+			row = append(row, clock(d))
+		}
+		rows = append(rows, row)
+	}
+	return rows, columns
+}
+
+// HR_QUALITY_WARNING is the fraction of good heart rate samples under which
+// the signal of a device is flagged.
+const HR_QUALITY_WARNING = 0.9
+
+// hrmCell colors a heart rate by its zone for the player wearing the device,
+// and flags the heart rates that cannot be.
+func hrmCell(id string, hr uint8) string {
+	text := fmt.Sprintf("%d", hr)
+	if !training.HRPlausible(hr) {
+		return colored(styleInvalid, text)
+	}
+	maxHR, _ := training.Player(id).HeartRate()
+	zone := training.Zone(hr, maxHR, config.Current.Training.HRZones)
+	if zone < 0 {
+		return text
+	}
+	return colored(zoneStyle(zone), text)
+}
+
+// qualityCell shows the share of good heart rate samples, flagged when the
+// signal is poor.
+func qualityCell(t training.Totals) string {
+	text := fmt.Sprintf("%.0f%%", t.HRQuality()*100)
+	if t.HRQuality() < HR_QUALITY_WARNING {
+		return colored(styleInvalid, text)
+	}
+	return text
+}
+
+// BAND_DEVIATION_WARNING is the difference between the distance integrated
+// in the speed bands and the one counted by the device, as a fraction of the
+// latter, over which a device is flagged.
+const BAND_DEVIATION_WARNING = 0.1
+
+// deviationCell compares the distance integrated in the speed bands to the
+// one counted by the device.
+func deviationCell(t training.Totals) string {
+	if len(t.DeviceBands) == 0 {
+		return "-"
+	}
+	text := fmt.Sprintf("%+.0f%%", t.BandDeviation()*100)
+	if math.Abs(t.BandDeviation()) > BAND_DEVIATION_WARNING {
+		return colored(styleInvalid, text)
+	}
+	return text
+}
+
+// SPRINTS_SHOWN is how many of the latest sprints of the selected device are
+// listed under the session table.
+const SPRINTS_SHOWN = 5
+
+// deviceDetail shows the speed bands and the latest sprints of the device
+// selected in the session table.
+func (m model) deviceDetail() string {
+	row := m.table.SelectedRow()
+	if m.session == nil || len(row) < 2 {
+		return ""
+	}
+	t, ok := m.session.Devices[row[1]]
+	if !ok {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s  %s: %s  device: %s\n", row[1], m.session.Players[row[1]].Name,
+		units.Title("bands", "speed_band"), distances(t.SpeedBands), distances(t.DeviceBands))
+	sprints := t.Sprints[max(len(t.Sprints)-SPRINTS_SHOWN, 0):]
+	for i := len(sprints) - 1; i >= 0; i-- {
+		sp := sprints[i]
+		fmt.Fprintf(&b, "  sprint %s  %4.1fs  peak %s %s  %s %s\n", sp.Start.Format(time.TimeOnly),
+			sp.Duration.Seconds(), units.Format("peak_speed", sp.PeakSpeed), units.Field("peak_speed").Symbol,
+			units.Format("distance", sp.Distance), units.Field("distance").Symbol)
+	}
+	return b.String()
+}
+
+// distances formats the distances of speed bands in the selected unit.
+func distances(bands []float64) string {
+	if len(bands) == 0 {
+		return "-"
+	}
+	s := make([]string, len(bands))
+	for i, d := range bands {
+		s[i] = units.Format("speed_band", d)
+	}
+	return strings.Join(s, " ")
+}
+
+// zones returns the time in each heart rate zone of the first device of the
+// session, to size the table.
+func (m model) zones() []time.Duration {
+	if m.session != nil {
+		for _, t := range m.session.Devices {
+			if len(t.HRZones) > 0 {
+				return t.HRZones
+			}
+		}
+	}
+	return nil
+}
+
+// summaryView shows the totals of the session that just ended, and of its
+// laps, until it is dismissed.
+func (m model) summaryView() string {
+	s := m.summary
+	var b strings.Builder
+	fmt.Fprintf(&b, "Session %s ended after %s\n\n", s.Name, clock(s.Elapsed()))
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tPlayer\t%s\t%s\t%s\tAcc\tDec\tJump\tImp\t%s\tTRIMP\tEdwards\tHR signal\tSprints\tHR zones\n",
+		units.Title("Dist", "distance"), units.Title("HSD", "high_speed_distance"), units.Title("Max", "max_speed"),
+		units.Title("HMLD", "hmld"))
+	for _, id := range training.SortedDevices(s.Devices) {
+		t := s.Devices[id]
+		zones := make([]string, len(t.HRZones))
+		for i, d := range t.HRZones {
+			zones[i] = clock(d)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\t%.1f\t%.1f\t%.0f%%\t%d\t%s\n", id, s.Players[id].Name,
+			units.Format("distance", t.Distance), units.Format("high_speed_distance", t.HighSpeedDistance),
+			units.Format("max_speed", t.MaxSpeed), t.Accelerations, t.Decelerations, t.Jumps,
+			t.Impacts, units.Format("hmld", t.HMLD), t.TRIMP, t.Edwards(), t.HRQuality()*100, len(t.Sprints),
+			strings.Join(zones, " "))
+	}
+	w.Flush()
+
+	var sprinted bool
+	for _, t := range s.Devices {
+		sprinted = sprinted || len(t.Sprints) > 0
+	}
+	if sprinted {
+		b.WriteString("\n")
+		w = tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Sprints\tPlayer\tStart\tLap\tDuration\t%s\t%s\n",
+			units.Title("Peak", "peak_speed"), units.Title("Dist", "distance"))
+		for _, id := range training.SortedDevices(s.Devices) {
+			for _, sp := range s.Devices[id].Sprints {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.1fs\t%s\t%s\n", id, s.Players[id].Name,
+					sp.Start.Format(time.TimeOnly), s.LapAt(sp.Start), sp.Duration.Seconds(),
+					units.Format("peak_speed", sp.PeakSpeed), units.Format("distance", sp.Distance))
+			}
+		}
+		w.Flush()
+	}
+
+	if len(s.Laps) > 0 {
+		b.WriteString("\n")
+		w = tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Lap\tDuration\tDevices\t%s\t%s\n",
+			units.Title("Dist", "distance"), units.Title("HSD", "high_speed_distance"))
+		for _, lap := range s.Laps {
+			var total training.Totals
+			for _, t := range lap.Devices {
+				total.Distance += t.Distance
+				total.HighSpeedDistance += t.HighSpeedDistance
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", lap.Name, clock(lap.End.Sub(lap.Start)),
+				len(lap.Devices), units.Format("distance", total.Distance),
+				units.Format("high_speed_distance", total.HighSpeedDistance))
+		}
+		w.Flush()
+	}
+	b.WriteString("\nenter: back to the devices")
+	return baseStyle.Render(b.String()) + "\n"
+}
+
+// clock formats a duration as minutes and seconds.
+func clock(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%02d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
@@ -0,0 +1,180 @@
+package display
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"unolink-client/config"
+	conn "unolink-client/connection"
+	def "unolink-client/definitions"
+
+	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// SLOT_GROUP is how many slots the grid shows between two spaces.
+const SLOT_GROUP = 8
+
+var (
+	freeSlotStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+	occupiedSlotStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("46"))
+	conflictSlotStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Bold(true)
+)
+
+// packetRate is the packets per second of a device over the last window of
+// the counters, negative until a window is complete.
+func (m model) packetRate(id string) float64 {
+	addr, err := def.RadioAddressFromString(id)
+	if err != nil || m.windowLength <= 0 {
+		return -1
+	}
+	counter := m.window[addr]
+	return float64(counter.Total()) / m.windowLength.Seconds()
+}
+
+// slotsTable lists the devices in telemetry by base station and slot.
+func (m model) slotsTable() ([]table.Row, []table.Column) {
+	columns := []table.Column{
+		{Title: "Slot", Width: 4},
+		{Title: "ID", Width: 6},
+		{Title: "Base", Width: 10},
+		{Title: "State", Width: 8},
+		{Title: "Pkt/s", Width: 5},
+		{Title: "Command", Width: 18},
+	}
+	type entry struct {
+		base string
+		slot uint8
+		id   string
+	}
+	var entries []entry
+	for base, mapping := range m.bases {
+		for id, slot := range mapping {
+			entries = append(entries, entry{base, slot, id})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.base != b.base {
+			return a.base < b.base
+		}
+		if a.slot != b.slot {
+			return a.slot < b.slot
+		}
+		return a.id < b.id
+	})
+
+	var rows []table.Row
+	for _, e := range entries {
+		state := "ok"
+		if slots := conn.SlotMap(m.bases[e.base]); len(slots[e.slot]) > 1 {
+			state = colored(styleInvalid, "conflict")
+		} else if capacity := config.Current.Telemetry.Slots; capacity > 0 && int(e.slot) >= capacity {
+			state = colored(styleInvalid, "beyond")
+		} else if owner := m.owner(e.id); owner != "" && owner != e.base {
+			state = colored(styleWarning, "foreign")
+		}
+		rate := "-"
+		if r := m.packetRate(e.id); r >= 0 {
+			rate = fmt.Sprintf("%.1f", r)
+		}
+		rows = append(rows, table.Row{fmt.Sprintf("%d", e.slot), e.id, e.base, state, rate, m.commandCell(e.id)})
+	}
+	return rows, columns
+}
+
+// owner is the base station a device is attached to, as last listed.
+func (m model) owner(id string) string {
+	addr, err := def.RadioAddressFromString(id)
+	if err != nil {
+		return ""
+	}
+	if i, ok := m.index[addr]; ok {
+		return m.devices[i].Base
+	}
+	return ""
+}
+
+// slotGrid shows the slots of every base station, free, occupied or in
+// conflict, with how many are used and the packets per second of the
+// station.
+func (m model) slotGrid() string {
+	if len(m.bases) == 0 {
+		return "No telemetry mapping yet\n"
+	}
+	bases := make([]string, 0, len(m.bases))
+	for base := range m.bases {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+	capacity := config.Current.Telemetry.Slots
+
+	var b strings.Builder
+	for _, base := range bases {
+		mapping := m.bases[base]
+		slots := conn.SlotMap(mapping)
+		size := capacity
+		for slot := range slots {
+			size = max(size, int(slot)+1)
+		}
+		var grid strings.Builder
+		conflicts := 0
+		for slot := 0; slot < size; slot++ {
+			if slot > 0 && slot%SLOT_GROUP == 0 {
+				grid.WriteString(" ")
+			}
+			switch devices := slots[uint8(slot)]; {
+			case len(devices) > 1:
+				conflicts++
+				grid.WriteString(conflictSlotStyle.Render("✖"))
+			case len(devices) == 1 && capacity > 0 && slot >= capacity:
+				grid.WriteString(conflictSlotStyle.Render("■"))
+			case len(devices) == 1:
+				grid.WriteString(occupiedSlotStyle.Render("■"))
+			default:
+				grid.WriteString(freeSlotStyle.Render("·"))
+			}
+		}
+		used := strconv.Itoa(len(mapping))
+		if capacity > 0 {
+			used += "/" + strconv.Itoa(capacity)
+		}
+		line := fmt.Sprintf("%-10s %6s  %s", base, used, grid.String())
+		var rate float64
+		known := false
+		for id := range mapping {
+			if r := m.packetRate(id); r >= 0 {
+				rate += r
+				known = true
+			}
+		}
+		if known {
+			line += fmt.Sprintf("  %.0f pkt/s", rate)
+		}
+		if conflicts > 0 {
+			line += conflictSlotStyle.Render(fmt.Sprintf("  %d in conflict", conflicts))
+		}
+		b.WriteString(line + "\n")
+	}
+	if capacity == 0 {
+		b.WriteString(freeSlotStyle.Render("slot capacity unknown, set telemetry.slots") + "\n")
+	}
+	b.WriteString("g: assign slot  G: rebalance\n")
+	return b.String()
+}
+
+// assignSlot checks the slot typed for a device and asks for it in the
+// background.
+func assignSlot(device, typed string) error {
+	slot, err := strconv.ParseUint(typed, 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid slot %q", typed)
+	}
+	if err := conn.CheckSlot(device, uint8(slot)); err != nil {
+		return err
+	}
+	go conn.AssignSlot(device, uint8(slot))
+	return nil
+}
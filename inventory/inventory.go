@@ -0,0 +1,270 @@
+// Package inventory records every device ever seen in a file that outlives
+// the client: when it was first and last seen, its firmware and the health of
+// its battery.
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"unolink-client/battery"
+	"unolink-client/config"
+	conn "unolink-client/connection"
+	def "unolink-client/definitions"
+	"unolink-client/logging"
+)
+
+const SAVE_INTERVAL = 30 * time.Second
+
+// Entry is a device of the inventory. Runtime is how long a full battery
+// lasted the last time its discharge rate was known, 0 until then.
+type Entry struct {
+	ID        string        `json:"id"`
+	Firmware  string        `json:"firmware"`
+	Base      string        `json:"base"`
+	FirstSeen time.Time     `json:"first_seen"`
+	LastSeen  time.Time     `json:"last_seen"`
+	Battery   uint8         `json:"battery"` // last level, in percent
+	Runtime   time.Duration `json:"runtime"`
+}
+
+// Health compares the runtime of the battery to the configured one, 1 being
+// as good as new; 0 while the runtime is unknown.
+func (e Entry) Health() float64 {
+	if e.Runtime == 0 || config.Current.Battery.Runtime == 0 {
+		return 0
+	}
+	return min(float64(e.Runtime)/float64(config.Current.Battery.Runtime), 1)
+}
+
+// Outdated tells whether the firmware of the device is older than the
+// configured minimum. An unknown firmware is not outdated.
+func (e Entry) Outdated() bool {
+	return Outdated(e.Firmware)
+}
+
+// Outdated tells whether a firmware version is older than the configured
+// minimum.
+func Outdated(version string) bool {
+	min := config.Current.Inventory.MinFirmware
+	return version != "" && min != "" && CompareVersions(version, min) < 0
+}
+
+// CompareVersions compares two firmware versions number by number, such as
+// 1.10.2 and 1.9, returning -1, 0 or 1. Anything between the numbers, like
+// dots or a leading v, is ignored.
+func CompareVersions(a, b string) int {
+	na, nb := versionNumbers(a), versionNumbers(b)
+	for i := 0; i < max(len(na), len(nb)); i++ {
+		var x, y int
+		if i < len(na) {
+			x = na[i]
+		}
+		if i < len(nb) {
+			y = nb[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionNumbers(v string) []int {
+	fields := strings.FieldsFunc(v, func(r rune) bool { return r < '0' || r > '9' })
+	numbers := make([]int, len(fields))
+	for i, f := range fields {
+		numbers[i], _ = strconv.Atoi(f)
+	}
+	return numbers
+}
+
+var (
+	mu      sync.Mutex
+	entries = map[string]*Entry{}
+	dirty   bool
+)
+
+// Load reads the inventory file. A missing file is an empty inventory.
+func Load(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Entry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// save writes the inventory through a temporary file, so that a crash never
+// leaves it half written.
+func save(path string, list []Entry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Run keeps the inventory at path up to date with the device lists until ctx
+// is cancelled, saving it every SAVE_INTERVAL when it changed and on exit.
+func Run(ctx context.Context, path string) error {
+	list, err := Load(path)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	for i := range list {
+		e := list[i]
+		entries[e.ID] = &e
+	}
+	mu.Unlock()
+	logging.Info("inventory loaded", "path", path, "devices", len(list))
+
+	updates := conn.Subscribe()
+	ticker := time.NewTicker(SAVE_INTERVAL)
+	defer ticker.Stop()
+	flush := func() {
+		mu.Lock()
+		if !dirty {
+			mu.Unlock()
+			return
+		}
+		dirty = false
+		list := snapshot()
+		mu.Unlock()
+		if err := save(path, list); err != nil {
+			logging.Error("cannot save inventory", "path", path, "err", err)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return nil
+		case <-ticker.C:
+			flush()
+		case u := <-updates:
+			switch u := u.(type) {
+			case conn.DevicesChanged:
+				see(u.Devices)
+			case conn.PacketDecoded:
+				seen(u.Device.Id.String(), u.Device.LastSeen)
+			}
+		}
+	}
+}
+
+// see records the devices of the lists.
+func see(devices []def.DeviceState) {
+	mu.Lock()
+	defer mu.Unlock()
+	now := time.Now()
+	for _, d := range devices {
+		id := d.Id.String()
+		e, ok := entries[id]
+		if !ok {
+			e = &Entry{ID: id, FirstSeen: now}
+			entries[id] = e
+			logging.Info("new device in the inventory", "device", id, "firmware", d.Firmware)
+		}
+		if d.Firmware != "" && d.Firmware != e.Firmware {
+			if e.Firmware != "" {
+				logging.Info("device firmware changed", "device", id, "from", e.Firmware, "to", d.Firmware)
+			}
+			e.Firmware = d.Firmware
+		}
+		e.Base = d.Base
+		e.LastSeen = now
+		if d.Battery != def.BATTERY_UNKNOWN {
+			e.Battery = d.Battery
+		}
+		if b, ok := battery.Lookup(id); ok && b.Rate > 0 {
+			e.Runtime = time.Duration(100 / b.Rate * float64(time.Hour))
+		}
+		dirty = true
+	}
+}
+
+// seen moves the last sighting of a device forward, at most once a minute
+// to keep the inventory from being rewritten for every packet.
+func seen(id string, at time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+	if e, ok := entries[id]; ok && at.Sub(e.LastSeen) > time.Minute {
+		e.LastSeen = at
+		dirty = true
+	}
+}
+
+// Snapshot returns the inventory sorted by firmware, the newest first, and
+// by ID.
+func Snapshot() []Entry {
+	mu.Lock()
+	defer mu.Unlock()
+	return snapshot()
+}
+
+func snapshot() []Entry {
+	list := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, *e)
+	}
+	Sort(list)
+	return list
+}
+
+// Sort sorts entries by firmware, the newest first, and by ID.
+func Sort(list []Entry) {
+	sort.Slice(list, func(i, j int) bool {
+		if c := CompareVersions(list[i].Firmware, list[j].Firmware); c != 0 {
+			return c > 0
+		}
+		return list[i].ID < list[j].ID
+	})
+}
+
+// Group counts the devices of each firmware, in the order of the entries.
+type Group struct {
+	Firmware string
+	Devices  int
+	Outdated bool
+}
+
+// Groups returns the firmware versions of sorted entries with their number
+// of devices.
+func Groups(list []Entry) []Group {
+	var groups []Group
+	for _, e := range list {
+		if n := len(groups); n > 0 && groups[n-1].Firmware == e.Firmware {
+			groups[n-1].Devices++
+			continue
+		}
+		groups = append(groups, Group{Firmware: e.Firmware, Devices: 1, Outdated: e.Outdated()})
+	}
+	return groups
+}
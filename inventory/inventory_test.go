@@ -0,0 +1,25 @@
+package inventory
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.0", "1.2.0", 0},
+		{"1.2", "1.2.0", 0},
+		{"v1.2.0", "1.2.0", 0},
+		{"1.10.2", "1.9", 1},
+		{"1.9", "1.10.2", -1},
+		{"2.0", "1.99.99", 1},
+		{"1.2.1", "1.2", 1},
+		{"1.2.0-rc1", "1.2.0", 1},
+		{"", "1.0", -1},
+	}
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("%q vs %q: %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	LOG_MAX_SIZE_MB  = 10
+	LOG_MAX_BACKUPS  = 5
+	LOG_MAX_AGE_DAYS = 28
+)
+
+// Logger is shared by every package. Until Init is called with a file it
+// discards everything, so nothing is ever written on top of the TUI.
+var Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+var rotator *lumberjack.Logger
+
+// Init configures the shared logger to write structured records to a rotating
+// file. The level is debug, info, warn or error and the format logfmt or
+// json; both are checked even when an empty path keeps logging disabled.
+func Init(path, level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+
+	format = strings.ToLower(format)
+	if format != "json" && format != "logfmt" {
+		return fmt.Errorf("invalid log format %q, expected logfmt or json", format)
+	}
+
+	if path == "" {
+		return nil
+	}
+
+	rotator = &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    LOG_MAX_SIZE_MB,
+		MaxBackups: LOG_MAX_BACKUPS,
+		MaxAge:     LOG_MAX_AGE_DAYS,
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	if format == "json" {
+		Logger = slog.New(slog.NewJSONHandler(rotator, opts))
+	} else {
+		Logger = slog.New(slog.NewTextHandler(rotator, opts))
+	}
+	return nil
+}
+
+// Close flushes and closes the log file, if any.
+func Close() {
+	if rotator != nil {
+		rotator.Close()
+	}
+}
+
+func Debug(msg string, args ...any) { Logger.Debug(msg, args...) }
+func Info(msg string, args ...any)  { Logger.Info(msg, args...) }
+func Warn(msg string, args ...any)  { Logger.Warn(msg, args...) }
+func Error(msg string, args ...any) { Logger.Error(msg, args...) }
@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		level, format string
+		file          bool
+		err           bool
+	}{
+		{level: "info", format: "logfmt", file: true},
+		{level: "DEBUG", format: "JSON", file: true},
+		{level: "warn", format: "logfmt"},
+		{level: "verbose", format: "logfmt", err: true},
+		{level: "verbose", format: "logfmt", file: true, err: true},
+		{level: "info", format: "text", err: true},
+		{level: "info", format: "yaml", file: true, err: true},
+	}
+	for _, tt := range tests {
+		path := ""
+		if tt.file {
+			path = filepath.Join(t.TempDir(), "unolink.log")
+		}
+		err := Init(path, tt.level, tt.format)
+		Close()
+		if (err != nil) != tt.err {
+			t.Errorf("level %q, format %q, file %v: error %v", tt.level, tt.format, tt.file, err)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unolink.log")
+	if err := Init(path, "info", "json"); err != nil {
+		t.Fatal(err)
+	}
+	Debug("left out")
+	Info("session started", "name", "morning")
+	Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.TrimSpace(string(data))
+	if strings.Contains(got, "left out") || !strings.Contains(got, `"msg":"session started","name":"morning"`) {
+		t.Errorf("log %s", got)
+	}
+}
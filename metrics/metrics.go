@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	def "unolink-client/definitions"
+	"unolink-client/logging"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	NAMESPACE        = "unolink"
+	METRICS_PATH     = "/metrics"
+	SHUTDOWN_TIMEOUT = time.Second
+)
+
+// Registry holds every metric of the client. The counters below are always
+// updated; they are only exposed once Serve runs.
+var Registry = prometheus.NewRegistry()
+
+var (
+	DecodeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "decode_errors_total",
+		Help:      "Stream packets that could not be decoded.",
+	}, []string{"base", "reason"})
+
+	RestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "rest_request_duration_seconds",
+		Help:      "Duration of the REST calls to the base stations.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"base", "endpoint"})
+
+	RestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "rest_errors_total",
+		Help:      "REST calls that failed or returned an error status.",
+	}, []string{"base", "endpoint"})
+
+	StreamConnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "stream_connects_total",
+		Help:      "Stream connections opened to the base stations.",
+	}, []string{"base"})
+
+	StreamReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "stream_reconnects_total",
+		Help:      "Attempts to connect the stream again after it was lost or could not be opened.",
+	}, []string{"base"})
+
+	StreamDisconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "stream_disconnects_total",
+		Help:      "Stream connections lost or closed.",
+	}, []string{"base"})
+
+	UpdatesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "updates_dropped_total",
+		Help:      "Updates a subscriber recording every packet could not keep up with.",
+	}, []string{"subscriber"})
+
+	StreamUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "stream_up",
+		Help:      "Whether the stream of the base station is connected.",
+	}, []string{"base"})
+)
+
+func init() {
+	Registry.MustRegister(
+		DecodeErrors, RestDuration, RestErrors,
+		StreamConnects, StreamReconnects, StreamDisconnects, StreamUp,
+		UpdatesDropped,
+		deviceCollector{},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+var (
+	deviceLabels = []string{"base", "device"}
+
+	batteryDesc = prometheus.NewDesc(NAMESPACE+"_device_battery_percent",
+		"Battery charge reported in the device list.", deviceLabels, nil)
+	speedDesc = prometheus.NewDesc(NAMESPACE+"_device_speed_meters_per_second",
+		"Speed of the last instantaneous packet.", deviceLabels, nil)
+	hrmDesc = prometheus.NewDesc(NAMESPACE+"_device_heart_rate_bpm",
+		"Heart rate of the last instantaneous packet.", deviceLabels, nil)
+	powerDesc = prometheus.NewDesc(NAMESPACE+"_device_power",
+		"Power of the last instantaneous packet, as reported by the device.", deviceLabels, nil)
+	distanceDesc = prometheus.NewDesc(NAMESPACE+"_device_distance_meters",
+		"Distance of the last cumulative packet.", deviceLabels, nil)
+	slotDesc = prometheus.NewDesc(NAMESPACE+"_device_slot",
+		"Telemetry slot of the device, -1 when not in telemetry.", deviceLabels, nil)
+	liveDesc = prometheus.NewDesc(NAMESPACE+"_device_live",
+		"Whether the device is in telemetry.", deviceLabels, nil)
+	packetsDesc = prometheus.NewDesc(NAMESPACE+"_device_packets_total",
+		"Stream packets received from the device, by type.", append(deviceLabels, "type"), nil)
+	lastSeenDesc = prometheus.NewDesc(NAMESPACE+"_device_last_seen_timestamp_seconds",
+		"Arrival of the last stream packet of the device.", deviceLabels, nil)
+)
+
+// deviceCollector reads the device states at scrape time, so the gauges never
+// outlive the devices.
+type deviceCollector struct{}
+
+func (deviceCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{batteryDesc, speedDesc, hrmDesc, powerDesc,
+		distanceDesc, slotDesc, liveDesc, packetsDesc, lastSeenDesc} {
+		ch <- d
+	}
+}
+
+func (deviceCollector) Collect(ch chan<- prometheus.Metric) {
+	mapping := def.Mapping()
+	for _, d := range def.Snapshot() {
+		id := d.Id.String()
+		gauge := func(desc *prometheus.Desc, v float64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, d.Base, id)
+		}
+		packets := func(kind string, n uint32) {
+			ch <- prometheus.MustNewConstMetric(packetsDesc, prometheus.CounterValue, float64(n), d.Base, id, kind)
+		}
+
+		// 255 means the device list has not been read yet
+		if d.Battery != def.BATTERY_UNKNOWN {
+			gauge(batteryDesc, float64(d.Battery))
+		}
+		gauge(speedDesc, float64(d.Speed))
+		gauge(hrmDesc, float64(d.Hrm))
+		gauge(powerDesc, float64(d.Power))
+		gauge(distanceDesc, float64(d.Distance))
+		if slot, ok := mapping[id]; ok {
+			gauge(slotDesc, float64(slot))
+			gauge(liveDesc, 1)
+		} else {
+			gauge(slotDesc, -1)
+			gauge(liveDesc, 0)
+		}
+		if !d.LastSeen.IsZero() {
+			gauge(lastSeenDesc, float64(d.LastSeen.UnixNano())/1e9)
+		}
+
+		packets("cumulative", d.Counter.NumCumulative)
+		packets("instantaneous", d.Counter.NumInstantaneous)
+		packets("position", d.Counter.NumPosition)
+		packets("other-data-1", d.Counter.NumOtherData1)
+		packets("other-data-2", d.Counter.NumOtherData2)
+		packets("other-data-3", d.Counter.NumOtherData3)
+	}
+}
+
+// Serve exposes the metrics on addr until ctx is cancelled.
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle(METRICS_PATH, promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry}))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	logging.Info("metrics listening", "address", listener.Addr().String(), "path", METRICS_PATH)
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	stop := context.AfterFunc(ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	})
+	defer stop()
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
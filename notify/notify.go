@@ -0,0 +1,225 @@
+// Package notify posts the notable events of the client to webhooks: the
+// sessions starting and stopping, the devices going offline or running low
+// on battery, the commands failing and the alerts of the rules, which also go
+// to the webhook of their rule.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"unolink-client/alerts"
+	"unolink-client/battery"
+	"unolink-client/config"
+	conn "unolink-client/connection"
+	def "unolink-client/definitions"
+	"unolink-client/logging"
+	"unolink-client/training"
+	"unolink-client/units"
+)
+
+// The events a webhook can be notified of.
+const (
+	EventSessionStarted = "session_started"
+	EventSessionStopped = "session_stopped"
+	EventDeviceOffline  = "device_offline"
+	EventDeviceOnline   = "device_online"
+	EventBatteryLow     = "battery_low"
+	EventCommandFailed  = "command_failed"
+	EventAlert          = "alert"
+)
+
+var EVENTS = []string{
+	EventSessionStarted, EventSessionStopped, EventDeviceOffline, EventDeviceOnline,
+	EventBatteryLow, EventCommandFailed, EventAlert,
+}
+
+// Notification is what a webhook receives, as JSON unless it has a template.
+// Data holds the details of the event: the alert, the command or the
+// battery.
+type Notification struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Device  string    `json:"device,omitempty"`
+	Player  string    `json:"player,omitempty"`
+	Session string    `json:"session,omitempty"`
+	Message string    `json:"message"`
+	Data    any       `json:"data,omitempty"`
+}
+
+// deviceNotification is a notification about a device, named after the
+// player wearing it.
+func deviceNotification(event, id string, at time.Time, format string, args ...any) Notification {
+	n := Notification{Event: event, Time: at, Device: id, Player: training.Player(id).Name}
+	name := strings.ToUpper(id)
+	if n.Player != "" {
+		name += " (" + n.Player + ")"
+	}
+	n.Message = strings.ReplaceAll(fmt.Sprintf(format, args...), "%device", name)
+	return n
+}
+
+// Run posts the events to the webhooks, and the alerts to the webhooks of
+// their rules, until ctx is cancelled.
+func Run(ctx context.Context, hooks []*Webhook, ruleHooks map[string]*Webhook) error {
+	for _, h := range hooks {
+		go h.deliver(ctx)
+	}
+	for _, h := range ruleHooks {
+		go h.deliver(ctx)
+	}
+	send := func(n Notification) {
+		logging.Debug("notification", "event", n.Event, "device", n.Device, "message", n.Message)
+		for _, h := range hooks {
+			h.enqueue(n)
+		}
+	}
+
+	updates := conn.Subscribe()
+	sessions := training.Subscribe()
+	raised := alerts.Subscribe()
+	w := newWatcher()
+	ticker := time.NewTicker(alerts.TICK)
+	defer ticker.Stop()
+	for {
+		var notifications []Notification
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			notifications = w.tick(now)
+		case u := <-updates:
+			notifications = w.connection(u, time.Now())
+		case u := <-sessions:
+			notifications = sessionNotifications(u)
+		case u := <-raised:
+			if u, ok := u.(alerts.Raised); ok {
+				n := alertNotification(u.Alert)
+				if h := ruleHooks[u.Rule.Webhook]; h != nil {
+					h.enqueue(n)
+				}
+				notifications = []Notification{n}
+			}
+		}
+		for _, n := range notifications {
+			send(n)
+		}
+	}
+}
+
+func sessionNotifications(u training.Update) []Notification {
+	switch u := u.(type) {
+	case training.SessionStarted:
+		s := u.Session
+		return []Notification{{Event: EventSessionStarted, Time: s.Start, Session: s.Name,
+			Message: fmt.Sprintf("Session %s started", s.Name)}}
+	case training.SessionStopped:
+		s := u.Session
+		return []Notification{{Event: EventSessionStopped, Time: s.End, Session: s.Name,
+			Message: fmt.Sprintf("Session %s ended after %s with %d devices", s.Name, s.Elapsed().Round(time.Second), len(s.Devices)),
+			Data:    map[string]any{"duration_s": s.Elapsed().Seconds(), "devices": len(s.Devices), "laps": len(s.Laps)}}}
+	}
+	return nil
+}
+
+func alertNotification(a alerts.Alert) Notification {
+	format := "%s for %%device"
+	args := []any{a.Rule}
+	if a.Field != "" {
+		u := units.Field(a.Field)
+		format += ": %s"
+		args = append(args, strings.TrimSpace(strconv.FormatFloat(a.Value, 'f', u.Decimals, 64)+" "+u.Symbol))
+	}
+	n := deviceNotification(EventAlert, a.Device, a.Raised, format, args...)
+	n.Data = a
+	return n
+}
+
+// watcher turns the updates of the connection into notifications: a device
+// in telemetry going silent for the configured time, coming back, crossing a
+// battery threshold or a command failing.
+type watcher struct {
+	offline *alerts.Engine
+	packets map[string]time.Time      // last packet of every device
+	status  map[string]battery.Status // battery status of every device
+}
+
+func newWatcher() *watcher {
+	rule := alerts.Rule{Name: "offline", Severity: alerts.SeverityWarning, For: config.Current.Notify.Offline, Live: true}
+	w := &watcher{
+		offline: alerts.NewEngine([]alerts.Rule{rule}),
+		packets: map[string]time.Time{},
+		status:  map[string]battery.Status{},
+	}
+	w.offline.Mapping(def.Mapping(), time.Now())
+	return w
+}
+
+func (w *watcher) connection(u conn.Update, now time.Time) []Notification {
+	switch u := u.(type) {
+	case conn.PacketDecoded:
+		w.packets[u.Device.Id.String()] = u.Device.LastSeen
+		w.offline.State(u.Device, now)
+	case conn.MappingChanged:
+		w.offline.Mapping(u.Mapping, now)
+	case conn.DevicesChanged:
+		return w.batteries(u.Devices, now)
+	case conn.CommandSettled:
+		c := u.Command
+		if c.State == conn.CommandFailed {
+			n := deviceNotification(EventCommandFailed, c.Device, c.Settled, "Command %s failed for %%device after %d attempts", c.Action, c.Attempts)
+			n.Data = map[string]any{"action": c.Action, "attempts": c.Attempts, "issued": c.Issued}
+			return []Notification{n}
+		}
+	case conn.Event:
+		if u.Kind == conn.DeviceRemoved {
+			n := deviceNotification(EventDeviceOffline, u.Device, now, "%%device left base station %s", u.Base)
+			n.Data = map[string]any{"base": u.Base}
+			return []Notification{n}
+		}
+	}
+	return nil
+}
+
+// tick notifies the devices going silent, and those coming back once they
+// send a packet again rather than once they left telemetry.
+func (w *watcher) tick(now time.Time) []Notification {
+	var notifications []Notification
+	for _, u := range w.offline.Tick(now) {
+		switch u := u.(type) {
+		case alerts.Raised:
+			notifications = append(notifications, deviceNotification(EventDeviceOffline, u.Alert.Device, now,
+				"%%device offline: no packet for %s", time.Duration(u.Alert.Value*float64(time.Second)).Round(time.Second)))
+		case alerts.Cleared:
+			if w.packets[u.Alert.Device].After(u.Alert.Raised) {
+				notifications = append(notifications, deviceNotification(EventDeviceOnline, u.Alert.Device, now,
+					"%%device back online after %s", u.Alert.Cleared.Sub(u.Alert.Raised).Round(time.Second)))
+			}
+		}
+	}
+	return notifications
+}
+
+// batteries notifies the devices whose battery crossed the warning or the
+// critical level.
+func (w *watcher) batteries(devices []def.DeviceState, now time.Time) []Notification {
+	var notifications []Notification
+	for _, d := range devices {
+		id := d.Id.String()
+		status := battery.StatusOf(d.Battery)
+		if status == battery.StatusUnknown {
+			continue
+		}
+		prev, known := w.status[id]
+		w.status[id] = status
+		if status > battery.StatusOK && (!known || status > prev) {
+			n := deviceNotification(EventBatteryLow, id, now, "Battery of %%device at %d%% (%s)", d.Battery, status)
+			n.Data = map[string]any{"level": d.Battery, "status": status}
+			notifications = append(notifications, n)
+		}
+	}
+	return notifications
+}
@@ -0,0 +1,195 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"text/template"
+	"time"
+
+	"unolink-client/alerts"
+	"unolink-client/config"
+	"unolink-client/logging"
+)
+
+const (
+	// QUEUE_SIZE is how many notifications wait for a webhook before the
+	// new ones are dropped.
+	QUEUE_SIZE      = 64
+	REQUEST_TIMEOUT = 10 * time.Second
+
+	SIGNATURE_HEADER = "X-Unolink-Signature"
+	EVENT_HEADER     = "X-Unolink-Event"
+)
+
+// Webhook delivers the notifications of its events to a URL, one at a time
+// and in order, retrying the failed deliveries.
+type Webhook struct {
+	url        string
+	events     []string // every event when empty
+	template   *template.Template
+	secret     []byte
+	retries    int
+	retryDelay time.Duration
+	client     *http.Client
+	queue      chan Notification
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// Webhooks checks the webhooks of the configuration.
+func Webhooks(cfg config.Notify) ([]*Webhook, error) {
+	hooks := make([]*Webhook, 0, len(cfg.Webhooks))
+	for i, w := range cfg.Webhooks {
+		if w.URL == "" {
+			return nil, fmt.Errorf("webhook %d: missing url", i+1)
+		}
+		for _, e := range w.Events {
+			if !slices.Contains(EVENTS, e) {
+				return nil, fmt.Errorf("webhook %s: invalid event %q, expected one of %v", w.URL, e, EVENTS)
+			}
+		}
+		h := newWebhook(w.URL, cfg)
+		h.events = w.Events
+		h.secret = []byte(w.Secret)
+		if w.Template != "" {
+			t, err := template.New(w.URL).Funcs(templateFuncs).Parse(w.Template)
+			if err != nil {
+				return nil, fmt.Errorf("webhook %s: %w", w.URL, err)
+			}
+			h.template = t
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// RuleWebhooks returns the webhooks of the alert rules by URL. They receive
+// the alerts of their rules only, with the retries of the configuration.
+func RuleWebhooks(rules []alerts.Rule, cfg config.Notify) map[string]*Webhook {
+	hooks := map[string]*Webhook{}
+	for _, r := range rules {
+		if r.Webhook != "" && hooks[r.Webhook] == nil {
+			hooks[r.Webhook] = newWebhook(r.Webhook, cfg)
+		}
+	}
+	return hooks
+}
+
+func newWebhook(url string, cfg config.Notify) *Webhook {
+	return &Webhook{
+		url:        url,
+		retries:    cfg.Retries,
+		retryDelay: cfg.RetryDelay,
+		client:     &http.Client{Timeout: REQUEST_TIMEOUT},
+		queue:      make(chan Notification, QUEUE_SIZE),
+	}
+}
+
+// enqueue queues a notification when the webhook wants its event.
+func (h *Webhook) enqueue(n Notification) {
+	if len(h.events) > 0 && !slices.Contains(h.events, n.Event) {
+		return
+	}
+	select {
+	case h.queue <- n:
+	default:
+		logging.Warn("notification dropped", "url", h.url, "event", n.Event)
+	}
+}
+
+// deliver posts the queued notifications until ctx is cancelled.
+func (h *Webhook) deliver(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-h.queue:
+			h.post(ctx, n)
+		}
+	}
+}
+
+// post posts a notification, retrying after the network errors and the
+// server errors with an exponential backoff.
+func (h *Webhook) post(ctx context.Context, n Notification) {
+	body, err := h.body(n)
+	if err != nil {
+		logging.Error("cannot render notification", "url", h.url, "event", n.Event, "err", err)
+		return
+	}
+	delay := h.retryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := h.send(ctx, n.Event, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= h.retries {
+			logging.Error("notification failed", "url", h.url, "event", n.Event, "attempts", attempt+1, "err", err)
+			return
+		}
+		logging.Warn("notification failed, retrying", "url", h.url, "event", n.Event, "in", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// body renders the template of the webhook, or the notification as JSON.
+func (h *Webhook) body(n Notification) ([]byte, error) {
+	if h.template == nil {
+		return json.Marshal(n)
+	}
+	var b bytes.Buffer
+	if err := h.template.Execute(&b, n); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// send makes one delivery attempt, telling whether it is worth retrying.
+func (h *Webhook) send(ctx context.Context, event string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EVENT_HEADER, event)
+	if len(h.secret) > 0 {
+		req.Header.Set(SIGNATURE_HEADER, Sign(h.secret, body))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+// Sign returns the signature of a body, "sha256=" followed by its
+// HMAC-SHA256 in hex, as sent in the SIGNATURE_HEADER.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,179 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"unolink-client/alerts"
+	"unolink-client/config"
+)
+
+// server records the requests of a webhook and answers them with statuses,
+// then with 200 once they run out.
+type server struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+	headers  []http.Header
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bodies = append(s.bodies, string(body))
+	s.headers = append(s.headers, r.Header.Clone())
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (s *server) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+// webhook returns the webhook of the configuration w, pointed to a new
+// server.
+func webhook(t *testing.T, w config.Webhook, statuses ...int) (*Webhook, *server) {
+	t.Helper()
+	s := &server{statuses: statuses}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	w.URL = ts.URL
+	hooks, err := Webhooks(config.Notify{Webhooks: []config.Webhook{w}, Retries: 2, RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hooks[0], s
+}
+
+var started = Notification{Event: EventSessionStarted, Time: time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC), Session: "morning", Message: "Session morning started"}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+	}{
+		{name: "delivered", requests: 1},
+		{name: "server error", statuses: []int{500, 502}, requests: 3},
+		{name: "too many requests", statuses: []int{429}, requests: 2},
+		{name: "server error until out of retries", statuses: []int{500, 500, 500, 500}, requests: 3},
+		{name: "client error", statuses: []int{400}, requests: 1},
+		{name: "not found", statuses: []int{404}, requests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, s := webhook(t, config.Webhook{}, tt.statuses...)
+			h.post(context.Background(), started)
+			if got := s.requests(); got != tt.requests {
+				t.Errorf("%d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestEvents(t *testing.T) {
+	h, s := webhook(t, config.Webhook{Events: []string{EventSessionStopped}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.deliver(ctx)
+
+	// delivered in order, the first request tells whether started was
+	h.enqueue(started)
+	h.enqueue(Notification{Event: EventSessionStopped, Session: "morning"})
+	deadline := time.Now().Add(5 * time.Second)
+	for s.requests() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.headers) == 0 || s.headers[0].Get(EVENT_HEADER) != EventSessionStopped {
+		t.Fatalf("delivered %v, want the %s notification only", s.bodies, EventSessionStopped)
+	}
+}
+
+func TestBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		webhook   config.Webhook
+		body      string
+		signature string
+	}{
+		{
+			name:    "json",
+			webhook: config.Webhook{},
+			body:    `{"event":"session_started","time":"2026-01-01T10:00:00Z","session":"morning","message":"Session morning started"}`,
+		},
+		{
+			name:    "template",
+			webhook: config.Webhook{Template: `{"text": {{json .Message}}, "session": "{{.Session}}"}`},
+			body:    `{"text": "Session morning started", "session": "morning"}`,
+		},
+		{
+			name:      "signed",
+			webhook:   config.Webhook{Template: `{"event":"{{.Event}}"}`, Secret: "secret"},
+			body:      `{"event":"session_started"}`,
+			signature: "sha256=2c5877ed967d99ecd41fdc272f8ba518d496ee90f085e8dc13502b043f2bc29c",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, s := webhook(t, tt.webhook)
+			h.post(context.Background(), started)
+			if s.requests() != 1 {
+				t.Fatalf("%d requests", s.requests())
+			}
+			if s.bodies[0] != tt.body {
+				t.Errorf("body %s, want %s", s.bodies[0], tt.body)
+			}
+			if got := s.headers[0].Get(SIGNATURE_HEADER); got != tt.signature {
+				t.Errorf("signature %q, want %q", got, tt.signature)
+			}
+			if got := s.headers[0].Get(EVENT_HEADER); got != EventSessionStarted {
+				t.Errorf("event header %q", got)
+			}
+		})
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook config.Webhook
+	}{
+		{name: "missing url", webhook: config.Webhook{}},
+		{name: "invalid event", webhook: config.Webhook{URL: "http://localhost", Events: []string{"lap_started"}}},
+		{name: "invalid template", webhook: config.Webhook{URL: "http://localhost", Template: "{{.Message"}},
+	}
+	for _, tt := range tests {
+		if _, err := Webhooks(config.Notify{Webhooks: []config.Webhook{tt.webhook}}); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestRuleWebhooks(t *testing.T) {
+	rules := []alerts.Rule{
+		{Name: "heart rate", Webhook: "http://localhost/a"},
+		{Name: "dropout", Webhook: "http://localhost/a"},
+		{Name: "battery", Webhook: "http://localhost/b"},
+		{Name: "speed"},
+	}
+	hooks := RuleWebhooks(rules, config.Notify{Retries: 3})
+	if len(hooks) != 2 || hooks["http://localhost/a"] == nil || hooks["http://localhost/b"] == nil {
+		t.Fatalf("webhooks %v", hooks)
+	}
+	if h := hooks["http://localhost/a"]; len(h.events) != 0 || h.retries != 3 {
+		t.Errorf("webhook %+v", h)
+	}
+}
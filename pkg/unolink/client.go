@@ -0,0 +1,352 @@
+package unolink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRestPort     = 2280
+	DefaultStreamPort   = 2281
+	DefaultPollInterval = time.Second
+	DefaultVO2Max       = 18.18
+
+	// EventBufferSize is the capacity of the channel returned by Events.
+	EventBufferSize = 256
+)
+
+// Options configure a Client. Only Host is required.
+type Options struct {
+	// Name identifies the base station in events. It defaults to Host.
+	Name       string
+	Host       string
+	RestPort   uint16
+	StreamPort uint16
+	// PollInterval is the period between two reads of the device list and
+	// of the telemetry mapping in Run.
+	PollInterval time.Duration
+	// StreamTimeout closes the stream when no packet is received for that
+	// long. Zero disables it.
+	StreamTimeout time.Duration
+	HTTPClient    *http.Client
+	// Registry keeps the state of the devices followed by Run. Clients of
+	// several base stations may share one. A new one is used when nil.
+	Registry *Registry
+}
+
+// Client talks to one base station.
+type Client struct {
+	opts     Options
+	http     *http.Client
+	events   chan Event
+	registry *Registry
+}
+
+func NewClient(opts Options) *Client {
+	if opts.Name == "" {
+		opts.Name = opts.Host
+	}
+	if opts.RestPort == 0 {
+		opts.RestPort = DefaultRestPort
+	}
+	if opts.StreamPort == 0 {
+		opts.StreamPort = DefaultStreamPort
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	c := &Client{
+		opts:     opts,
+		http:     opts.HTTPClient,
+		events:   make(chan Event, EventBufferSize),
+		registry: opts.Registry,
+	}
+	if c.http == nil {
+		c.http = &http.Client{Timeout: 10 * time.Second}
+	}
+	if c.registry == nil {
+		c.registry = NewRegistry()
+	}
+	return c
+}
+
+// Name returns the name of the base station used in events.
+func (c *Client) Name() string {
+	return c.opts.Name
+}
+
+// Registry returns the registry Run keeps the state of the devices in.
+func (c *Client) Registry() *Registry {
+	return c.registry
+}
+
+// Events returns the channel Run publishes to. It is closed when Run
+// returns. Events are dropped rather than blocking Run when nobody reads.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+func (c *Client) url(endpoint string) string {
+	return fmt.Sprintf("http://%s:%d/%s", c.opts.Host, c.opts.RestPort, endpoint)
+}
+
+func (c *Client) get(ctx context.Context, endpoint string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(endpoint), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unolink: %s: %s", endpoint, resp.Status)
+	}
+	return body, nil
+}
+
+// ListDevices returns the devices attached to the base station.
+func (c *Client) ListDevices(ctx context.Context) ([]DeviceInfo, error) {
+	body, err := c.get(ctx, "listDevices")
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Infos []DeviceInfo `json:"infos"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("unolink: listDevices: %w", err)
+	}
+	return resp.Infos, nil
+}
+
+// TelemetryMapping returns the telemetry slot of every device in telemetry.
+func (c *Client) TelemetryMapping(ctx context.Context) (map[string]uint8, error) {
+	body, err := c.get(ctx, "getTelemetryMapping")
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Mapping map[string]uint8 `json:"mapping"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("unolink: getTelemetryMapping: %w", err)
+	}
+	return resp.Mapping, nil
+}
+
+func (c *Client) command(ctx context.Context, endpoint string, devices []string) error {
+	if len(devices) == 0 {
+		return nil
+	}
+	_, err := c.get(ctx, endpoint+"?devices="+strings.Join(devices, "+"))
+	return err
+}
+
+func (c *Client) Activate(ctx context.Context, devices ...string) error {
+	return c.command(ctx, "activate", devices)
+}
+
+func (c *Client) Deactivate(ctx context.Context, devices ...string) error {
+	return c.command(ctx, "deactivate", devices)
+}
+
+func (c *Client) Shutdown(ctx context.Context, devices ...string) error {
+	return c.command(ctx, "shutdown", devices)
+}
+
+func (c *Client) ExitTelemetry(ctx context.Context, devices ...string) error {
+	return c.command(ctx, "exitTelemetry", devices)
+}
+
+// StartTelemetry puts devices in telemetry, all with the same VO2Max.
+func (c *Client) StartTelemetry(ctx context.Context, vo2max float64, devices ...string) error {
+	if len(devices) == 0 {
+		return nil
+	}
+	values := make([]string, len(devices))
+	for i := range values {
+		values[i] = fmt.Sprintf("%.2f", vo2max)
+	}
+	_, err := c.get(ctx, "startTelemetry?devices="+strings.Join(devices, "+")+"&VO2Max="+strings.Join(values, "+"))
+	return err
+}
+
+// StopTelemetry takes every device of the base station out of telemetry.
+func (c *Client) StopTelemetry(ctx context.Context) error {
+	_, err := c.get(ctx, "stopTelemetry")
+	return err
+}
+
+// StreamRequest opens the packet stream once connected to the stream port.
+const StreamRequest = "GET / HTTP/1.0\r\n\r\n"
+
+// Stream reads the packet stream until ctx is cancelled or the connection
+// fails, calling handle for every packet. A packet that cannot be decoded is
+// handed over with a nil Packet and the error of Decode, wrapping
+// ErrUnknownType for a type unknown to the package. It returns nil when ctx
+// is cancelled.
+func (c *Client) Stream(ctx context.Context, handle func(Packet, error)) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", c.opts.Host, c.opts.StreamPort))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if _, err := io.WriteString(conn, StreamRequest); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	buf := make([]byte, PacketSize)
+	for {
+		if c.opts.StreamTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(c.opts.StreamTimeout))
+		}
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return fmt.Errorf("unolink: no packet for %s", c.opts.StreamTimeout)
+			}
+			return err
+		}
+		handle(Decode(buf))
+	}
+}
+
+// Run streams and polls the base station, keeps the registry up to date and
+// publishes Events until ctx is cancelled or a call fails. It returns nil
+// when ctx is cancelled. Run must be called at most once.
+func (c *Client) Run(ctx context.Context) error {
+	defer close(c.events)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+	)
+	fail := func(err error) {
+		if err != nil {
+			once.Do(func() { first = err })
+			cancel()
+		}
+	}
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		fail(c.Stream(ctx, func(p Packet, err error) {
+			if err != nil {
+				c.emit(Event{Kind: PacketRejected, Base: c.opts.Name, Err: err, Battery: -1, Slot: NoSlot, PrevSlot: NoSlot})
+				return
+			}
+			c.registry.Apply(c.opts.Name, p)
+			c.emit(Event{Kind: PacketReceived, Base: c.opts.Name, Device: p.PacketHeader().Device, Packet: p, Battery: -1, Slot: NoSlot, PrevSlot: NoSlot})
+		}))
+	}()
+	go func() {
+		defer wg.Done()
+		fail(c.poll(ctx))
+	}()
+	wg.Wait()
+	return first
+}
+
+func (c *Client) emit(e Event) {
+	select {
+	case c.events <- e:
+	default:
+	}
+}
+
+// poll reads the device list and the telemetry mapping periodically, records
+// them in the registry and publishes the differences with the previous reads.
+func (c *Client) poll(ctx context.Context) error {
+	ticker := time.NewTicker(c.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		list, err := c.ListDevices(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		c.publishList(c.registry.UpdateList(c.opts.Name, list))
+
+		mapping, err := c.TelemetryMapping(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		c.publishMapping(c.registry.UpdateMapping(c.opts.Name, mapping))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishList publishes the differences between two device lists.
+func (c *Client) publishList(changes []ListChange) {
+	for _, change := range changes {
+		info := change.Next
+		if change.Kind == DeviceRemoved {
+			info = change.Prev
+		}
+		a, err := ParseAddress(info.Id)
+		if err != nil {
+			continue
+		}
+		battery := -1
+		if change.Kind != DeviceRemoved {
+			if b, err := info.Battery(); err == nil {
+				battery = b
+			}
+		}
+		c.emit(Event{Kind: change.Kind, Base: c.opts.Name, Device: a, Battery: battery, Slot: NoSlot, PrevSlot: NoSlot})
+	}
+}
+
+// publishMapping publishes the differences between two telemetry mappings.
+func (c *Client) publishMapping(changes []SlotChange) {
+	for _, change := range changes {
+		a, err := ParseAddress(change.Id)
+		if err != nil {
+			continue
+		}
+		c.emit(Event{Kind: SlotChanged, Base: c.opts.Name, Device: a, Battery: -1, Slot: change.Slot, PrevSlot: change.PrevSlot})
+	}
+}
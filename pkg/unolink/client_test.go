@@ -0,0 +1,195 @@
+package unolink
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestStream serves the stream from a stand-in base station that only sends
+// packets once it received the request opening it.
+func TestStream(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	requests := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		var request string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			request += line
+			if line == "\r\n" {
+				break
+			}
+		}
+		requests <- request
+		for _, packet := range []string{
+			"2C0C0B0A030201E11000000000000000000000000000",
+			"990C0B0A030201000000000000000000000000000000",
+		} {
+			b, _ := hex.DecodeString(packet)
+			conn.Write(b)
+		}
+		reader.ReadByte() // until the client closes the stream
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.ParseUint(port, 10, 16)
+	c := NewClient(Options{Host: "127.0.0.1", StreamPort: uint16(p)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var packets []Packet
+	var errs []error
+	err = c.Stream(ctx, func(p Packet, err error) {
+		packets = append(packets, p)
+		errs = append(errs, err)
+		if len(packets) == 2 {
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case request := <-requests:
+		if request != StreamRequest {
+			t.Errorf("request %q, want %q", request, StreamRequest)
+		}
+	default:
+		t.Error("no request received")
+	}
+	if len(packets) != 2 {
+		t.Fatalf("%d packets, want 2", len(packets))
+	}
+	if p, ok := packets[0].(OtherData3Packet); !ok || p.Hmld != 4321 || errs[0] != nil {
+		t.Errorf("first packet %+v, %v", packets[0], errs[0])
+	}
+	if packets[1] != nil || !errors.Is(errs[1], ErrUnknownType) {
+		t.Errorf("second packet %+v, %v, want ErrUnknownType", packets[1], errs[1])
+	}
+}
+
+// TestRun follows a stand-in base station with one device in telemetry
+// sending an instantaneous packet.
+func TestRun(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/listDevices", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"infos":[{"batt":"80%","code":"0A0B0C","fmw":"1.2.0"}]}`)
+	})
+	mux.HandleFunc("/getTelemetryMapping", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"mapping":{"0A0B0C":3}}`)
+	})
+	rest := httptest.NewServer(mux)
+	defer rest.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "\r\n" {
+				break
+			}
+		}
+		b, _ := hex.DecodeString("230C0B0A030201EE4B980000E84000000E4200000000")
+		conn.Write(b)
+		reader.ReadByte() // until the client closes the stream
+	}()
+
+	c := NewClient(Options{
+		Name:         "north",
+		Host:         "127.0.0.1",
+		RestPort:     port(t, rest.Listener.Addr()),
+		StreamPort:   port(t, l.Addr()),
+		PollInterval: 10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+
+	device := Address{0x0A, 0x0B, 0x0C}
+	want := map[EventKind]bool{DeviceAdded: true, SlotChanged: true, PacketReceived: true}
+	for e := range c.Events() {
+		if e.Base != "north" || e.Device != device {
+			t.Errorf("event %s", e)
+		}
+		switch e.Kind {
+		case DeviceAdded:
+			if e.Battery != 80 {
+				t.Errorf("battery %d, want 80", e.Battery)
+			}
+		case SlotChanged:
+			if e.Slot != 3 || e.PrevSlot != NoSlot {
+				t.Errorf("slot %d -> %d, want %d -> 3", e.PrevSlot, e.Slot, NoSlot)
+			}
+		case PacketReceived:
+			if _, ok := e.Packet.(InstantaneousPacket); !ok {
+				t.Errorf("packet %+v", e.Packet)
+			}
+		default:
+			t.Errorf("unexpected event %s", e)
+		}
+		delete(want, e.Kind)
+		if len(want) == 0 {
+			cancel()
+		}
+	}
+	if len(want) != 0 {
+		t.Errorf("events missing: %v", want)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	d, ok := c.Registry().Lookup(device)
+	if !ok {
+		t.Fatal("device not in the registry")
+	}
+	if d.Base != "north" || d.Battery != 80 || d.Firmware != "1.2.0" || d.Slot != 3 || d.Hrm != 152 {
+		t.Errorf("device %+v", d)
+	}
+}
+
+func port(t *testing.T, addr net.Addr) uint16 {
+	t.Helper()
+	_, port, _ := net.SplitHostPort(addr.String())
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uint16(p)
+}
@@ -0,0 +1,41 @@
+// Package unolink talks to Unolink base stations and decodes the data of the
+// devices attached to them.
+//
+// The package has no global state and no dependency outside the standard
+// library. It is made of four parts:
+//
+//   - Decode turns the 22 byte packets of the stream into typed packets.
+//   - Client wraps the REST API and the stream of one base station and can
+//     follow it while publishing Events.
+//   - Event describes what changed: packets, devices appearing or leaving,
+//     battery and telemetry slot changes. DiffList and DiffMapping find
+//     these changes between two reads of the REST API.
+//   - Registry keeps the latest state of every device, from the packets and
+//     the reads of the REST API. Client.Run keeps one up to date, which
+//     several clients may share.
+//
+// The terminal client does not follow its base stations with Client: its
+// connection package keeps its own implementation of the polling and of the
+// stream, for the adaptive polling, the push endpoint and the reconnections.
+// It shares Decode, DiffList and DiffMapping with the package.
+//
+// A minimal program following a base station:
+//
+//	c := unolink.NewClient(unolink.Options{Host: "192.168.1.10"})
+//	go func() {
+//		for e := range c.Events() {
+//			fmt.Println(e)
+//		}
+//	}()
+//	err := c.Run(ctx)
+//	for _, d := range c.Registry().Devices() {
+//		fmt.Println(d.Address, d.Battery, d.Hrm)
+//	}
+//
+// The package is a module of its own, versioned independently of the
+// terminal client with tags of the form pkg/unolink/vX.Y.Z. Version holds
+// the version of the source.
+package unolink
+
+// Version of the package, following semantic versioning.
+const Version = "0.2.0"
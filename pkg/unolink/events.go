@@ -0,0 +1,139 @@
+package unolink
+
+import (
+	"fmt"
+	"sort"
+)
+
+type EventKind int
+
+const (
+	PacketReceived EventKind = iota
+	DeviceAdded
+	DeviceRemoved
+	BatteryChanged
+	SlotChanged
+	PacketRejected
+)
+
+// NoSlot is the slot of a device that is not in telemetry.
+const NoSlot = -1
+
+func (k EventKind) String() string {
+	switch k {
+	case PacketReceived:
+		return "packet-received"
+	case DeviceAdded:
+		return "device-added"
+	case DeviceRemoved:
+		return "device-removed"
+	case BatteryChanged:
+		return "battery-changed"
+	case SlotChanged:
+		return "slot-changed"
+	case PacketRejected:
+		return "packet-rejected"
+	}
+	return "unknown"
+}
+
+// Event is published by Client.Run. Packet is only set for PacketReceived,
+// Battery for the device list events, the slots for SlotChanged and Err for
+// PacketRejected, a packet of the stream that could not be decoded.
+type Event struct {
+	Kind     EventKind
+	Base     string
+	Device   Address
+	Packet   Packet
+	Battery  int
+	Slot     int
+	PrevSlot int
+	Err      error
+}
+
+func (e Event) String() string {
+	switch e.Kind {
+	case PacketReceived:
+		return fmt.Sprintf("%s %s %s %s", e.Base, e.Kind, e.Device, e.Packet.PacketHeader().Type)
+	case SlotChanged:
+		return fmt.Sprintf("%s %s %s %d -> %d", e.Base, e.Kind, e.Device, e.PrevSlot, e.Slot)
+	case PacketRejected:
+		return fmt.Sprintf("%s %s %v", e.Base, e.Kind, e.Err)
+	}
+	return fmt.Sprintf("%s %s %s battery=%d", e.Base, e.Kind, e.Device, e.Battery)
+}
+
+// ListChange is a difference between two device lists of a base station:
+// DeviceAdded, DeviceRemoved or BatteryChanged. Prev is the entry of the
+// previous list, unset for DeviceAdded, and Next the entry of the new one,
+// unset for DeviceRemoved.
+type ListChange struct {
+	Kind EventKind
+	Prev DeviceInfo
+	Next DeviceInfo
+}
+
+// DiffList compares two device lists of the same base station, in the order
+// of the new list followed by the devices removed.
+func DiffList(prev, next []DeviceInfo) []ListChange {
+	var changes []ListChange
+	old := map[string]DeviceInfo{}
+	for _, d := range prev {
+		old[d.Id] = d
+	}
+	seen := map[string]bool{}
+	for _, d := range next {
+		seen[d.Id] = true
+		o, ok := old[d.Id]
+		switch {
+		case !ok:
+			changes = append(changes, ListChange{Kind: DeviceAdded, Next: d})
+		case o.Batt != d.Batt:
+			changes = append(changes, ListChange{Kind: BatteryChanged, Prev: o, Next: d})
+		}
+	}
+	for _, d := range prev {
+		if !seen[d.Id] {
+			changes = append(changes, ListChange{Kind: DeviceRemoved, Prev: d})
+		}
+	}
+	return changes
+}
+
+// SlotChange is a device whose telemetry slot differs between two mappings,
+// NoSlot standing for a device out of telemetry.
+type SlotChange struct {
+	Id       string
+	Slot     int
+	PrevSlot int
+}
+
+// DiffMapping compares two telemetry mappings of the same base station, by
+// device ID.
+func DiffMapping(prev, next map[string]uint8) []SlotChange {
+	ids := make([]string, 0, len(prev)+len(next))
+	for id := range prev {
+		ids = append(ids, id)
+	}
+	for id := range next {
+		if _, ok := prev[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var changes []SlotChange
+	for _, id := range ids {
+		before, after := NoSlot, NoSlot
+		if slot, ok := prev[id]; ok {
+			before = int(slot)
+		}
+		if slot, ok := next[id]; ok {
+			after = int(slot)
+		}
+		if before != after {
+			changes = append(changes, SlotChange{Id: id, Slot: after, PrevSlot: before})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,57 @@
+package unolink
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffList(t *testing.T) {
+	a := DeviceInfo{Id: "0A0B0C", Batt: "80%", Version: "1.2.0"}
+	b := DeviceInfo{Id: "0A0B0D", Batt: "60%", Version: "1.2.0"}
+	lower := b
+	lower.Batt = "59%"
+
+	tests := []struct {
+		name       string
+		prev, next []DeviceInfo
+		want       []ListChange
+	}{
+		{"unchanged", []DeviceInfo{a, b}, []DeviceInfo{b, a}, nil},
+		{"added", []DeviceInfo{a}, []DeviceInfo{a, b}, []ListChange{{Kind: DeviceAdded, Next: b}}},
+		{"removed", []DeviceInfo{a, b}, []DeviceInfo{a}, []ListChange{{Kind: DeviceRemoved, Prev: b}}},
+		{"battery", []DeviceInfo{a, b}, []DeviceInfo{a, lower}, []ListChange{{Kind: BatteryChanged, Prev: b, Next: lower}}},
+		{"first list", nil, []DeviceInfo{a}, []ListChange{{Kind: DeviceAdded, Next: a}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffList(tt.prev, tt.next); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffMapping(t *testing.T) {
+	tests := []struct {
+		name       string
+		prev, next map[string]uint8
+		want       []SlotChange
+	}{
+		{"unchanged", map[string]uint8{"0A0B0C": 1}, map[string]uint8{"0A0B0C": 1}, nil},
+		{"entered", nil, map[string]uint8{"0A0B0C": 0}, []SlotChange{{Id: "0A0B0C", Slot: 0, PrevSlot: NoSlot}}},
+		{"left", map[string]uint8{"0A0B0C": 2}, map[string]uint8{}, []SlotChange{{Id: "0A0B0C", Slot: NoSlot, PrevSlot: 2}}},
+		{
+			"moved",
+			map[string]uint8{"0A0B0C": 2, "0A0B0D": 3},
+			map[string]uint8{"0A0B0C": 3, "0A0B0D": 2},
+			[]SlotChange{{Id: "0A0B0C", Slot: 3, PrevSlot: 2}, {Id: "0A0B0D", Slot: 2, PrevSlot: 3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffMapping(tt.prev, tt.next); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+// Decode prints the content of packets given as hex strings, one per
+// argument or one per line of the standard input.
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ralflici/unolink-client/pkg/unolink"
+)
+
+func main() {
+	lines := os.Args[1:]
+	if len(lines) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+	}
+
+	status := 0
+	for _, line := range lines {
+		b, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(line), " ", ""))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		p, err := unolink.Decode(b)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		h := p.PacketHeader()
+		fmt.Printf("%s %s t=%d %+v\n", h.Device, h.Type, h.Time, p)
+	}
+	os.Exit(status)
+}
@@ -0,0 +1,48 @@
+// Watch follows a base station and prints every event until interrupted.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/ralflici/unolink-client/pkg/unolink"
+)
+
+func main() {
+	host := flag.String("host", "127.0.0.1", "base station address")
+	restPort := flag.Uint("rest-port", unolink.DefaultRestPort, "REST API port")
+	streamPort := flag.Uint("stream-port", unolink.DefaultStreamPort, "stream port")
+	packets := flag.Bool("packets", false, "print every packet, not only device changes")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	c := unolink.NewClient(unolink.Options{
+		Host:          *host,
+		RestPort:      uint16(*restPort),
+		StreamPort:    uint16(*streamPort),
+		StreamTimeout: 30 * time.Second,
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range c.Events() {
+			if e.Kind == unolink.PacketReceived && !*packets {
+				continue
+			}
+			fmt.Println(e)
+		}
+	}()
+
+	err := c.Run(ctx)
+	<-done
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
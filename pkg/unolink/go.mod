@@ -0,0 +1,3 @@
+module github.com/ralflici/unolink-client/pkg/unolink
+
+go 1.22
@@ -0,0 +1,18 @@
+package unolink
+
+import (
+	"strconv"
+	"strings"
+)
+
+// DeviceInfo is an entry of the device list of a base station.
+type DeviceInfo struct {
+	Batt    string `json:"batt"`
+	Id      string `json:"code"`
+	Version string `json:"fmw"`
+}
+
+// Battery returns the state of charge in percent.
+func (i DeviceInfo) Battery() (int, error) {
+	return strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(i.Batt), "%"))
+}
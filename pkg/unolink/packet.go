@@ -0,0 +1,207 @@
+package unolink
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// PacketSize is the size of every packet of the stream.
+const PacketSize = 22
+
+// SpeedConversionFactor converts the raw speed of Instantaneous packets to
+// metres per second.
+const SpeedConversionFactor = 1.94384 * 1000
+
+// PacketType is the first byte of a packet.
+type PacketType byte
+
+const (
+	Cumulative    PacketType = 0x22
+	Instantaneous PacketType = 0x23
+	Position      PacketType = 0x24
+	OtherData1    PacketType = 0x28
+	OtherData2    PacketType = 0x2B
+	OtherData3    PacketType = 0x2C
+)
+
+func (t PacketType) String() string {
+	switch t {
+	case Cumulative:
+		return "cumulative"
+	case Instantaneous:
+		return "instantaneous"
+	case Position:
+		return "position"
+	case OtherData1:
+		return "other-data-1"
+	case OtherData2:
+		return "other-data-2"
+	case OtherData3:
+		return "other-data-3"
+	}
+	return fmt.Sprintf("0x%02X", byte(t))
+}
+
+var (
+	ErrShortPacket = errors.New("unolink: short packet")
+	ErrUnknownType = errors.New("unolink: unknown packet type")
+)
+
+// Address is the radio address of a device.
+type Address [3]byte
+
+func (a Address) String() string {
+	return fmt.Sprintf("%02X%02X%02X", a[0], a[1], a[2])
+}
+
+// ParseAddress parses the six hex digits of an address, as used by the REST
+// API.
+func ParseAddress(s string) (Address, error) {
+	var a Address
+	if len(s) != 2*len(a) {
+		return Address{}, fmt.Errorf("unolink: invalid address %q", s)
+	}
+	for i := range a {
+		b, err := strconv.ParseUint(s[2*i:2*i+2], 16, 8)
+		if err != nil {
+			return Address{}, fmt.Errorf("unolink: invalid address %q", s)
+		}
+		a[i] = byte(b)
+	}
+	return a, nil
+}
+
+// Header is common to every packet. Time is the device clock.
+type Header struct {
+	Type   PacketType
+	Device Address
+	Time   uint32
+}
+
+func (h Header) PacketHeader() Header {
+	return h
+}
+
+// Packet is one of CumulativePacket, InstantaneousPacket, PositionPacket,
+// OtherData1Packet, OtherData2Packet and OtherData3Packet.
+type Packet interface {
+	PacketHeader() Header
+}
+
+type CumulativePacket struct {
+	Header
+	TagId         uint16
+	Energy        float32
+	Distance      float32
+	EquivDistance float32
+}
+
+type InstantaneousPacket struct {
+	Header
+	Speed float32 // metres per second
+	Hrm   uint8
+	Power float32
+	Vo2   float32
+}
+
+type PositionPacket struct {
+	Header
+	Lat uint32
+	Lng uint32
+}
+
+type OtherData1Packet struct {
+	Header
+	PeCounter uint16
+	Acc       uint16
+	Dec       uint16
+	Jump      uint16
+	Impact    uint16
+}
+
+// OtherData2Packet carries the distance covered in each of the five speed
+// bands configured on the device.
+type OtherData2Packet struct {
+	Header
+	CumDistance [5]uint32
+}
+
+type OtherData3Packet struct {
+	Header
+	Hmld uint32
+}
+
+// uint24 reads a little endian 3 byte integer.
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func float32At(b []byte) float32 {
+	return math.Float32frombits(binary.LittleEndian.Uint32(b))
+}
+
+// Decode decodes one packet of the stream. b is not retained.
+func Decode(b []byte) (Packet, error) {
+	if len(b) < PacketSize {
+		return nil, ErrShortPacket
+	}
+	h := Header{
+		Type:   PacketType(b[0]),
+		Device: Address{b[3], b[2], b[1]},
+		Time:   uint24(b[4:7]),
+	}
+
+	switch h.Type {
+	case Cumulative:
+		return CumulativePacket{
+			Header:        h,
+			TagId:         binary.LittleEndian.Uint16(b[7:9]),
+			Energy:        float32At(b[9:13]),
+			Distance:      float32At(b[13:17]),
+			EquivDistance: float32At(b[17:21]),
+		}, nil
+	case Instantaneous:
+		return InstantaneousPacket{
+			Header: h,
+			Speed:  float32(binary.LittleEndian.Uint16(b[7:9])) / SpeedConversionFactor,
+			Hrm:    b[9],
+			Power:  float32At(b[10:14]),
+			Vo2:    float32At(b[14:18]),
+		}, nil
+	case Position:
+		return PositionPacket{
+			Header: h,
+			Lat:    binary.LittleEndian.Uint32(b[7:11]),
+			Lng:    binary.LittleEndian.Uint32(b[11:15]),
+		}, nil
+	case OtherData1:
+		return OtherData1Packet{
+			Header:    h,
+			PeCounter: binary.LittleEndian.Uint16(b[7:9]),
+			Acc:       binary.LittleEndian.Uint16(b[9:11]),
+			Dec:       binary.LittleEndian.Uint16(b[11:13]),
+			Jump:      binary.LittleEndian.Uint16(b[13:15]),
+			Impact:    binary.LittleEndian.Uint16(b[15:17]),
+		}, nil
+	case OtherData2:
+		return OtherData2Packet{
+			Header: h,
+			CumDistance: [5]uint32{
+				uint24(b[7:10]),
+				uint24(b[10:13]),
+				uint24(b[13:16]),
+				uint24(b[16:19]),
+				uint24(b[19:22]),
+			},
+		}, nil
+	case OtherData3:
+		return OtherData3Packet{
+			Header: h,
+			Hmld:   uint24(b[7:10]),
+		}, nil
+	}
+	return nil, fmt.Errorf("%w %s", ErrUnknownType, h.Type)
+}
@@ -0,0 +1,133 @@
+package unolink
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// header is the header of the golden packets: device 0A0B0C at time 0x010203.
+var header = Header{Device: Address{0x0A, 0x0B, 0x0C}, Time: 0x010203}
+
+func withType(t PacketType) Header {
+	h := header
+	h.Type = t
+	return h
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet string
+		want   Packet
+		err    error
+	}{
+		{
+			name:   "cumulative",
+			packet: "220C0B0A03020134120000C94200509A440088BB4400",
+			want: CumulativePacket{
+				Header:        withType(Cumulative),
+				TagId:         0x1234,
+				Energy:        100.5,
+				Distance:      1234.5,
+				EquivDistance: 1500.25,
+			},
+		},
+		{
+			name:   "instantaneous",
+			packet: "230C0B0A030201EE4B980000E84000000E4200000000",
+			want: InstantaneousPacket{
+				Header: withType(Instantaneous),
+				Speed:  19438 / SpeedConversionFactor,
+				Hrm:    152,
+				Power:  7.25,
+				Vo2:    35.5,
+			},
+		},
+		{
+			name:   "position",
+			packet: "240C0B0A03020100A2201B0721700500000000000000",
+			want: PositionPacket{
+				Header: withType(Position),
+				Lat:    455123456,
+				Lng:    91234567,
+			},
+		},
+		{
+			name:   "other data 1",
+			packet: "280C0B0A03020107000C000900030019000000000000",
+			want: OtherData1Packet{
+				Header:    withType(OtherData1),
+				PeCounter: 7,
+				Acc:       12,
+				Dec:       9,
+				Jump:      3,
+				Impact:    25,
+			},
+		},
+		{
+			name:   "other data 2",
+			packet: "2B0C0B0A030201B00400200300C20100780000EFCDAB",
+			want: OtherData2Packet{
+				Header:      withType(OtherData2),
+				CumDistance: [5]uint32{1200, 800, 450, 120, 0xABCDEF},
+			},
+		},
+		{
+			name:   "other data 3",
+			packet: "2C0C0B0A030201E11000000000000000000000000000",
+			want: OtherData3Packet{
+				Header: withType(OtherData3),
+				Hmld:   4321,
+			},
+		},
+		{
+			name:   "unknown type",
+			packet: "990C0B0A030201000000000000000000000000000000",
+			err:    ErrUnknownType,
+		},
+		{
+			name:   "short",
+			packet: "220C0B0A030201",
+			err:    ErrShortPacket,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := hex.DecodeString(tt.packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Decode(b)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		id   string
+		want Address
+		ok   bool
+	}{
+		{"0A0B0C", Address{0x0A, 0x0B, 0x0C}, true},
+		{"0a0b0c", Address{0x0A, 0x0B, 0x0C}, true},
+		{"0A0B0", Address{}, false},
+		{"0A0B0Z", Address{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseAddress(tt.id)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseAddress(%q) = %v, %v", tt.id, got, err)
+		}
+		if tt.ok && got.String() != "0A0B0C" {
+			t.Errorf("String() = %q", got.String())
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package unolink
+
+import (
+	"sync"
+	"time"
+)
+
+// PacketCounter counts the packets received from a device, by type.
+type PacketCounter struct {
+	Cumulative    uint32
+	Instantaneous uint32
+	Position      uint32
+	OtherData1    uint32
+	OtherData2    uint32
+	OtherData3    uint32
+}
+
+func (c PacketCounter) Total() uint32 {
+	return c.Cumulative + c.Instantaneous + c.Position +
+		c.OtherData1 + c.OtherData2 + c.OtherData3
+}
+
+// Device is the latest known state of a device. Battery is -1 until the
+// device list of its base station has been read and Slot is NoSlot while the
+// device is not in telemetry.
+type Device struct {
+	Address  Address
+	Base     string
+	Battery  int
+	Firmware string
+	Slot     int
+	LastSeen time.Time
+	Counter  PacketCounter
+
+	Time          uint32
+	Speed         float32
+	Hrm           uint8
+	Power         float32
+	Vo2           float32
+	Energy        float32
+	Distance      float32
+	EquivDistance float32
+	TagId         uint16
+	PeCounter     uint16
+	Acc           uint16
+	Dec           uint16
+	Jump          uint16
+	Impact        uint16
+	Hmld          uint32
+	CumDistance   [5]uint32
+	Lat           uint32
+	Lng           uint32
+}
+
+// Apply updates the device with the content of a packet.
+func (d *Device) Apply(p Packet) {
+	d.Time = p.PacketHeader().Time
+	switch p := p.(type) {
+	case CumulativePacket:
+		d.Counter.Cumulative++
+		d.TagId = p.TagId
+		d.Energy = p.Energy
+		d.Distance = p.Distance
+		d.EquivDistance = p.EquivDistance
+	case InstantaneousPacket:
+		d.Counter.Instantaneous++
+		d.Speed = p.Speed
+		d.Hrm = p.Hrm
+		d.Power = p.Power
+		d.Vo2 = p.Vo2
+	case PositionPacket:
+		d.Counter.Position++
+		d.Lat = p.Lat
+		d.Lng = p.Lng
+	case OtherData1Packet:
+		d.Counter.OtherData1++
+		d.PeCounter = p.PeCounter
+		d.Acc = p.Acc
+		d.Dec = p.Dec
+		d.Jump = p.Jump
+		d.Impact = p.Impact
+	case OtherData2Packet:
+		d.Counter.OtherData2++
+		d.CumDistance = p.CumDistance
+	case OtherData3Packet:
+		d.Counter.OtherData3++
+		d.Hmld = p.Hmld
+	}
+}
+
+// Registry keeps the state of every device seen, on one or more base
+// stations, along with the last device list and telemetry mapping read from
+// each. It is safe for concurrent use; the methods return copies.
+type Registry struct {
+	mu       sync.Mutex
+	devices  map[Address]*Device
+	order    []Address
+	lists    map[string][]DeviceInfo
+	mappings map[string]map[string]uint8
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		devices:  map[Address]*Device{},
+		lists:    map[string][]DeviceInfo{},
+		mappings: map[string]map[string]uint8{},
+	}
+}
+
+func (r *Registry) get(a Address) *Device {
+	d, ok := r.devices[a]
+	if !ok {
+		d = &Device{Address: a, Battery: -1, Slot: NoSlot}
+		r.devices[a] = d
+		r.order = append(r.order, a)
+	}
+	return d
+}
+
+// Apply records a packet received from base.
+func (r *Registry) Apply(base string, p Packet) Device {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.get(p.PacketHeader().Device)
+	d.Base = base
+	d.LastSeen = time.Now()
+	d.Apply(p)
+	return *d
+}
+
+// SetInfo records an entry of the device list of base.
+func (r *Registry) SetInfo(base string, info DeviceInfo) (Device, error) {
+	a, err := ParseAddress(info.Id)
+	if err != nil {
+		return Device{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.get(a)
+	d.Base = base
+	d.Firmware = info.Version
+	if batt, err := info.Battery(); err == nil {
+		d.Battery = batt
+	}
+	return *d, nil
+}
+
+// UpdateList records the device list of base and returns its differences
+// with the previous one, as found by DiffList. A device that left the list
+// keeps its state but loses its base station, its battery and its slot.
+func (r *Registry) UpdateList(base string, list []DeviceInfo) []ListChange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changes := DiffList(r.lists[base], list)
+	r.lists[base] = list
+	for _, info := range list {
+		a, err := ParseAddress(info.Id)
+		if err != nil {
+			continue
+		}
+		d := r.get(a)
+		d.Base = base
+		d.Firmware = info.Version
+		if batt, err := info.Battery(); err == nil {
+			d.Battery = batt
+		}
+	}
+	for _, change := range changes {
+		if change.Kind != DeviceRemoved {
+			continue
+		}
+		a, err := ParseAddress(change.Prev.Id)
+		if err != nil {
+			continue
+		}
+		if d, ok := r.devices[a]; ok && d.Base == base {
+			d.Base = ""
+			d.Battery = -1
+			d.Slot = NoSlot
+		}
+	}
+	return changes
+}
+
+// UpdateMapping records the telemetry mapping of base and returns its
+// differences with the previous one, as found by DiffMapping.
+func (r *Registry) UpdateMapping(base string, mapping map[string]uint8) []SlotChange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changes := DiffMapping(r.mappings[base], mapping)
+	r.mappings[base] = mapping
+	for _, change := range changes {
+		a, err := ParseAddress(change.Id)
+		if err != nil {
+			continue
+		}
+		r.get(a).Slot = change.Slot
+	}
+	return changes
+}
+
+func (r *Registry) Lookup(a Address) (Device, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d, ok := r.devices[a]
+	if !ok {
+		return Device{}, false
+	}
+	return *d, true
+}
+
+// Devices returns every device, in the order they were first seen.
+func (r *Registry) Devices() []Device {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	devices := make([]Device, 0, len(r.order))
+	for _, a := range r.order {
+		devices = append(devices, *r.devices[a])
+	}
+	return devices
+}
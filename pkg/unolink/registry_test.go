@@ -0,0 +1,131 @@
+package unolink
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestRegistryApply(t *testing.T) {
+	b, _ := hex.DecodeString("230C0B0A030201EE4B980000E84000000E4200000000")
+	p, err := Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry()
+	r.Apply("north", p)
+	r.Apply("north", p)
+
+	d, ok := r.Lookup(Address{0x0A, 0x0B, 0x0C})
+	if !ok {
+		t.Fatal("device not found")
+	}
+	if d.Base != "north" || d.Battery != -1 || d.Slot != NoSlot || d.Time != 66051 {
+		t.Errorf("device %+v", d)
+	}
+	if d.Hrm != 152 || d.Power != 7.25 || d.Vo2 != 35.5 {
+		t.Errorf("instantaneous values %d %v %v", d.Hrm, d.Power, d.Vo2)
+	}
+	if d.Counter.Instantaneous != 2 || d.Counter.Total() != 2 {
+		t.Errorf("counter %+v", d.Counter)
+	}
+	if d.LastSeen.IsZero() {
+		t.Error("last seen not set")
+	}
+}
+
+func TestRegistryUpdateList(t *testing.T) {
+	a := DeviceInfo{Id: "0A0B0C", Batt: "80%", Version: "1.2.0"}
+	b := DeviceInfo{Id: "0A0B0D", Batt: "60%", Version: "1.2.0"}
+	updated := b
+	updated.Batt, updated.Version = "59%", "1.3.0"
+
+	r := NewRegistry()
+	tests := []struct {
+		name    string
+		list    []DeviceInfo
+		changes []EventKind
+		devices []Device
+	}{
+		{
+			name:    "first list",
+			list:    []DeviceInfo{a, b},
+			changes: []EventKind{DeviceAdded, DeviceAdded},
+			devices: []Device{
+				{Address: Address{0x0A, 0x0B, 0x0C}, Base: "north", Battery: 80, Firmware: "1.2.0", Slot: NoSlot},
+				{Address: Address{0x0A, 0x0B, 0x0D}, Base: "north", Battery: 60, Firmware: "1.2.0", Slot: NoSlot},
+			},
+		},
+		{
+			name:    "battery and firmware",
+			list:    []DeviceInfo{a, updated},
+			changes: []EventKind{BatteryChanged},
+			devices: []Device{
+				{Address: Address{0x0A, 0x0B, 0x0C}, Base: "north", Battery: 80, Firmware: "1.2.0", Slot: NoSlot},
+				{Address: Address{0x0A, 0x0B, 0x0D}, Base: "north", Battery: 59, Firmware: "1.3.0", Slot: NoSlot},
+			},
+		},
+		{
+			name:    "removed",
+			list:    []DeviceInfo{updated},
+			changes: []EventKind{DeviceRemoved},
+			devices: []Device{
+				{Address: Address{0x0A, 0x0B, 0x0C}, Battery: -1, Firmware: "1.2.0", Slot: NoSlot},
+				{Address: Address{0x0A, 0x0B, 0x0D}, Base: "north", Battery: 59, Firmware: "1.3.0", Slot: NoSlot},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var kinds []EventKind
+			for _, c := range r.UpdateList("north", tt.list) {
+				kinds = append(kinds, c.Kind)
+			}
+			if !reflect.DeepEqual(kinds, tt.changes) {
+				t.Errorf("changes %v, want %v", kinds, tt.changes)
+			}
+			if got := r.Devices(); !reflect.DeepEqual(got, tt.devices) {
+				t.Errorf("devices %+v, want %+v", got, tt.devices)
+			}
+		})
+	}
+
+	// the list of another base station leaves the devices of north alone
+	if changes := r.UpdateList("south", nil); len(changes) != 0 {
+		t.Errorf("south changes %+v", changes)
+	}
+	if d, _ := r.Lookup(Address{0x0A, 0x0B, 0x0D}); d.Base != "north" {
+		t.Errorf("device moved to %q", d.Base)
+	}
+}
+
+func TestRegistryUpdateMapping(t *testing.T) {
+	r := NewRegistry()
+	r.UpdateList("north", []DeviceInfo{{Id: "0A0B0C", Batt: "80%"}, {Id: "0A0B0D", Batt: "80%"}})
+
+	tests := []struct {
+		name    string
+		mapping map[string]uint8
+		changes []SlotChange
+		slots   []int
+	}{
+		{"in telemetry", map[string]uint8{"0A0B0C": 3}, []SlotChange{{Id: "0A0B0C", Slot: 3, PrevSlot: NoSlot}}, []int{3, NoSlot}},
+		{"unchanged", map[string]uint8{"0A0B0C": 3}, nil, []int{3, NoSlot}},
+		{"moved", map[string]uint8{"0A0B0C": 1, "0A0B0D": 3}, []SlotChange{{Id: "0A0B0C", Slot: 1, PrevSlot: 3}, {Id: "0A0B0D", Slot: 3, PrevSlot: NoSlot}}, []int{1, 3}},
+		{"out of telemetry", map[string]uint8{}, []SlotChange{{Id: "0A0B0C", Slot: NoSlot, PrevSlot: 1}, {Id: "0A0B0D", Slot: NoSlot, PrevSlot: 3}}, []int{NoSlot, NoSlot}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.UpdateMapping("north", tt.mapping); !reflect.DeepEqual(got, tt.changes) {
+				t.Errorf("changes %+v, want %+v", got, tt.changes)
+			}
+			var slots []int
+			for _, d := range r.Devices() {
+				slots = append(slots, d.Slot)
+			}
+			if !reflect.DeepEqual(slots, tt.slots) {
+				t.Errorf("slots %v, want %v", slots, tt.slots)
+			}
+		})
+	}
+}
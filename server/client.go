@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	def "unolink-client/definitions"
+	"unolink-client/logging"
+
+	"github.com/gorilla/websocket"
+)
+
+// subscription selects what a client receives. Empty Fields and Devices
+// select everything. Rate is the most state updates per second and device,
+// capped by the server, and Events turns the event and connection messages
+// on or off (on by default).
+//
+// Clients change their subscription by sending it as a JSON message, e.g.
+// {"fields":["speed","hrm"],"devices":["0A0B0C"],"rate":2}, and receive a new
+// snapshot in return.
+type subscription struct {
+	Fields  []string `json:"fields"`
+	Devices []string `json:"devices"`
+	Rate    float64  `json:"rate"`
+	Events  *bool    `json:"events"`
+}
+
+func (s subscription) wants(id string) bool {
+	if len(s.Devices) == 0 {
+		return true
+	}
+	for _, d := range s.Devices {
+		if d == id {
+			return true
+		}
+	}
+	return false
+}
+
+// client is a WebSocket consumer. States are coalesced per device and sent
+// in batches at the rate of the subscription; events are sent right away. A
+// client that does not keep up is disconnected.
+type client struct {
+	ws        *websocket.Conn
+	maxRate   float64
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	sub      subscription
+	interval time.Duration
+	pending  map[string]def.DeviceState
+}
+
+func newClient(ws *websocket.Conn, maxRate float64) *client {
+	return &client{
+		ws:      ws,
+		maxRate: maxRate,
+		send:    make(chan []byte, CLIENT_BUFFER_SIZE),
+		done:    make(chan struct{}),
+		pending: map[string]def.DeviceState{},
+	}
+}
+
+func (c *client) subscribe(sub subscription) {
+	if sub.Rate <= 0 || sub.Rate > c.maxRate {
+		sub.Rate = c.maxRate
+	}
+	if sub.Events == nil {
+		on := true
+		sub.Events = &on
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sub = sub
+	c.interval = time.Duration(float64(time.Second) / sub.Rate)
+	for id := range c.pending {
+		if !sub.wants(id) {
+			delete(c.pending, id)
+		}
+	}
+}
+
+// offer records the latest state of a device, to be sent at the next flush.
+func (c *client) offer(d def.DeviceState) {
+	id := d.Id.String()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sub.wants(id) {
+		c.pending[id] = d
+	}
+}
+
+// event sends msg unless the client opted out of events or, when device is
+// set, does not follow that device.
+func (c *client) event(device string, msg message) {
+	c.mu.Lock()
+	ok := *c.sub.Events && (device == "" || c.sub.wants(device))
+	c.mu.Unlock()
+	if ok {
+		c.queue(encode(msg))
+	}
+}
+
+func (c *client) queue(b []byte) {
+	select {
+	case c.send <- b:
+	case <-c.done:
+	default:
+		logging.Warn("websocket client too slow, disconnecting", "remote", c.ws.RemoteAddr().String())
+		c.close()
+	}
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.ws.Close()
+	})
+}
+
+// serve runs the client until the connection is closed.
+func (c *client) serve() {
+	c.mu.Lock()
+	sub := c.sub
+	c.mu.Unlock()
+	c.queue(encode(snapshot(sub)))
+
+	go c.write()
+	go c.flush()
+	c.read()
+	c.close()
+}
+
+func (c *client) read() {
+	c.ws.SetReadLimit(MAX_MESSAGE_SIZE)
+	c.ws.SetReadDeadline(time.Now().Add(2 * PING_INTERVAL))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(2 * PING_INTERVAL))
+	})
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.ws.SetReadDeadline(time.Now().Add(2 * PING_INTERVAL))
+
+		var sub subscription
+		if err := json.Unmarshal(data, &sub); err != nil {
+			c.queue(encode(message{Type: "error", Error: "invalid subscription: " + err.Error()}))
+			continue
+		}
+		if err := checkFields(sub.Fields); err != nil {
+			c.queue(encode(message{Type: "error", Error: err.Error()}))
+			continue
+		}
+		for i := range sub.Devices {
+			sub.Devices[i] = strings.ToUpper(sub.Devices[i])
+		}
+		c.subscribe(sub)
+		c.queue(encode(snapshot(sub)))
+	}
+}
+
+func (c *client) write() {
+	ping := time.NewTicker(PING_INTERVAL)
+	defer ping.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case b := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+			if err := c.ws.WriteMessage(websocket.TextMessage, b); err != nil {
+				c.close()
+				return
+			}
+		case <-ping.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(WRITE_TIMEOUT)); err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// flush sends the pending states, at most once per interval.
+func (c *client) flush() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-timer.C:
+		}
+
+		c.mu.Lock()
+		pending := c.pending
+		c.pending = map[string]def.DeviceState{}
+		fields := c.sub.Fields
+		timer.Reset(c.interval)
+		c.mu.Unlock()
+		if len(pending) == 0 {
+			continue
+		}
+
+		ids := make([]string, 0, len(pending))
+		for id := range pending {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		mapping := def.Mapping()
+		devices := make([]map[string]any, 0, len(ids))
+		for _, id := range ids {
+			devices = append(devices, view(pending[id], mapping, fields))
+		}
+		c.queue(encode(message{Type: "states", Devices: devices}))
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	conn "unolink-client/connection"
+	def "unolink-client/definitions"
+	"unolink-client/logging"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	CLIENT_BUFFER_SIZE = 64
+	WRITE_TIMEOUT      = 5 * time.Second
+	PING_INTERVAL      = 30 * time.Second
+	MAX_MESSAGE_SIZE   = 4096
+)
+
+var upgrader = websocket.Upgrader{
+	// the server is meant for the local network, where the tablets and
+	// tools connecting to it are not served from a known origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// hub fans the connection updates out to the WebSocket clients.
+type hub struct {
+	maxRate float64
+
+	mu      sync.Mutex
+	clients map[*client]bool
+}
+
+func newHub(maxRate float64) *hub {
+	return &hub{maxRate: maxRate, clients: map[*client]bool{}}
+}
+
+func (h *hub) run(ctx context.Context, updates <-chan conn.Update) {
+	for {
+		select {
+		case <-ctx.Done():
+			h.mu.Lock()
+			for c := range h.clients {
+				c.close()
+			}
+			h.mu.Unlock()
+			return
+		case u := <-updates:
+			h.broadcast(u)
+		}
+	}
+}
+
+func (h *hub) broadcast(u conn.Update) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch u := u.(type) {
+	case conn.PacketDecoded:
+		for c := range h.clients {
+			c.offer(u.Device)
+		}
+	case conn.Event:
+		msg := message{Type: "event", Event: &event{
+			Kind:        u.Kind.String(),
+			Base:        u.Base,
+			Device:      u.Device,
+			Battery:     u.Battery,
+			PrevBattery: u.PrevBattery,
+			Slot:        u.Slot,
+			PrevSlot:    u.PrevSlot,
+		}}
+		for c := range h.clients {
+			c.event(u.Device, msg)
+		}
+	case conn.ConnectionChanged:
+		msg := message{Type: "connection", Base: u.Base, Link: u.Link, Up: &u.Up}
+		if u.Err != nil {
+			msg.Error = u.Err.Error()
+		}
+		for c := range h.clients {
+			c.event("", msg)
+		}
+	}
+}
+
+// handleWS upgrades the request and serves the client until either side
+// closes the connection. The query takes the same parameters as the
+// subscription messages: fields and devices as comma separated lists, rate
+// and events.
+func (h *hub) handleWS(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var sub subscription
+	var err error
+	if sub.Fields, err = parseFields(q.Get("fields")); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if devices := q.Get("devices"); devices != "" {
+		sub.Devices = strings.Split(strings.ToUpper(devices), ",")
+	}
+	if rate := q.Get("rate"); rate != "" {
+		if sub.Rate, err = strconv.ParseFloat(rate, 64); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if events := q.Get("events"); events != "" {
+		on, err := strconv.ParseBool(events)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		sub.Events = &on
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logging.Warn("websocket upgrade failed", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+	c := newClient(ws, h.maxRate)
+	c.subscribe(sub)
+	logging.Info("websocket client connected", "remote", r.RemoteAddr)
+
+	h.mu.Lock()
+	h.clients[c] = true
+	h.mu.Unlock()
+
+	c.serve()
+
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+	logging.Info("websocket client disconnected", "remote", r.RemoteAddr)
+}
+
+// message is sent to the WebSocket clients. Type is "snapshot", "states",
+// "event", "connection" or "error".
+type message struct {
+	Type    string           `json:"type"`
+	Devices []map[string]any `json:"devices,omitempty"`
+	Event   *event           `json:"event,omitempty"`
+	Base    string           `json:"base,omitempty"`
+	Link    string           `json:"link,omitempty"`
+	Up      *bool            `json:"up,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+type event struct {
+	Kind        string `json:"kind"`
+	Base        string `json:"base"`
+	Device      string `json:"device"`
+	Battery     string `json:"battery,omitempty"`
+	PrevBattery string `json:"prev_battery,omitempty"`
+	Slot        int    `json:"slot"`
+	PrevSlot    int    `json:"prev_slot"`
+}
+
+func encode(msg message) []byte {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		logging.Error("cannot encode message", "type", msg.Type, "err", err)
+	}
+	return b
+}
+
+// snapshot returns every device the subscription selects.
+func snapshot(sub subscription) message {
+	mapping := def.Mapping()
+	devices := []map[string]any{}
+	for _, d := range def.Snapshot() {
+		if sub.wants(d.Id.String()) {
+			devices = append(devices, view(d, mapping, sub.Fields))
+		}
+	}
+	return message{Type: "snapshot", Devices: devices}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"unolink-client/battery"
+	conn "unolink-client/connection"
+	def "unolink-client/definitions"
+	"unolink-client/logging"
+	"unolink-client/units"
+)
+
+const SHUTDOWN_TIMEOUT = time.Second
+
+// Serve re-publishes the devices and the updates of the connection on addr
+// until ctx is cancelled:
+//
+//	GET /devices       every device, ?fields= restricts the metrics
+//	GET /devices/{id}  one device
+//	GET /units         unit of every metric that has one
+//	GET /battery       battery history and estimated runtime of every device
+//	GET /ws            WebSocket stream of states and events, see client
+//
+// maxRate caps the state updates per second and device sent to each
+// WebSocket client.
+func Serve(ctx context.Context, addr string, maxRate float64) error {
+	if maxRate <= 0 {
+		return fmt.Errorf("invalid serve rate %v", maxRate)
+	}
+	h := newHub(maxRate)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	logging.Info("server listening", "address", listener.Addr().String())
+	updates := conn.Subscribe()
+
+	server := &http.Server{Handler: routes(h), ReadHeaderTimeout: 5 * time.Second}
+	stop := context.AfterFunc(ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	})
+	defer stop()
+
+	go h.run(ctx, updates)
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func routes(h *hub) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /devices", handleDevices)
+	mux.HandleFunc("GET /devices/{id}", handleDevice)
+	mux.HandleFunc("GET /units", handleUnits)
+	mux.HandleFunc("GET /battery", handleBattery)
+	mux.HandleFunc("GET /ws", h.handleWS)
+	return mux
+}
+
+// FIELDS are the metrics a client can select: those of every device plus
+// its telemetry slot and whether it is live.
+var FIELDS = append(append([]string(nil), def.FIELDS...), "slot", "live")
+
+// parseFields parses a comma separated list of metrics. An empty list
+// selects every metric.
+func parseFields(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	fields := strings.Split(s, ",")
+	return fields, checkFields(fields)
+}
+
+func checkFields(fields []string) error {
+	for _, f := range fields {
+		known := false
+		for _, k := range FIELDS {
+			known = known || f == k
+		}
+		if !known {
+			return fmt.Errorf("unknown field %q", f)
+		}
+	}
+	return nil
+}
+
+// view is the JSON form of a device in the selected units, restricted to
+// fields when not empty.
+func view(d def.DeviceState, mapping map[string]uint8, fields []string) map[string]any {
+	id := d.Id.String()
+	all := d.Fields()
+	units.ConvertFields(all)
+	slot, live := mapping[id]
+	if live {
+		all["slot"] = int(slot)
+	} else {
+		all["slot"] = conn.NO_SLOT
+	}
+	all["live"] = live
+
+	v := map[string]any{"id": id, "base": d.Base}
+	if len(fields) == 0 {
+		for k, val := range all {
+			v[k] = val
+		}
+		return v
+	}
+	for _, f := range fields {
+		v[f] = all[f]
+	}
+	return v
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Warn("cannot write response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func handleDevices(w http.ResponseWriter, r *http.Request) {
+	fields, err := parseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	mapping := def.Mapping()
+	devices := []map[string]any{}
+	for _, d := range def.Snapshot() {
+		devices = append(devices, view(d, mapping, fields))
+	}
+	writeJSON(w, http.StatusOK, devices)
+}
+
+func handleUnits(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, units.Labels())
+}
+
+func handleBattery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, battery.Snapshot())
+}
+
+func handleDevice(w http.ResponseWriter, r *http.Request) {
+	fields, err := parseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	id := strings.ToUpper(r.PathValue("id"))
+	d, ok := def.Lookup(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown device %q", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, view(d, def.Mapping(), fields))
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"unolink-client/config"
+	conn "unolink-client/connection"
+	def "unolink-client/definitions"
+
+	"github.com/gorilla/websocket"
+)
+
+// INSTANTANEOUS is a packet of device 0A0B0C with a heart rate of 152.
+const INSTANTANEOUS = "230C0B0A030201EE4B980000E84000000E4200000000"
+
+// serve serves the routes of a hub with the given maximum rate, with devices
+// 0A0B0C, in slot 3 and with a heart rate of 152, and 0D0E0F on base
+// station "server".
+func serve(t *testing.T, maxRate float64) (*hub, *httptest.Server) {
+	t.Helper()
+	config.Current = config.Default()
+	def.UpdateDevices("server", []def.ListDevices{{Id: "0A0B0C", Batt: "80%"}, {Id: "0D0E0F", Batt: "60%"}})
+	def.UpdateMapping("server", map[string]uint8{"0A0B0C": 3})
+	packet, _ := hex.DecodeString(INSTANTANEOUS)
+	if _, _, err := def.DecodePacket("server", packet); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newHub(maxRate)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go h.run(ctx, make(chan conn.Update))
+	ts := httptest.NewServer(routes(h))
+	t.Cleanup(ts.Close)
+	return h, ts
+}
+
+func keys(v map[string]any) []string {
+	var k []string
+	for key := range v {
+		k = append(k, key)
+	}
+	sort.Strings(k)
+	return k
+}
+
+func TestDevices(t *testing.T) {
+	_, ts := serve(t, 5)
+	tests := []struct {
+		path   string
+		status int
+		check  func(t *testing.T, body []byte)
+	}{
+		{
+			path:   "/devices",
+			status: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var devices []map[string]any
+				json.Unmarshal(body, &devices)
+				found := 0
+				for _, d := range devices {
+					if d["id"] == "0A0B0C" || d["id"] == "0D0E0F" {
+						found++
+					}
+				}
+				if found != 2 {
+					t.Errorf("devices %s", body)
+				}
+			},
+		},
+		{
+			path:   "/devices?fields=hrm,slot",
+			status: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var devices []map[string]any
+				json.Unmarshal(body, &devices)
+				for _, d := range devices {
+					if got := keys(d); !reflect.DeepEqual(got, []string{"base", "hrm", "id", "slot"}) {
+						t.Errorf("fields %v", got)
+					}
+				}
+			},
+		},
+		{path: "/devices?fields=hrm,heart_rate", status: http.StatusBadRequest},
+		{
+			path:   "/devices/0a0b0c",
+			status: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var d map[string]any
+				json.Unmarshal(body, &d)
+				if d["id"] != "0A0B0C" || d["base"] != "server" || d["hrm"] != 152.0 || d["slot"] != 3.0 || d["live"] != true {
+					t.Errorf("device %s", body)
+				}
+			},
+		},
+		{
+			path:   "/devices/0D0E0F?fields=live,slot",
+			status: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var d map[string]any
+				json.Unmarshal(body, &d)
+				if d["live"] != false || d["slot"] != float64(conn.NO_SLOT) {
+					t.Errorf("device %s", body)
+				}
+			},
+		},
+		{path: "/devices/FFFFFF", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var body json.RawMessage
+			json.NewDecoder(resp.Body).Decode(&body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d: %s", resp.StatusCode, tt.status, body)
+			}
+			if tt.check != nil {
+				tt.check(t, body)
+			}
+		})
+	}
+}
+
+// dial opens a WebSocket to the server with the given query.
+func dial(t *testing.T, ts *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?" + query
+	ws, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v (%v)", url, err, resp)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// receive reads the next message, failing after a while.
+func receive(t *testing.T, ws *websocket.Conn) message {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg message
+	if err := ws.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestWS(t *testing.T) {
+	h, ts := serve(t, 5)
+	ws := dial(t, ts, "fields=hrm&devices=0a0b0c&events=false")
+
+	msg := receive(t, ws)
+	if msg.Type != "snapshot" || len(msg.Devices) != 1 {
+		t.Fatalf("snapshot %+v", msg)
+	}
+	if got := keys(msg.Devices[0]); !reflect.DeepEqual(got, []string{"base", "hrm", "id"}) {
+		t.Errorf("fields %v", got)
+	}
+
+	// states of the devices not followed are left out
+	for _, id := range []string{"0D0E0F", "0A0B0C"} {
+		d, _ := def.Lookup(id)
+		h.broadcast(conn.PacketDecoded{Base: "server", Device: d})
+	}
+	h.broadcast(conn.Event{Kind: conn.DeviceRemoved, Base: "server", Device: "0A0B0C"})
+	msg = receive(t, ws)
+	if msg.Type != "states" || len(msg.Devices) != 1 || msg.Devices[0]["id"] != "0A0B0C" {
+		t.Fatalf("states %+v", msg)
+	}
+
+	ws.WriteJSON(map[string]any{"fields": []string{"heart_rate"}})
+	if msg = receive(t, ws); msg.Type != "error" || !strings.Contains(msg.Error, "heart_rate") {
+		t.Errorf("message %+v, want an error", msg)
+	}
+
+	ws.WriteJSON(subscription{Fields: []string{"battery"}, Devices: []string{"0d0e0f"}})
+	msg = receive(t, ws)
+	if msg.Type != "snapshot" || len(msg.Devices) != 1 || msg.Devices[0]["id"] != "0D0E0F" || msg.Devices[0]["battery"] != 60.0 {
+		t.Errorf("snapshot %+v", msg)
+	}
+}
+
+func TestWSUnknownField(t *testing.T) {
+	_, ts := serve(t, 5)
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?fields=hrm,heart_rate"
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		t.Fatal("upgraded with an unknown field")
+	}
+	if resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("response %v, want %d", resp, http.StatusBadRequest)
+	}
+}
+
+func TestSubscribeRate(t *testing.T) {
+	tests := []struct {
+		rate, want float64
+	}{
+		{0, 5},
+		{-1, 5},
+		{2, 2},
+		{100, 5},
+	}
+	for _, tt := range tests {
+		c := newClient(nil, 5)
+		c.subscribe(subscription{Rate: tt.rate})
+		if c.sub.Rate != tt.want || c.interval != time.Duration(float64(time.Second)/tt.want) {
+			t.Errorf("rate %v: %v every %s, want %v", tt.rate, c.sub.Rate, c.interval, tt.want)
+		}
+	}
+}
+
+// TestRate offers states much faster than the rate of the client, which
+// receives them coalesced.
+func TestRate(t *testing.T) {
+	h, ts := serve(t, 5)
+	ws := dial(t, ts, "rate=2&fields=hrm&devices=0A0B0C&events=false")
+	if msg := receive(t, ws); msg.Type != "snapshot" {
+		t.Fatalf("message %+v, want a snapshot", msg)
+	}
+
+	const period = time.Second
+	d, _ := def.Lookup("0A0B0C")
+	go func() {
+		for hr := uint8(100); hr < 150; hr++ {
+			d.Hrm = hr
+			h.broadcast(conn.PacketDecoded{Base: "server", Device: d})
+			time.Sleep(period / 50)
+		}
+	}()
+
+	var states []message
+	ws.SetReadDeadline(time.Now().Add(period + 700*time.Millisecond))
+	for {
+		var msg message
+		if err := ws.ReadJSON(&msg); err != nil {
+			break
+		}
+		states = append(states, msg)
+	}
+	// one every 500ms, give or take the one at the start and the end
+	if len(states) < 2 || len(states) > 4 {
+		t.Fatalf("%d states in %s at 2 per second", len(states), period)
+	}
+	if last := states[len(states)-1]; last.Devices[0]["hrm"] != 149.0 {
+		t.Errorf("last state %+v, want the latest heart rate", last.Devices[0])
+	}
+}
@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"unolink-client/training"
+)
+
+// Session is a recorded session. Ended is zero while it is running, or when
+// the client did not stop cleanly.
+type Session struct {
+	ID       int64
+	Name     string
+	Started  time.Time
+	Ended    time.Time
+	Stations []string
+	Devices  int
+	Samples  int64
+}
+
+// DeviceSummary sums up the samples of a device during a session.
+type DeviceSummary struct {
+	Device   string
+	Player   string
+	Samples  int64
+	First    time.Time
+	Last     time.Time
+	MaxSpeed float64 // metres per second
+	MaxHrm   int
+	Distance float64 // largest distance reported by the device
+}
+
+// LapRecord is a lap of a session, numbered from 1.
+type LapRecord struct {
+	Number  int
+	Name    string
+	Started time.Time
+	Ended   time.Time
+}
+
+// TotalsRecord holds the totals of a device over a lap, or over the whole
+// session when Lap is 0.
+type TotalsRecord struct {
+	Lap    int
+	Device string
+	Player string
+	training.Totals
+}
+
+// SprintRecord is a sprint of a device during a session.
+type SprintRecord struct {
+	Device string
+	Player string
+	training.Sprint
+}
+
+// CommandRecord is a command settled during a session.
+type CommandRecord struct {
+	Device   string
+	Action   string
+	State    string
+	Attempts int
+	Issued   time.Time
+	Settled  time.Time
+}
+
+const selectSessions = `SELECT s.id, s.name, s.started_at, s.ended_at, s.stations,
+		COUNT(DISTINCT x.device_id), COUNT(x.device_id)
+	FROM sessions s LEFT JOIN samples x ON x.session_id = s.id`
+
+// Sessions returns every session, the latest first.
+func (s *Store) Sessions() ([]Session, error) {
+	rows, err := s.db.Query(selectSessions + " GROUP BY s.id ORDER BY s.id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		ss, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, ss)
+	}
+	return sessions, rows.Err()
+}
+
+// Session returns the session with the given ID.
+func (s *Store) Session(id int64) (Session, error) {
+	ss, err := scanSession(s.db.QueryRow(selectSessions+" WHERE s.id = ? GROUP BY s.id", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, fmt.Errorf("unknown session %d", id)
+	}
+	return ss, err
+}
+
+func scanSession(row interface{ Scan(...any) error }) (Session, error) {
+	var ss Session
+	var started int64
+	var ended sql.NullInt64
+	var stations string
+	if err := row.Scan(&ss.ID, &ss.Name, &started, &ended, &stations, &ss.Devices, &ss.Samples); err != nil {
+		return Session{}, err
+	}
+	ss.Started = time.UnixMilli(started)
+	if ended.Valid {
+		ss.Ended = time.UnixMilli(ended.Int64)
+	}
+	if stations != "" {
+		ss.Stations = strings.Split(stations, ",")
+	}
+	return ss, nil
+}
+
+// Devices sums up the devices that sent data during a session, by ID.
+func (s *Store) Devices(session int64) ([]DeviceSummary, error) {
+	rows, err := s.db.Query(`SELECT x.device_id, COALESCE(r.player, ''), COUNT(*),
+			MIN(x.received), MAX(x.received),
+			COALESCE(MAX(x.speed), 0), COALESCE(MAX(x.hrm), 0), COALESCE(MAX(x.distance), 0)
+		FROM samples x
+		LEFT JOIN roster r ON r.session_id = x.session_id AND r.device_id = x.device_id
+		WHERE x.session_id = ?
+		GROUP BY x.device_id ORDER BY x.device_id`, session)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []DeviceSummary
+	for rows.Next() {
+		var d DeviceSummary
+		var first, last int64
+		if err := rows.Scan(&d.Device, &d.Player, &d.Samples, &first, &last, &d.MaxSpeed, &d.MaxHrm, &d.Distance); err != nil {
+			return nil, err
+		}
+		d.First = time.UnixMilli(first)
+		d.Last = time.UnixMilli(last)
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+// Laps returns the laps of a session in order.
+func (s *Store) Laps(session int64) ([]LapRecord, error) {
+	rows, err := s.db.Query(`SELECT number, name, started_at, ended_at FROM laps
+		WHERE session_id = ? ORDER BY number`, session)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var laps []LapRecord
+	for rows.Next() {
+		var l LapRecord
+		var started int64
+		var ended sql.NullInt64
+		if err := rows.Scan(&l.Number, &l.Name, &started, &ended); err != nil {
+			return nil, err
+		}
+		l.Started = time.UnixMilli(started)
+		if ended.Valid {
+			l.Ended = time.UnixMilli(ended.Int64)
+		}
+		laps = append(laps, l)
+	}
+	return laps, rows.Err()
+}
+
+// Totals returns the totals of a session, those of the whole session first
+// and then lap by lap, by device.
+func (s *Store) Totals(session int64) ([]TotalsRecord, error) {
+	rows, err := s.db.Query(`SELECT t.lap, t.device_id, COALESCE(r.player, ''), t.distance, t.high_speed_distance,
+			t.max_speed, t.accelerations, t.decelerations, t.jumps, t.impacts, t.hmld, t.hr_zones,
+			t.trimp, t.hr_samples, t.hr_invalid, t.speed_bands, t.device_bands
+		FROM totals t
+		LEFT JOIN roster r ON r.session_id = t.session_id AND r.device_id = t.device_id
+		WHERE t.session_id = ?
+		ORDER BY t.lap, t.device_id`, session)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []TotalsRecord
+	for rows.Next() {
+		var t TotalsRecord
+		var zones, bands, deviceBands string
+		if err := rows.Scan(&t.Lap, &t.Device, &t.Player, &t.Distance, &t.HighSpeedDistance, &t.MaxSpeed,
+			&t.Accelerations, &t.Decelerations, &t.Jumps, &t.Impacts, &t.HMLD, &zones,
+			&t.TRIMP, &t.HRSamples, &t.HRInvalid, &bands, &deviceBands); err != nil {
+			return nil, err
+		}
+		if t.SpeedBands, err = splitFloats(bands); err != nil {
+			return nil, fmt.Errorf("invalid speed bands %q: %w", bands, err)
+		}
+		if t.DeviceBands, err = splitFloats(deviceBands); err != nil {
+			return nil, fmt.Errorf("invalid device bands %q: %w", deviceBands, err)
+		}
+		if zones != "" {
+			for _, z := range strings.Split(zones, ",") {
+				ms, err := strconv.ParseInt(z, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid heart rate zones %q: %w", zones, err)
+				}
+				t.HRZones = append(t.HRZones, time.Duration(ms)*time.Millisecond)
+			}
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+func splitFloats(s string) ([]float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var values []float64
+	for _, v := range strings.Split(s, ",") {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, f)
+	}
+	return values, nil
+}
+
+// Sprints returns the sprints of a session by device, in order.
+func (s *Store) Sprints(session int64) ([]SprintRecord, error) {
+	rows, err := s.db.Query(`SELECT x.device_id, COALESCE(r.player, ''), x.started_at, x.duration, x.peak_speed, x.distance
+		FROM sprints x
+		LEFT JOIN roster r ON r.session_id = x.session_id AND r.device_id = x.device_id
+		WHERE x.session_id = ?
+		ORDER BY x.device_id, x.started_at`, session)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sprints []SprintRecord
+	for rows.Next() {
+		var sp SprintRecord
+		var started, duration int64
+		if err := rows.Scan(&sp.Device, &sp.Player, &started, &duration, &sp.PeakSpeed, &sp.Distance); err != nil {
+			return nil, err
+		}
+		sp.Start = time.UnixMilli(started)
+		sp.Duration = time.Duration(duration) * time.Millisecond
+		sprints = append(sprints, sp)
+	}
+	return sprints, rows.Err()
+}
+
+// Commands returns the commands settled during a session, in the order they
+// were issued.
+func (s *Store) Commands(session int64) ([]CommandRecord, error) {
+	rows, err := s.db.Query(`SELECT device_id, action, state, attempts, issued, settled
+		FROM commands WHERE session_id = ? ORDER BY issued`, session)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var commands []CommandRecord
+	for rows.Next() {
+		var c CommandRecord
+		var issued, settled int64
+		if err := rows.Scan(&c.Device, &c.Action, &c.State, &c.Attempts, &issued, &settled); err != nil {
+			return nil, err
+		}
+		c.Issued = time.UnixMilli(issued)
+		c.Settled = time.UnixMilli(settled)
+		commands = append(commands, c)
+	}
+	return commands, rows.Err()
+}
@@ -0,0 +1,425 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"unolink-client/config"
+	conn "unolink-client/connection"
+	"unolink-client/logging"
+	"unolink-client/training"
+
+	"github.com/ralflici/unolink-client/pkg/unolink"
+)
+
+const (
+	FLUSH_INTERVAL = time.Second
+	// STOP_WAIT bounds the wait for the final totals of the session running
+	// when the client stops.
+	STOP_WAIT = 2 * time.Second
+)
+
+// VALUE_COLUMNS are the columns of samples holding the values of the packets.
+// Only those of the type of the packet are set.
+var VALUE_COLUMNS = []string{
+	"tag_id", "energy", "distance", "equiv_distance",
+	"speed", "hrm", "power", "vo2",
+	"lat", "lng",
+	"pe_counter", "acc", "dec", "jump", "impact",
+	"cum_distance_1", "cum_distance_2", "cum_distance_3", "cum_distance_4", "cum_distance_5",
+	"hmld",
+}
+
+var insertSample = "INSERT INTO samples (session_id, device_id, base, received, type, device_time, " +
+	strings.Join(VALUE_COLUMNS, ", ") + ") VALUES (?" +
+	strings.Repeat(", ?", 5+len(VALUE_COLUMNS)) + ")"
+
+const (
+	upsertDevice = `INSERT INTO devices (id, first_seen, last_seen, base) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET last_seen = excluded.last_seen, base = excluded.base`
+	insertCommand = `INSERT INTO commands (session_id, device_id, action, state, attempts, issued, settled)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+	insertTotals = `INSERT OR REPLACE INTO totals (session_id, lap, device_id, distance, high_speed_distance, max_speed,
+		accelerations, decelerations, jumps, impacts, hmld, hr_zones, trimp, hr_samples, hr_invalid,
+		speed_bands, device_bands) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	insertSprint = `INSERT INTO sprints (session_id, device_id, started_at, duration, peak_speed, distance)
+		VALUES (?, ?, ?, ?, ?, ?)`
+)
+
+// StartSession records a session started at start on the given stations.
+func (s *Store) StartSession(name string, start time.Time, stations []string) (int64, error) {
+	res, err := s.db.Exec("INSERT INTO sessions (name, started_at, stations) VALUES (?, ?, ?)",
+		name, start.UnixMilli(), strings.Join(stations, ","))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// AssignRoster records which player wore each device during a session, with
+// the heart rates their load was computed against.
+func (s *Store) AssignRoster(session int64, roster map[string]config.Player) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for device, player := range roster {
+		maxHR, restHR := player.HeartRate()
+		if _, err := tx.Exec("INSERT OR REPLACE INTO roster (session_id, device_id, player, max_hr, rest_hr) VALUES (?, ?, ?, ?, ?)",
+			session, device, player.Name, maxHR, restHR); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveLaps records the laps of a session so far.
+func (s *Store) SaveLaps(session int64, t training.Session) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := saveLaps(tx, session, t); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func saveLaps(tx *sql.Tx, session int64, t training.Session) error {
+	for i, lap := range t.Laps {
+		if _, err := tx.Exec("INSERT OR REPLACE INTO laps (session_id, number, name, started_at, ended_at) VALUES (?, ?, ?, ?, ?)",
+			session, i+1, lap.Name, lap.Start.UnixMilli(), nullMillis(lap.End)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FinishSession records the end, the laps and the totals of a session. A
+// session without end is ended now.
+func (s *Store) FinishSession(session int64, t training.Session) error {
+	end := t.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := saveLaps(tx, session, t); err != nil {
+		return err
+	}
+	if err := saveTotals(tx, session, 0, t.Devices); err != nil {
+		return err
+	}
+	for i, lap := range t.Laps {
+		if err := saveTotals(tx, session, i+1, lap.Devices); err != nil {
+			return err
+		}
+	}
+	for id, totals := range t.Devices {
+		for _, sp := range totals.Sprints {
+			if _, err := tx.Exec(insertSprint, session, id, sp.Start.UnixMilli(), sp.Duration.Milliseconds(),
+				sp.PeakSpeed, sp.Distance); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := tx.Exec("UPDATE sessions SET ended_at = ? WHERE id = ?", end.UnixMilli(), session); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func saveTotals(tx *sql.Tx, session int64, lap int, devices map[string]*training.Totals) error {
+	for id, t := range devices {
+		zones := make([]string, len(t.HRZones))
+		for i, d := range t.HRZones {
+			zones[i] = strconv.FormatInt(d.Milliseconds(), 10)
+		}
+		if _, err := tx.Exec(insertTotals, session, lap, id, t.Distance, t.HighSpeedDistance, t.MaxSpeed,
+			t.Accelerations, t.Decelerations, t.Jumps, t.Impacts, t.HMLD, strings.Join(zones, ","),
+			t.TRIMP, t.HRSamples, t.HRInvalid, joinFloats(t.SpeedBands), joinFloats(t.DeviceBands)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func joinFloats(values []float64) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.FormatFloat(v, 'f', 1, 64)
+	}
+	return strings.Join(s, ",")
+}
+
+func nullMillis(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.UnixMilli(), Valid: true}
+}
+
+// Record stores the training sessions in the database at path until ctx is
+// cancelled: the roster, every decoded packet and the settled commands while
+// a session runs, its laps and totals once it stops, and the devices seen at
+// any time. Writes are batched and committed every FLUSH_INTERVAL.
+func Record(ctx context.Context, path string, stations []string) error {
+	s, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	r := &recorder{store: s, stations: stations, devices: map[string]seen{}}
+	updates := conn.SubscribeSamples("storage")
+	sessions := training.Subscribe()
+	if t, ok := training.Running(); ok {
+		if err := r.begin(t); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(FLUSH_INTERVAL)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+			if err := r.flush(); err != nil {
+				return err
+			}
+		case u := <-updates:
+			r.add(u)
+		case u := <-sessions:
+			if err := r.follow(u); err != nil {
+				return err
+			}
+		}
+	}
+
+	if r.session == 0 {
+		return r.flush()
+	}
+	return r.stop(updates, sessions)
+}
+
+// stop saves the session running when the client stops. Training stops it
+// too and publishes its final totals, which are waited for up to STOP_WAIT;
+// the session is saved with the last totals received when they do not come.
+func (r *recorder) stop(updates <-chan conn.Update, sessions <-chan training.Update) error {
+	timeout := time.NewTimer(STOP_WAIT)
+	defer timeout.Stop()
+	for r.session != 0 {
+		select {
+		case <-timeout.C:
+			logging.Warn("final session totals not received", "session", r.session, "after", STOP_WAIT)
+			return r.finish(r.last)
+		case u := <-updates:
+			r.add(u)
+		case u := <-sessions:
+			if err := r.follow(u); err != nil {
+				return err
+			}
+		}
+	}
+	return r.flush()
+}
+
+type seen struct {
+	first, last time.Time
+	base        string
+}
+
+// recorder buffers the updates between two flushes. Samples and commands
+// are only kept while a session, the last state of which is last, runs.
+type recorder struct {
+	store    *Store
+	stations []string
+	session  int64
+	last     training.Session
+	samples  []conn.PacketDecoded
+	devices  map[string]seen
+	commands []conn.Command
+}
+
+func (r *recorder) add(u conn.Update) {
+	switch u := u.(type) {
+	case conn.PacketDecoded:
+		if u.Packet == nil {
+			return
+		}
+		r.see(u.Device.Id.String(), u.Base, u.Device.LastSeen)
+		if r.session != 0 {
+			r.samples = append(r.samples, u)
+		}
+	case conn.DevicesChanged:
+		now := time.Now()
+		for _, d := range u.Devices {
+			r.see(d.Id.String(), d.Base, now)
+		}
+	case conn.CommandSettled:
+		if r.session != 0 {
+			r.commands = append(r.commands, u.Command)
+		}
+	}
+}
+
+func (r *recorder) follow(u training.Update) error {
+	switch u := u.(type) {
+	case training.SessionStarted:
+		if r.session != 0 {
+			if err := r.finish(r.last); err != nil {
+				return err
+			}
+		}
+		return r.begin(u.Session)
+	case training.LapStarted:
+		r.last = u.Session
+		if r.session != 0 {
+			return r.store.SaveLaps(r.session, u.Session)
+		}
+	case training.TotalsChanged:
+		r.last = u.Session
+	case training.SessionStopped:
+		if r.session != 0 {
+			return r.finish(u.Session)
+		}
+	}
+	return nil
+}
+
+func (r *recorder) begin(t training.Session) error {
+	if err := r.flush(); err != nil {
+		return err
+	}
+	id, err := r.store.StartSession(t.Name, t.Start, r.stations)
+	if err != nil {
+		return err
+	}
+	if err := r.store.AssignRoster(id, t.Players); err != nil {
+		return err
+	}
+	r.session, r.last = id, t
+	logging.Info("session recorded", "session", id, "name", t.Name)
+	return nil
+}
+
+func (r *recorder) finish(t training.Session) error {
+	if err := r.flush(); err != nil {
+		return err
+	}
+	if err := r.store.FinishSession(r.session, t); err != nil {
+		return err
+	}
+	logging.Info("session saved", "session", r.session, "name", t.Name)
+	r.session = 0
+	return nil
+}
+
+func (r *recorder) see(id, base string, at time.Time) {
+	d, ok := r.devices[id]
+	if !ok {
+		d.first = at
+	}
+	d.last = at
+	d.base = base
+	r.devices[id] = d
+}
+
+// flush writes the buffered updates in one transaction.
+func (r *recorder) flush() error {
+	if len(r.samples) == 0 && len(r.devices) == 0 && len(r.commands) == 0 {
+		return nil
+	}
+	tx, err := r.store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := r.writeSamples(tx); err != nil {
+		return err
+	}
+	for id, d := range r.devices {
+		if _, err := tx.Exec(upsertDevice, id, d.first.UnixMilli(), d.last.UnixMilli(), d.base); err != nil {
+			return err
+		}
+	}
+	for _, c := range r.commands {
+		if _, err := tx.Exec(insertCommand, r.session, c.Device, c.Action, c.State.String(), c.Attempts,
+			c.Issued.UnixMilli(), c.Settled.UnixMilli()); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logging.Debug("session flushed", "session", r.session, "samples", len(r.samples),
+		"devices", len(r.devices), "commands", len(r.commands))
+	r.samples = r.samples[:0]
+	r.devices = map[string]seen{}
+	r.commands = r.commands[:0]
+	return nil
+}
+
+func (r *recorder) writeSamples(tx *sql.Tx) error {
+	if len(r.samples) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare(insertSample)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	args := make([]any, 6+len(VALUE_COLUMNS))
+	for _, u := range r.samples {
+		h := u.Packet.PacketHeader()
+		args[0] = r.session
+		args[1] = u.Device.Id.String()
+		args[2] = u.Base
+		args[3] = u.Device.LastSeen.UnixMilli()
+		args[4] = h.Type.String()
+		args[5] = h.Time
+		values := packetValues(u.Packet)
+		for i, col := range VALUE_COLUMNS {
+			args[6+i] = values[col]
+		}
+		if _, err := stmt.Exec(args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packetValues returns the values of a packet by column.
+func packetValues(p unolink.Packet) map[string]any {
+	switch p := p.(type) {
+	case unolink.CumulativePacket:
+		return map[string]any{"tag_id": p.TagId, "energy": p.Energy, "distance": p.Distance, "equiv_distance": p.EquivDistance}
+	case unolink.InstantaneousPacket:
+		return map[string]any{"speed": p.Speed, "hrm": p.Hrm, "power": p.Power, "vo2": p.Vo2}
+	case unolink.PositionPacket:
+		return map[string]any{"lat": p.Lat, "lng": p.Lng}
+	case unolink.OtherData1Packet:
+		return map[string]any{"pe_counter": p.PeCounter, "acc": p.Acc, "dec": p.Dec, "jump": p.Jump, "impact": p.Impact}
+	case unolink.OtherData2Packet:
+		return map[string]any{
+			"cum_distance_1": p.CumDistance[0], "cum_distance_2": p.CumDistance[1], "cum_distance_3": p.CumDistance[2],
+			"cum_distance_4": p.CumDistance[3], "cum_distance_5": p.CumDistance[4],
+		}
+	case unolink.OtherData3Packet:
+		return map[string]any{"hmld": p.Hmld}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	conn "unolink-client/connection"
+	"unolink-client/training"
+)
+
+var start = time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+func open(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "unolink.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// session is a session of one device that ran distance metres.
+func session(distance float64, stopped bool) training.Session {
+	t := training.Session{
+		Name:  "morning",
+		Start: start,
+		Laps:  []*training.Lap{{Name: "Lap 1", Start: start, Devices: map[string]*training.Totals{}}},
+		Devices: map[string]*training.Totals{"0A0B0C": {
+			Distance: distance,
+			HRZones:  []time.Duration{time.Minute, 2 * time.Second},
+			Sprints:  []training.Sprint{{Start: start.Add(time.Minute), Duration: 3 * time.Second, PeakSpeed: 8, Distance: 21}},
+		}},
+	}
+	if stopped {
+		t.End = start.Add(time.Hour)
+		t.Laps[0].End = t.End
+	}
+	return t
+}
+
+func TestFinishSession(t *testing.T) {
+	s := open(t)
+	id, err := s.StartSession("morning", start, []string{"north"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FinishSession(id, session(1200, true)); err != nil {
+		t.Fatal(err)
+	}
+
+	ss, err := s.Session(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ss.Ended.Equal(start.Add(time.Hour)) {
+		t.Errorf("ended %s", ss.Ended)
+	}
+	laps, err := s.Laps(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(laps) != 1 || laps[0].Number != 1 || laps[0].Name != "Lap 1" || laps[0].Ended.IsZero() {
+		t.Errorf("laps %+v", laps)
+	}
+	totals, err := s.Totals(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 || totals[0].Lap != 0 || totals[0].Distance != 1200 ||
+		!reflect.DeepEqual(totals[0].HRZones, []time.Duration{time.Minute, 2 * time.Second}) {
+		t.Errorf("totals %+v", totals)
+	}
+	sprints, err := s.Sprints(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sprints) != 1 || sprints[0].Duration != 3*time.Second || sprints[0].PeakSpeed != 8 {
+		t.Errorf("sprints %+v", sprints)
+	}
+	if _, err := s.Session(id + 1); err == nil {
+		t.Error("unknown session found")
+	}
+}
+
+// TestStop saves the session running when the client stops, with the final
+// totals when training publishes them in time and the last ones otherwise.
+func TestStop(t *testing.T) {
+	tests := []struct {
+		name     string
+		stopped  bool
+		distance float64
+	}{
+		{name: "final totals", stopped: true, distance: 1200},
+		{name: "timeout", distance: 900},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := open(t)
+			r := &recorder{store: s, devices: map[string]seen{}}
+			if err := r.begin(session(0, false)); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.follow(training.TotalsChanged{Session: session(900, false)}); err != nil {
+				t.Fatal(err)
+			}
+			id := r.session
+
+			sessions := make(chan training.Update, 1)
+			if tt.stopped {
+				sessions <- training.SessionStopped{Session: session(1200, true)}
+			}
+			if err := r.stop(make(chan conn.Update), sessions); err != nil {
+				t.Fatal(err)
+			}
+			if r.session != 0 {
+				t.Error("session still running")
+			}
+			totals, err := s.Totals(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(totals) != 1 || totals[0].Distance != tt.distance {
+				t.Errorf("totals %+v, want a distance of %v", totals, tt.distance)
+			}
+			ss, err := s.Session(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ss.Ended.IsZero() {
+				t.Error("session not ended")
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const BUSY_TIMEOUT = 5 * time.Second
+
+// migrations bring the schema from one version to the next; the version of
+// a database is the number of migrations applied to it. Never edit a
+// migration that has been released, append a new one instead.
+var migrations = []string{
+	// 1: sessions, devices, roster, samples and commands
+	`CREATE TABLE sessions (
+		id         INTEGER PRIMARY KEY,
+		started_at INTEGER NOT NULL,
+		ended_at   INTEGER,
+		stations   TEXT NOT NULL
+	);
+	CREATE TABLE devices (
+		id         TEXT PRIMARY KEY,
+		first_seen INTEGER NOT NULL,
+		last_seen  INTEGER NOT NULL,
+		base       TEXT NOT NULL
+	);
+	CREATE TABLE roster (
+		session_id INTEGER NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+		device_id  TEXT NOT NULL,
+		player     TEXT NOT NULL,
+		PRIMARY KEY (session_id, device_id)
+	);
+	CREATE TABLE samples (
+		session_id     INTEGER NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+		device_id      TEXT NOT NULL,
+		base           TEXT NOT NULL,
+		received       INTEGER NOT NULL,
+		type           TEXT NOT NULL,
+		device_time    INTEGER NOT NULL,
+		tag_id         INTEGER,
+		energy         REAL,
+		distance       REAL,
+		equiv_distance REAL,
+		speed          REAL,
+		hrm            INTEGER,
+		power          REAL,
+		vo2            REAL,
+		lat            INTEGER,
+		lng            INTEGER,
+		pe_counter     INTEGER,
+		acc            INTEGER,
+		dec            INTEGER,
+		jump           INTEGER,
+		impact         INTEGER,
+		cum_distance_1 INTEGER,
+		cum_distance_2 INTEGER,
+		cum_distance_3 INTEGER,
+		cum_distance_4 INTEGER,
+		cum_distance_5 INTEGER,
+		hmld           INTEGER
+	);
+	CREATE INDEX samples_session_device ON samples (session_id, device_id, received);
+	CREATE TABLE commands (
+		session_id INTEGER NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+		device_id  TEXT NOT NULL,
+		action     TEXT NOT NULL,
+		state      TEXT NOT NULL,
+		attempts   INTEGER NOT NULL,
+		issued     INTEGER NOT NULL,
+		settled    INTEGER NOT NULL
+	);
+	CREATE INDEX commands_session ON commands (session_id, issued);`,
+
+	// 2: training sessions with their laps and totals
+	`ALTER TABLE sessions ADD COLUMN name TEXT NOT NULL DEFAULT '';
+	CREATE TABLE laps (
+		session_id INTEGER NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+		number     INTEGER NOT NULL,
+		name       TEXT NOT NULL,
+		started_at INTEGER NOT NULL,
+		ended_at   INTEGER,
+		PRIMARY KEY (session_id, number)
+	);
+	CREATE TABLE totals (
+		session_id          INTEGER NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+		lap                 INTEGER NOT NULL, -- 0 for the whole session
+		device_id           TEXT NOT NULL,
+		distance            REAL NOT NULL,
+		high_speed_distance REAL NOT NULL,
+		max_speed           REAL NOT NULL,
+		accelerations       INTEGER NOT NULL,
+		decelerations       INTEGER NOT NULL,
+		jumps               INTEGER NOT NULL,
+		impacts             INTEGER NOT NULL,
+		hmld                REAL NOT NULL,
+		hr_zones            TEXT NOT NULL, -- milliseconds in each zone, comma separated
+		PRIMARY KEY (session_id, lap, device_id)
+	);`,
+
+	// 3: heart rates of the players, training load and heart rate signal quality
+	`ALTER TABLE roster ADD COLUMN max_hr INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE roster ADD COLUMN rest_hr INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE totals ADD COLUMN trimp REAL NOT NULL DEFAULT 0;
+	ALTER TABLE totals ADD COLUMN hr_samples INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE totals ADD COLUMN hr_invalid INTEGER NOT NULL DEFAULT 0;`,
+
+	// 4: speed bands and sprints
+	`ALTER TABLE totals ADD COLUMN speed_bands TEXT NOT NULL DEFAULT ''; -- metres in each band, comma separated
+	ALTER TABLE totals ADD COLUMN device_bands TEXT NOT NULL DEFAULT '';
+	CREATE TABLE sprints (
+		session_id INTEGER NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+		device_id  TEXT NOT NULL,
+		started_at INTEGER NOT NULL,
+		duration   INTEGER NOT NULL, -- milliseconds
+		peak_speed REAL NOT NULL,
+		distance   REAL NOT NULL
+	);
+	CREATE INDEX sprints_session ON sprints (session_id, device_id, started_at);`,
+}
+
+// Store is the SQLite database holding the recorded sessions.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens the database at path, creating it if needed, and migrates it to
+// the latest schema.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(%d)",
+		path, BUSY_TIMEOUT.Milliseconds())
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// a single connection serialises the writes and keeps the pragmas
+	db.SetMaxOpenConns(1)
+
+	s := &Store{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// migrate applies the migrations the database has not seen yet, each in a
+// transaction of its own.
+func (s *Store) migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this client (%d)", version, len(migrations))
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
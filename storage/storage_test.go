@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func version(t *testing.T, s *Store) int {
+	t.Helper()
+	var v int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int // migrations applied before Open, -1 for none at all
+		err     string
+	}{
+		{name: "new database", version: -1},
+		{name: "first schema", version: 1},
+		{name: "up to date", version: len(migrations)},
+		{name: "newer than the client", version: len(migrations) + 1, err: "newer than this client"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sub", "unolink.db")
+			if tt.version >= 0 {
+				if err := prepare(path, tt.version); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s, err := Open(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if v := version(t, s); v != len(migrations) {
+				t.Errorf("version %d, want %d", v, len(migrations))
+			}
+			// the columns of the last migration are there
+			if _, err := s.db.Exec("SELECT speed_bands, device_bands FROM totals"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// prepare creates a database at path with the first n migrations applied,
+// only setting the version when n is beyond them.
+func prepare(path string, n int) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for i := 0; i < n && i < len(migrations); i++ {
+		if _, err := db.Exec(migrations[i]); err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec(fmt.Sprintf("PRAGMA user_version = %d", n))
+	return err
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unolink.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.StartSession("morning", start, []string{"north"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	sessions, err := s.Sessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].Name != "morning" || sessions[0].Stations[0] != "north" {
+		t.Errorf("sessions %+v", sessions)
+	}
+}
@@ -0,0 +1,74 @@
+package training
+
+import (
+	"fmt"
+	"sync"
+
+	"unolink-client/logging"
+)
+
+const EVENT_BUFFER_SIZE = 64
+
+// Update is implemented by everything published to subscribers:
+// SessionStarted, LapStarted, TotalsChanged and SessionStopped. Each carries
+// a copy of the session as it was at that moment.
+type Update interface {
+	update()
+}
+
+type SessionStarted struct {
+	Session Session
+}
+
+func (SessionStarted) update() {}
+
+// LapStarted reports a new lap, the last of Session.Laps.
+type LapStarted struct {
+	Session Session
+}
+
+func (LapStarted) update() {}
+
+// TotalsChanged is published every TOTALS_INTERVAL while a session runs.
+type TotalsChanged struct {
+	Session Session
+}
+
+func (TotalsChanged) update() {}
+
+// SessionStopped carries the final totals of the session.
+type SessionStopped struct {
+	Session Session
+}
+
+func (SessionStopped) update() {}
+
+var (
+	subscribersMu sync.Mutex
+	subscribers   []chan Update
+)
+
+// Subscribe returns a channel receiving every update from now on. Slow
+// subscribers lose updates rather than blocking the sessions.
+func Subscribe() <-chan Update {
+	ch := make(chan Update, EVENT_BUFFER_SIZE)
+	subscribersMu.Lock()
+	subscribers = append(subscribers, ch)
+	subscribersMu.Unlock()
+	return ch
+}
+
+func publish(u Update) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	for _, ch := range subscribers {
+		select {
+		case ch <- u:
+		default:
+			// totals are superseded by the next ones
+			if _, ok := u.(TotalsChanged); !ok {
+				logging.Warn("session update dropped", "update", fmt.Sprintf("%T", u))
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package training
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"unolink-client/units"
+)
+
+const FILE_TIME_FORMAT = "20060102-150405"
+
+// SUMMARY_COLUMNS follow the drill, device and player columns of a summary,
+// suffixed with their units. The time in each heart rate zone comes after
+// them, in seconds, then the distance in each speed band, integrated and
+// counted by the device.
+var SUMMARY_COLUMNS = []string{
+	"distance", "high_speed_distance", "max_speed",
+	"accelerations", "decelerations", "jumps", "impacts", "hmld",
+	"trimp", "edwards", "hr_quality",
+	"sprints", "sprint_distance", "band_deviation",
+}
+
+// SPRINT_COLUMNS are the columns of a sprint list.
+var SPRINT_COLUMNS = []string{"drill", "device", "player", "start", "duration_s", "peak_speed", "distance"}
+
+// Export writes the summary of a session to a CSV file in dir and returns
+// its path. The totals of the whole session come first, with an empty drill,
+// followed by those of every lap.
+func Export(dir string, s Session) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, s.Start.Format(FILE_TIME_FORMAT)+"-"+FileName(s.Name)+".csv")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var zones, bands, deviceBands int
+	for _, t := range s.Devices {
+		zones = max(zones, len(t.HRZones))
+		bands = max(bands, len(t.SpeedBands))
+		deviceBands = max(deviceBands, len(t.DeviceBands))
+	}
+	header := append([]string{"drill", "device", "player"}, columns(SUMMARY_COLUMNS)...)
+	for i := 0; i < zones; i++ {
+		header = append(header, fmt.Sprintf("hr_zone_%d_s", i+1))
+	}
+	for i := 0; i < bands; i++ {
+		header = append(header, fmt.Sprintf("%s_%d", units.Column("speed_band"), i+1))
+	}
+	for i := 0; i < deviceBands; i++ {
+		header = append(header, fmt.Sprintf("%s_%d", units.Column("device_band"), i+1))
+	}
+
+	w := csv.NewWriter(f)
+	w.Write(header)
+	write := func(drill string, devices map[string]*Totals) {
+		for _, id := range SortedDevices(devices) {
+			t := devices[id]
+			row := []string{drill, id, s.Players[id].Name}
+			row = append(row, SummaryValues(*t)...)
+			for i := 0; i < zones; i++ {
+				var seconds float64
+				if i < len(t.HRZones) {
+					seconds = t.HRZones[i].Seconds()
+				}
+				row = append(row, strconv.FormatFloat(seconds, 'f', 0, 64))
+			}
+			row = append(row, bandValues("speed_band", t.SpeedBands, bands)...)
+			row = append(row, bandValues("device_band", t.DeviceBands, deviceBands)...)
+			w.Write(row)
+		}
+	}
+	write("", s.Devices)
+	for _, lap := range s.Laps {
+		write(lap.Name, lap.Devices)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
+
+func columns(names []string) []string {
+	c := make([]string, len(names))
+	for i, name := range names {
+		c[i] = units.Column(name)
+	}
+	return c
+}
+
+// value formats the value of a metric in its selected unit, to the
+// thousandth.
+func value(name string, v float64) string {
+	return strconv.FormatFloat(math.Round(units.Convert(name, v)*1000)/1000, 'f', -1, 64)
+}
+
+// SummaryValues formats totals in the order of SUMMARY_COLUMNS.
+func SummaryValues(t Totals) []string {
+	f := func(v float64, prec int) string { return strconv.FormatFloat(v, 'f', prec, 64) }
+	return []string{
+		value("distance", t.Distance), value("high_speed_distance", t.HighSpeedDistance),
+		value("max_speed", t.MaxSpeed),
+		strconv.Itoa(t.Accelerations), strconv.Itoa(t.Decelerations),
+		strconv.Itoa(t.Jumps), strconv.Itoa(t.Impacts), value("hmld", t.HMLD),
+		f(t.TRIMP, 1), f(t.Edwards(), 1), f(t.HRQuality(), 3),
+		strconv.Itoa(len(t.Sprints)), value("sprint_distance", t.SprintDistance()), f(t.BandDeviation(), 3),
+	}
+}
+
+func bandValues(name string, distances []float64, n int) []string {
+	values := make([]string, n)
+	for i := range values {
+		var d float64
+		if i < len(distances) {
+			d = distances[i]
+		}
+		values[i] = value(name, d)
+	}
+	return values
+}
+
+// ExportSprints writes the sprints of a session to a CSV file in dir, next to
+// its summary, and returns its path. Each sprint is listed with the lap it
+// started in, if any.
+func ExportSprints(dir string, s Session) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, s.Start.Format(FILE_TIME_FORMAT)+"-"+FileName(s.Name)+"-sprints.csv")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write(columns(SPRINT_COLUMNS))
+	for _, id := range SortedDevices(s.Devices) {
+		for _, sp := range s.Devices[id].Sprints {
+			w.Write([]string{
+				s.LapAt(sp.Start), id, s.Players[id].Name,
+				sp.Start.Format(time.RFC3339Nano),
+				strconv.FormatFloat(sp.Duration.Seconds(), 'f', 1, 64),
+				value("peak_speed", sp.PeakSpeed),
+				value("distance", sp.Distance),
+			})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// SortedDevices returns the device IDs of totals in order.
+func SortedDevices(devices map[string]*Totals) []string {
+	ids := make([]string, 0, len(devices))
+	for id := range devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// FileName makes a session name usable in a file name.
+func FileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', ' ':
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,323 @@
+package training
+
+import (
+	"math"
+	"time"
+
+	def "unolink-client/definitions"
+
+	"github.com/ralflici/unolink-client/pkg/unolink"
+)
+
+// MAX_SAMPLE_GAP is the longest time between two instantaneous packets of a
+// device that is still accounted for; longer gaps are dropouts.
+const MAX_SAMPLE_GAP = 2 * time.Second
+
+// Heart rates outside MIN_HR and MAX_HR, or moving by more than MAX_HR_JUMP
+// from one packet to the next, are bad signal rather than heart rates. 0 is
+// sent when the strap has no signal at all.
+const (
+	MIN_HR      = 30
+	MAX_HR      = 230
+	MAX_HR_JUMP = 30
+)
+
+// HRPlausible tells whether hr can be a heart rate.
+func HRPlausible(hr uint8) bool {
+	return hr >= MIN_HR && hr <= MAX_HR
+}
+
+// Totals sums up what a device did during a session or a lap. Distance,
+// accelerations, decelerations, jumps, impacts, HMLD and the device bands are
+// the increase of the counters of the device; high speed distance, the
+// distance in the speed bands, the sprints, the time in the heart rate zones
+// and TRIMP are integrated over the instantaneous packets, leaving out the
+// heart rates with bad signal.
+type Totals struct {
+	Distance          float64 // metres
+	HighSpeedDistance float64 // metres
+	MaxSpeed          float64 // metres per second
+	Accelerations     int
+	Decelerations     int
+	Jumps             int
+	Impacts           int
+	HMLD              float64
+	HRZones           []time.Duration // time spent in each zone of the configuration
+	TRIMP             float64         // Banister's training impulse
+	HRSamples         int
+	HRInvalid         int       // heart rate samples with bad signal
+	SpeedBands        []float64 // metres in each speed band of the configuration
+	DeviceBands       []float64 // metres in each speed band of the device
+	Sprints           []Sprint
+}
+
+// Sprint is an effort above the sprint speed lasting at least the sprint
+// duration.
+type Sprint struct {
+	Start     time.Time
+	Duration  time.Duration
+	PeakSpeed float64 // metres per second
+	Distance  float64 // metres
+}
+
+// SprintDistance is the distance covered in sprints.
+func (t Totals) SprintDistance() float64 {
+	var d float64
+	for _, s := range t.Sprints {
+		d += s.Distance
+	}
+	return d
+}
+
+// BandDeviation compares the distance integrated in the speed bands to the
+// one counted by the device, as a fraction of the latter; 0 when the device
+// counted nothing.
+func (t Totals) BandDeviation() float64 {
+	var integrated, counted float64
+	for _, d := range t.SpeedBands {
+		integrated += d
+	}
+	for _, d := range t.DeviceBands {
+		counted += d
+	}
+	if counted == 0 {
+		return 0
+	}
+	return (integrated - counted) / counted
+}
+
+// Edwards is the summated heart rate zones load: the minutes spent in each
+// zone weighted by its number. The default zones, 50 to 100% of the maximum
+// heart rate by steps of 10, are those of Edwards.
+func (t Totals) Edwards() float64 {
+	var load float64
+	for i, d := range t.HRZones {
+		load += d.Minutes() * float64(i+1)
+	}
+	return load
+}
+
+// HRQuality is the fraction of heart rate samples with a good signal, 1
+// without samples.
+func (t Totals) HRQuality() float64 {
+	if t.HRSamples == 0 {
+		return 1
+	}
+	return 1 - float64(t.HRInvalid)/float64(t.HRSamples)
+}
+
+func (t *Totals) add(o Totals) {
+	t.Distance += o.Distance
+	t.HighSpeedDistance += o.HighSpeedDistance
+	t.MaxSpeed = max(t.MaxSpeed, o.MaxSpeed)
+	t.Accelerations += o.Accelerations
+	t.Decelerations += o.Decelerations
+	t.Jumps += o.Jumps
+	t.Impacts += o.Impacts
+	t.HMLD += o.HMLD
+	t.TRIMP += o.TRIMP
+	t.HRSamples += o.HRSamples
+	t.HRInvalid += o.HRInvalid
+	t.HRZones = addSlice(t.HRZones, o.HRZones)
+	t.SpeedBands = addSlice(t.SpeedBands, o.SpeedBands)
+	t.DeviceBands = addSlice(t.DeviceBands, o.DeviceBands)
+	t.Sprints = append(t.Sprints, o.Sprints...)
+}
+
+// addSlice adds o to s element by element, growing s as needed.
+func addSlice[T time.Duration | float64](s, o []T) []T {
+	for i, v := range o {
+		for len(s) <= i {
+			s = append(s, 0)
+		}
+		s[i] += v
+	}
+	return s
+}
+
+func (t Totals) clone() Totals {
+	t.HRZones = append([]time.Duration(nil), t.HRZones...)
+	t.SpeedBands = append([]float64(nil), t.SpeedBands...)
+	t.DeviceBands = append([]float64(nil), t.DeviceBands...)
+	t.Sprints = append([]Sprint(nil), t.Sprints...)
+	return t
+}
+
+// baseline holds the last counters of a device, the increments being
+// computed against them.
+type baseline struct {
+	distance    float32
+	hasDistance bool
+	acc, dec    uint16
+	jump        uint16
+	impact      uint16
+	hasOther1   bool
+	cumDistance [5]uint32
+	hasOther2   bool
+	hmld        uint32
+	hasHmld     bool
+	instant     time.Time // arrival of the last instantaneous packet
+	hr          uint8     // last plausible heart rate
+	sprint      Sprint    // running while Start is set
+}
+
+// athlete holds what the totals of a device depend on besides its packets.
+type athlete struct {
+	highSpeed      float64
+	bands          []float64
+	sprintSpeed    float64
+	sprintDuration time.Duration
+	maxHR          uint8
+	restHR         uint8
+	female         bool
+	zones          []float64
+}
+
+// trimp is Banister's training impulse of dt spent at hr, weighted by the
+// heart rate reserve.
+func trimp(hr uint8, a athlete, dt time.Duration) float64 {
+	if a.maxHR <= a.restHR {
+		return 0
+	}
+	r := (float64(hr) - float64(a.restHR)) / float64(a.maxHR-a.restHR)
+	r = min(max(r, 0), 1)
+	k, b := 0.64, 1.92
+	if a.female {
+		k, b = 0.86, 1.67
+	}
+	return dt.Minutes() * r * k * math.Exp(b*r)
+}
+
+// counterDelta is the increase of a counter of the device. A counter going
+// back means the device restarted and counts from zero again.
+func counterDelta[T uint16 | uint32](prev, cur T) T {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// increment returns what a packet adds to the totals of its device, and
+// moves the baseline forward.
+func (b *baseline) increment(d def.DeviceState, p unolink.Packet, a athlete) Totals {
+	var inc Totals
+	switch p := p.(type) {
+	case unolink.CumulativePacket:
+		if b.hasDistance {
+			if p.Distance >= b.distance {
+				inc.Distance = float64(p.Distance - b.distance)
+			} else {
+				inc.Distance = float64(p.Distance)
+			}
+		}
+		b.distance, b.hasDistance = p.Distance, true
+	case unolink.InstantaneousPacket:
+		inc.MaxSpeed = float64(p.Speed)
+		hrOK := HRPlausible(p.Hrm) && (b.hr == 0 || absDiff(p.Hrm, b.hr) <= MAX_HR_JUMP)
+		inc.HRSamples = 1
+		if !hrOK {
+			inc.HRInvalid = 1
+		}
+		dt := d.LastSeen.Sub(b.instant)
+		if !b.instant.IsZero() && dt > 0 && dt <= MAX_SAMPLE_GAP {
+			distance := inc.MaxSpeed * dt.Seconds()
+			if inc.MaxSpeed >= a.highSpeed {
+				inc.HighSpeedDistance = distance
+			}
+			if band := Band(inc.MaxSpeed, a.bands); band >= 0 {
+				inc.SpeedBands = make([]float64, len(a.bands))
+				inc.SpeedBands[band] = distance
+			}
+			if inc.MaxSpeed >= a.sprintSpeed {
+				if b.sprint.Start.IsZero() {
+					b.sprint.Start = b.instant
+				}
+				b.sprint.Duration = d.LastSeen.Sub(b.sprint.Start)
+				b.sprint.PeakSpeed = max(b.sprint.PeakSpeed, inc.MaxSpeed)
+				b.sprint.Distance += distance
+			} else if s, ok := b.endSprint(a.sprintDuration); ok {
+				inc.Sprints = []Sprint{s}
+			}
+			if hrOK {
+				if z := Zone(p.Hrm, a.maxHR, a.zones); z >= 0 {
+					inc.HRZones = make([]time.Duration, len(a.zones))
+					inc.HRZones[z] = dt
+				}
+				inc.TRIMP = trimp(p.Hrm, a, dt)
+			}
+		} else if s, ok := b.endSprint(a.sprintDuration); ok {
+			// a dropout ends the sprint
+			inc.Sprints = []Sprint{s}
+		}
+		b.instant = d.LastSeen
+		// a lasting change is only flagged once
+		if HRPlausible(p.Hrm) {
+			b.hr = p.Hrm
+		}
+	case unolink.OtherData1Packet:
+		if b.hasOther1 {
+			inc.Accelerations = int(counterDelta(b.acc, p.Acc))
+			inc.Decelerations = int(counterDelta(b.dec, p.Dec))
+			inc.Jumps = int(counterDelta(b.jump, p.Jump))
+			inc.Impacts = int(counterDelta(b.impact, p.Impact))
+		}
+		b.acc, b.dec, b.jump, b.impact, b.hasOther1 = p.Acc, p.Dec, p.Jump, p.Impact, true
+	case unolink.OtherData2Packet:
+		if b.hasOther2 {
+			inc.DeviceBands = make([]float64, len(p.CumDistance))
+			for i, cur := range p.CumDistance {
+				inc.DeviceBands[i] = float64(counterDelta(b.cumDistance[i], cur))
+			}
+		}
+		b.cumDistance, b.hasOther2 = p.CumDistance, true
+	case unolink.OtherData3Packet:
+		if b.hasHmld {
+			inc.HMLD = float64(counterDelta(b.hmld, p.Hmld))
+		}
+		b.hmld, b.hasHmld = p.Hmld, true
+	}
+	return inc
+}
+
+// endSprint ends the running sprint, if any, and returns it when it lasted
+// long enough.
+func (b *baseline) endSprint(shortest time.Duration) (Sprint, bool) {
+	s := b.sprint
+	b.sprint = Sprint{}
+	return s, !s.Start.IsZero() && s.Duration >= shortest
+}
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// Zone returns the heart rate zone of hr, from 0, or -1 below the first one.
+// zones holds the lower bound of each zone as a fraction of maxHR.
+func Zone(hr, maxHR uint8, zones []float64) int {
+	if hr == 0 || maxHR == 0 {
+		return -1
+	}
+	fraction := float64(hr) / float64(maxHR)
+	zone := -1
+	for i, lower := range zones {
+		if fraction >= lower {
+			zone = i
+		}
+	}
+	return zone
+}
+
+// Band returns the speed band of speed, from 0, or -1 below the first one.
+// bands holds the lower bound of each band.
+func Band(speed float64, bands []float64) int {
+	band := -1
+	for i, lower := range bands {
+		if speed >= lower {
+			band = i
+		}
+	}
+	return band
+}
@@ -0,0 +1,280 @@
+package training
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	def "unolink-client/definitions"
+
+	"github.com/ralflici/unolink-client/pkg/unolink"
+)
+
+var epoch = time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+// player is the athlete of the synthetic sessions.
+var player = athlete{
+	highSpeed:      5.5,
+	bands:          []float64{0, 4, 7},
+	sprintSpeed:    7,
+	sprintDuration: 2 * time.Second,
+	maxHR:          200,
+	restHR:         60,
+	zones:          []float64{0.5, 0.6, 0.7, 0.8, 0.9},
+}
+
+// sample is a packet received at a time since epoch.
+type sample struct {
+	at     time.Duration
+	packet unolink.Packet
+}
+
+func instant(at time.Duration, speed float32, hr uint8) sample {
+	return sample{at, unolink.InstantaneousPacket{Speed: speed, Hrm: hr}}
+}
+
+// run returns a sample every second from from to to included, at the same
+// speed and without heart rate.
+func run(from, to time.Duration, speed float32) []sample {
+	var samples []sample
+	for at := from; at <= to; at += time.Second {
+		samples = append(samples, instant(at, speed, 0))
+	}
+	return samples
+}
+
+func concat(samples ...[]sample) []sample {
+	var all []sample
+	for _, s := range samples {
+		all = append(all, s...)
+	}
+	return all
+}
+
+func TestIncrement(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []sample
+		want    Totals
+	}{
+		{
+			name: "heart rate plausibility",
+			samples: []sample{
+				instant(0, 0, 150),
+				instant(time.Second, 0, 0),          // no signal
+				instant(2*time.Second, 0, 250),      // above MAX_HR
+				instant(3*time.Second, 0, 151),      // zone 2
+				instant(4*time.Second, 0, 190),      // jump from 151
+				instant(5*time.Second, 0, 192),      // zone 4, the jump was lasting
+				instant(6*time.Second, 0, MIN_HR-1), // below MIN_HR
+			},
+			want: Totals{
+				HRSamples:  7,
+				HRInvalid:  4,
+				HRZones:    []time.Duration{0, 0, time.Second, 0, time.Second},
+				TRIMP:      trimp(151, player, time.Second) + trimp(192, player, time.Second),
+				SpeedBands: []float64{0, 0, 0},
+			},
+		},
+		{
+			name:    "dropout",
+			samples: concat(run(0, time.Second, 5), run(4*time.Second, 5*time.Second, 5)),
+			want:    Totals{MaxSpeed: 5, HRSamples: 4, HRInvalid: 4, SpeedBands: []float64{0, 10, 0}},
+		},
+		{
+			name: "speed bands",
+			samples: []sample{
+				instant(0, 3, 0),
+				instant(time.Second, 3, 0),
+				instant(2*time.Second, 5, 0),
+				instant(3*time.Second, 8, 0), // too short for a sprint
+				instant(4*time.Second, 6, 0),
+			},
+			want: Totals{MaxSpeed: 8, HighSpeedDistance: 14, HRSamples: 5, HRInvalid: 5, SpeedBands: []float64{3, 11, 8}},
+		},
+		{
+			name:    "sprint",
+			samples: concat(run(0, 3*time.Second, 8), run(4*time.Second, 4*time.Second, 3)),
+			want: Totals{
+				MaxSpeed: 8, HighSpeedDistance: 24, HRSamples: 5, HRInvalid: 5, SpeedBands: []float64{3, 0, 24},
+				Sprints: []Sprint{{Start: epoch, Duration: 3 * time.Second, PeakSpeed: 8, Distance: 24}},
+			},
+		},
+		{
+			name:    "sprint ended by a dropout",
+			samples: concat(run(0, 3*time.Second, 8), run(10*time.Second, 10*time.Second, 8)),
+			want: Totals{
+				MaxSpeed: 8, HighSpeedDistance: 24, HRSamples: 5, HRInvalid: 5, SpeedBands: []float64{0, 0, 24},
+				Sprints: []Sprint{{Start: epoch, Duration: 3 * time.Second, PeakSpeed: 8, Distance: 24}},
+			},
+		},
+		{
+			name: "counters",
+			samples: []sample{
+				{0, unolink.CumulativePacket{Distance: 100}},
+				{0, unolink.OtherData1Packet{Acc: 3, Dec: 1, Jump: 65534, Impact: 0}},
+				{0, unolink.OtherData2Packet{CumDistance: [5]uint32{10, 20, 0, 0, 0}}},
+				{0, unolink.OtherData3Packet{Hmld: 65530}},
+				{time.Second, unolink.CumulativePacket{Distance: 150}},
+				{time.Second, unolink.OtherData1Packet{Acc: 5, Dec: 1, Jump: 65535, Impact: 2}},
+				{time.Second, unolink.OtherData2Packet{CumDistance: [5]uint32{15, 20, 0, 0, 0}}},
+				{time.Second, unolink.OtherData3Packet{Hmld: 65540}},
+				// the device restarted
+				{2 * time.Second, unolink.CumulativePacket{Distance: 20}},
+				{2 * time.Second, unolink.OtherData1Packet{Acc: 1, Dec: 0, Jump: 1, Impact: 2}},
+				{2 * time.Second, unolink.OtherData2Packet{CumDistance: [5]uint32{3, 4, 0, 0, 0}}},
+				{2 * time.Second, unolink.OtherData3Packet{Hmld: 5}},
+			},
+			want: Totals{
+				Distance: 70, Accelerations: 3, Jumps: 2, Impacts: 2, HMLD: 15,
+				DeviceBands: []float64{8, 4, 0, 0, 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b baseline
+			var got Totals
+			for _, s := range tt.samples {
+				d := def.DeviceState{LastSeen: epoch.Add(s.at)}
+				got.add(b.increment(d, s.packet, player))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("totals %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHRPlausible(t *testing.T) {
+	for hr, want := range map[uint8]bool{0: false, MIN_HR - 1: false, MIN_HR: true, 150: true, MAX_HR: true, MAX_HR + 1: false} {
+		if got := HRPlausible(hr); got != want {
+			t.Errorf("%d: %v, want %v", hr, got, want)
+		}
+	}
+}
+
+func TestTrimp(t *testing.T) {
+	female := player
+	female.female = true
+	unknown := player
+	unknown.maxHR = unknown.restHR
+
+	tests := []struct {
+		name string
+		hr   uint8
+		a    athlete
+		dt   time.Duration
+		want float64
+	}{
+		{name: "at rest", hr: 60, a: player, dt: time.Minute, want: 0},
+		{name: "below rest", hr: 50, a: player, dt: time.Minute, want: 0},
+		{name: "half the reserve", hr: 130, a: player, dt: time.Minute, want: 0.5 * 0.64 * math.Exp(1.92*0.5)},
+		{name: "maximum", hr: 200, a: player, dt: 2 * time.Minute, want: 2 * 0.64 * math.Exp(1.92)},
+		{name: "above the maximum", hr: 220, a: player, dt: time.Minute, want: 0.64 * math.Exp(1.92)},
+		{name: "female", hr: 200, a: female, dt: time.Minute, want: 0.86 * math.Exp(1.67)},
+		{name: "heart rates unknown", hr: 150, a: unknown, dt: time.Minute, want: 0},
+	}
+	for _, tt := range tests {
+		if got := trimp(tt.hr, tt.a, tt.dt); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEdwards(t *testing.T) {
+	tests := []struct {
+		zones []time.Duration
+		want  float64
+	}{
+		{nil, 0},
+		{[]time.Duration{time.Minute, 2 * time.Minute, 0, 0, time.Minute}, 1 + 4 + 5},
+		{[]time.Duration{0, 0, 30 * time.Second}, 1.5},
+	}
+	for _, tt := range tests {
+		if got := (Totals{HRZones: tt.zones}).Edwards(); got != tt.want {
+			t.Errorf("%v: %v, want %v", tt.zones, got, tt.want)
+		}
+	}
+}
+
+func TestZone(t *testing.T) {
+	tests := []struct {
+		hr, maxHR uint8
+		want      int
+	}{
+		{0, 200, -1},
+		{150, 0, -1},
+		{90, 200, -1},
+		{100, 200, 0},
+		{139, 200, 1},
+		{140, 200, 2},
+		{200, 200, 4},
+		{210, 200, 4},
+	}
+	for _, tt := range tests {
+		if got := Zone(tt.hr, tt.maxHR, player.zones); got != tt.want {
+			t.Errorf("%d of %d: zone %d, want %d", tt.hr, tt.maxHR, got, tt.want)
+		}
+	}
+}
+
+func TestBand(t *testing.T) {
+	tests := []struct {
+		speed float64
+		bands []float64
+		want  int
+	}{
+		{0, player.bands, 0},
+		{3.9, player.bands, 0},
+		{4, player.bands, 1},
+		{12, player.bands, 2},
+		{1, []float64{2, 4}, -1},
+		{1, nil, -1},
+	}
+	for _, tt := range tests {
+		if got := Band(tt.speed, tt.bands); got != tt.want {
+			t.Errorf("%v in %v: band %d, want %d", tt.speed, tt.bands, got, tt.want)
+		}
+	}
+}
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name      string
+		prev, cur uint32
+		want      uint32
+	}{
+		{"unchanged", 10, 10, 0},
+		{"increased", 10, 15, 5},
+		{"restarted", 4000000000, 7, 7},
+	}
+	for _, tt := range tests {
+		if got := counterDelta(tt.prev, tt.cur); got != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if got := counterDelta[uint16](65535, 2); got != 2 {
+		t.Errorf("uint16 restarted: %d, want 2", got)
+	}
+}
+
+func TestBandDeviation(t *testing.T) {
+	tests := []struct {
+		name              string
+		integrated, count []float64
+		want              float64
+	}{
+		{name: "nothing counted", integrated: []float64{100}, want: 0},
+		{name: "equal", integrated: []float64{100, 50}, count: []float64{120, 30}, want: 0},
+		{name: "over", integrated: []float64{110}, count: []float64{100}, want: 0.1},
+		{name: "under", integrated: []float64{40, 35}, count: []float64{50, 50}, want: -0.25},
+	}
+	for _, tt := range tests {
+		got := Totals{SpeedBands: tt.integrated, DeviceBands: tt.count}.BandDeviation()
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
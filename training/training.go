@@ -0,0 +1,355 @@
+package training
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"unolink-client/config"
+	conn "unolink-client/connection"
+	"unolink-client/logging"
+)
+
+const (
+	TOTALS_INTERVAL = time.Second
+	NAME_FORMAT     = "2006-01-02 15:04"
+)
+
+var (
+	ErrRunning    = errors.New("a session is already running")
+	ErrNotRunning = errors.New("no session is running")
+)
+
+// Lap is a named part of a session, such as a drill. It ends when the next
+// one starts or the session stops.
+type Lap struct {
+	Name    string
+	Start   time.Time
+	End     time.Time
+	Devices map[string]*Totals
+}
+
+// Session runs from Start to Stop, or from the start to the stop of the
+// telemetry. Devices holds the totals of the whole session, every lap its
+// own. Players maps the devices to the roster at the start.
+type Session struct {
+	Name    string
+	Start   time.Time
+	End     time.Time
+	Laps    []*Lap
+	Devices map[string]*Totals
+	Players map[string]config.Player
+
+	auto bool // started by the telemetry
+}
+
+// Elapsed is the duration of the session so far.
+func (s Session) Elapsed() time.Duration {
+	if s.End.IsZero() {
+		return time.Since(s.Start)
+	}
+	return s.End.Sub(s.Start)
+}
+
+// CurrentLap returns the lap running, if any.
+func (s Session) CurrentLap() (*Lap, bool) {
+	if len(s.Laps) == 0 || !s.Laps[len(s.Laps)-1].End.IsZero() {
+		return nil, false
+	}
+	return s.Laps[len(s.Laps)-1], true
+}
+
+// LapAt returns the name of the lap running at t, empty outside the laps.
+func (s Session) LapAt(t time.Time) string {
+	for _, l := range s.Laps {
+		if !t.Before(l.Start) && (l.End.IsZero() || t.Before(l.End)) {
+			return l.Name
+		}
+	}
+	return ""
+}
+
+// clone copies the session deeply enough to be handed to other goroutines.
+func (s *Session) clone() Session {
+	c := *s
+	c.Devices = cloneTotals(s.Devices)
+	c.Laps = make([]*Lap, len(s.Laps))
+	for i, l := range s.Laps {
+		lap := *l
+		lap.Devices = cloneTotals(l.Devices)
+		c.Laps[i] = &lap
+	}
+	return c
+}
+
+func cloneTotals(m map[string]*Totals) map[string]*Totals {
+	c := make(map[string]*Totals, len(m))
+	for id, t := range m {
+		clone := t.clone()
+		c[id] = &clone
+	}
+	return c
+}
+
+var (
+	mu        sync.Mutex
+	current   *Session
+	baselines map[string]*baseline
+	roster    map[string]config.Player
+)
+
+// Player returns the player wearing a device, in the running session or
+// else in the roster.
+func Player(id string) config.Player {
+	mu.Lock()
+	defer mu.Unlock()
+	if current != nil {
+		return current.Players[id]
+	}
+	return roster[id]
+}
+
+// Group returns the devices of the roster whose player is in a group, by ID.
+func Group(name string) []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var devices []string
+	for id, player := range roster {
+		if slices.Contains(player.Groups, name) {
+			devices = append(devices, id)
+		}
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+// Groups returns the names of the groups of the roster, sorted.
+func Groups() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for _, player := range roster {
+		for _, g := range player.Groups {
+			if !slices.Contains(names, g) {
+				names = append(names, g)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Start starts a session. An empty name is replaced by the date.
+func Start(name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return start(name, false)
+}
+
+func start(name string, auto bool) error {
+	if current != nil {
+		return ErrRunning
+	}
+	now := time.Now()
+	if name == "" {
+		name = now.Format(NAME_FORMAT)
+	}
+	players := make(map[string]config.Player, len(roster))
+	for id, player := range roster {
+		players[id] = player
+	}
+	current = &Session{Name: name, Start: now, Devices: map[string]*Totals{}, Players: players, auto: auto}
+	baselines = map[string]*baseline{}
+	logging.Info("session started", "name", name, "auto", auto)
+	publish(SessionStarted{Session: current.clone()})
+	return nil
+}
+
+// NewLap ends the current lap, if any, and starts a new one. An empty name
+// is replaced by the number of the lap.
+func NewLap(name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if current == nil {
+		return ErrNotRunning
+	}
+	now := time.Now()
+	if lap, ok := current.CurrentLap(); ok {
+		lap.End = now
+	}
+	if name == "" {
+		name = fmt.Sprintf("Lap %d", len(current.Laps)+1)
+	}
+	current.Laps = append(current.Laps, &Lap{Name: name, Start: now, Devices: map[string]*Totals{}})
+	logging.Info("lap started", "session", current.Name, "lap", name)
+	publish(LapStarted{Session: current.clone()})
+	return nil
+}
+
+// Stop ends the current session and exports its summary.
+func Stop() error {
+	mu.Lock()
+	summary, err := stop()
+	mu.Unlock()
+	if err != nil {
+		return err
+	}
+	export(summary)
+	return nil
+}
+
+// stop ends the current session and returns its summary, to be exported
+// once mu is released.
+func stop() (Session, error) {
+	if current == nil {
+		return Session{}, ErrNotRunning
+	}
+	// the sprints still running count
+	for id, b := range baselines {
+		if s, ok := b.endSprint(config.Current.Training.SprintDuration); ok {
+			inc := Totals{Sprints: []Sprint{s}}
+			addTotals(current.Devices, id, inc)
+			if lap, ok := current.CurrentLap(); ok {
+				addTotals(lap.Devices, id, inc)
+			}
+		}
+	}
+	now := time.Now()
+	if lap, ok := current.CurrentLap(); ok {
+		lap.End = now
+	}
+	current.End = now
+	summary := current.clone()
+	current = nil
+	logging.Info("session stopped", "name", summary.Name, "duration", summary.Elapsed(), "devices", len(summary.Devices))
+	publish(SessionStopped{Session: summary})
+	return summary, nil
+}
+
+// export writes the summary and the sprints of a stopped session to
+// SummaryDir, when set.
+func export(summary Session) {
+	if dir := config.Current.Training.SummaryDir; dir != "" {
+		path, err := Export(dir, summary)
+		if err != nil {
+			logging.Error("cannot export session summary", "session", summary.Name, "err", err)
+		} else {
+			logging.Info("session summary exported", "session", summary.Name, "path", path)
+		}
+		path, err = ExportSprints(dir, summary)
+		if err != nil {
+			logging.Error("cannot export session sprints", "session", summary.Name, "err", err)
+		} else {
+			logging.Info("session sprints exported", "session", summary.Name, "path", path)
+		}
+	}
+}
+
+// Running returns a copy of the current session, if any.
+func Running() (Session, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	if current == nil {
+		return Session{}, false
+	}
+	return current.clone(), true
+}
+
+// Run totals the decoded packets into the current session until ctx is
+// cancelled, which stops the session. With FollowTelemetry a session is
+// started when the first device enters telemetry and stopped when the last
+// one leaves it, unless the session was started by hand.
+func Run(ctx context.Context, players map[string]config.Player) error {
+	mu.Lock()
+	roster = players
+	mu.Unlock()
+
+	updates := conn.SubscribeSamples("training")
+	ticker := time.NewTicker(TOTALS_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			summary, err := stop()
+			mu.Unlock()
+			if err == nil {
+				export(summary)
+			}
+			return nil
+		case <-ticker.C:
+			mu.Lock()
+			if current != nil {
+				publish(TotalsChanged{Session: current.clone()})
+			}
+			mu.Unlock()
+		case u := <-updates:
+			if summary, stopped := handle(u); stopped {
+				export(summary)
+			}
+		}
+	}
+}
+
+// handle totals a packet and follows the telemetry mapping. It returns the
+// summary of the session it stopped, if any.
+func handle(u conn.Update) (Session, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	switch u := u.(type) {
+	case conn.PacketDecoded:
+		if current == nil || u.Packet == nil {
+			return Session{}, false
+		}
+		id := u.Device.Id.String()
+		b, ok := baselines[id]
+		if !ok {
+			b = &baseline{}
+			baselines[id] = b
+		}
+		inc := b.increment(u.Device, u.Packet, athleteOf(current.Players[id]))
+		addTotals(current.Devices, id, inc)
+		if lap, ok := current.CurrentLap(); ok {
+			addTotals(lap.Devices, id, inc)
+		}
+	case conn.MappingChanged:
+		if !config.Current.Training.FollowTelemetry {
+			return Session{}, false
+		}
+		if len(u.Mapping) > 0 && current == nil {
+			start("", true)
+		} else if len(u.Mapping) == 0 && current != nil && current.auto {
+			summary, err := stop()
+			return summary, err == nil
+		}
+	}
+	return Session{}, false
+}
+
+func athleteOf(p config.Player) athlete {
+	cfg := config.Current.Training
+	maxHR, restHR := p.HeartRate()
+	return athlete{
+		highSpeed:      cfg.HighSpeed,
+		bands:          cfg.SpeedBands,
+		sprintSpeed:    cfg.SprintSpeed,
+		sprintDuration: cfg.SprintDuration,
+		maxHR:          maxHR,
+		restHR:         restHR,
+		female:         p.Sex == "f",
+		zones:          cfg.HRZones,
+	}
+}
+
+func addTotals(m map[string]*Totals, id string, inc Totals) {
+	t, ok := m[id]
+	if !ok {
+		t = &Totals{}
+		m[id] = t
+	}
+	t.add(inc)
+}
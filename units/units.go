@@ -0,0 +1,207 @@
+// Package units converts the metrics from the units the devices report them
+// in to the units selected by the user, for the display and every export.
+// The database keeps the native units.
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"unolink-client/config"
+)
+
+// Quantity is what a metric measures. Every quantity has a native unit, the
+// one the devices report it in.
+type Quantity int
+
+const (
+	None Quantity = iota
+	Speed
+	Distance
+	Energy
+	Power
+	VO2
+	HeartRate
+)
+
+// Unit is a unit of a quantity. Factor is its value for one native unit.
+type Unit struct {
+	Symbol   string
+	Suffix   string // symbol usable in a column or field name
+	Factor   float64
+	Decimals int // shown on screen
+}
+
+// Convert converts a value from the native unit.
+func (u Unit) Convert(native float64) float64 {
+	return native * u.Factor
+}
+
+var (
+	SPEEDS = []Unit{
+		{"m/s", "ms", 1, 2},
+		{"km/h", "kmh", 3.6, 1},
+		{"mph", "mph", 3600 / 1609.344, 1},
+		{"kn", "kn", 3600 / 1852.0, 1},
+	}
+	DISTANCES = []Unit{
+		{"m", "m", 1, 0},
+		{"km", "km", 0.001, 2},
+		{"yd", "yd", 1 / 0.9144, 0},
+	}
+	ENERGIES = []Unit{
+		{"kcal", "kcal", 1, 1},
+		{"kJ", "kj", 4.184, 1},
+	}
+
+	// the quantities without a choice of units; whether the power the devices
+	// report is per kilogram is not documented
+	POWER      = Unit{"W", "w", 1, 2}
+	OXYGEN     = Unit{"ml/kg/min", "mlkgmin", 1, 1}
+	HEART_RATE = Unit{"bpm", "bpm", 1, 0}
+)
+
+// FIELDS gives the quantity of the metrics that have a unit, by name. The
+// names are those of the device fields, the packet columns and the totals.
+var FIELDS = map[string]Quantity{
+	"speed":               Speed,
+	"max_speed":           Speed,
+	"peak_speed":          Speed,
+	"distance":            Distance,
+	"equiv_distance":      Distance,
+	"high_speed_distance": Distance,
+	"sprint_distance":     Distance,
+	"cum_distance":        Distance,
+	"cum_distance_1":      Distance,
+	"cum_distance_2":      Distance,
+	"cum_distance_3":      Distance,
+	"cum_distance_4":      Distance,
+	"cum_distance_5":      Distance,
+	"speed_band":          Distance,
+	"device_band":         Distance,
+	"hmld":                Distance,
+	"energy":              Energy,
+	"power":               Power,
+	"vo2":                 VO2,
+	"hrm":                 HeartRate,
+}
+
+// selected holds the unit of every quantity, native until Select.
+var selected = map[Quantity]Unit{
+	None:      {Factor: 1, Decimals: 3},
+	Speed:     SPEEDS[0],
+	Distance:  DISTANCES[0],
+	Energy:    ENERGIES[0],
+	Power:     POWER,
+	VO2:       OXYGEN,
+	HeartRate: HEART_RATE,
+}
+
+// Select selects the units of the configuration.
+func Select(cfg config.Units) error {
+	for _, c := range []struct {
+		q      Quantity
+		name   string
+		symbol string
+		units  []Unit
+	}{
+		{Speed, "speed", cfg.Speed, SPEEDS},
+		{Distance, "distance", cfg.Distance, DISTANCES},
+		{Energy, "energy", cfg.Energy, ENERGIES},
+	} {
+		u, ok := find(c.units, c.symbol)
+		if !ok {
+			return fmt.Errorf("invalid %s unit %q, expected one of %s", c.name, c.symbol, symbols(c.units))
+		}
+		selected[c.q] = u
+	}
+	return nil
+}
+
+func find(units []Unit, symbol string) (Unit, bool) {
+	for _, u := range units {
+		if strings.EqualFold(u.Symbol, symbol) || strings.EqualFold(u.Suffix, symbol) {
+			return u, true
+		}
+	}
+	return Unit{}, false
+}
+
+func symbols(units []Unit) string {
+	s := make([]string, len(units))
+	for i, u := range units {
+		s[i] = u.Symbol
+	}
+	return strings.Join(s, ", ")
+}
+
+// Of returns the selected unit of a quantity.
+func Of(q Quantity) Unit {
+	return selected[q]
+}
+
+// Field returns the selected unit of a metric, a unit without symbol for
+// those without one.
+func Field(name string) Unit {
+	return selected[FIELDS[name]]
+}
+
+// Convert converts the value of a metric from its native unit.
+func Convert(name string, native float64) float64 {
+	return Field(name).Convert(native)
+}
+
+// Format formats the value of a metric in its selected unit, for the screen.
+func Format(name string, native float64) string {
+	u := Field(name)
+	return strconv.FormatFloat(u.Convert(native), 'f', u.Decimals, 64)
+}
+
+// Column returns the name of a metric suffixed with its unit, for the
+// header of an export.
+func Column(name string) string {
+	if u := Field(name); u.Suffix != "" {
+		return name + "_" + u.Suffix
+	}
+	return name
+}
+
+// Title returns a column title followed by the unit of a metric.
+func Title(title, name string) string {
+	return title + " (" + Field(name).Symbol + ")"
+}
+
+// Labels returns the unit symbol of every metric that has one, by name.
+func Labels() map[string]string {
+	labels := make(map[string]string, len(FIELDS))
+	for name, q := range FIELDS {
+		labels[name] = selected[q].Symbol
+	}
+	return labels
+}
+
+// ConvertFields converts in place the device fields that have a unit, as
+// returned by definitions.DeviceState.Fields.
+func ConvertFields(fields map[string]any) {
+	for name, v := range fields {
+		u := Field(name)
+		if u.Factor == 1 {
+			continue
+		}
+		switch v := v.(type) {
+		case float32:
+			fields[name] = u.Convert(float64(v))
+		case float64:
+			fields[name] = u.Convert(v)
+		case uint32:
+			fields[name] = u.Convert(float64(v))
+		case [5]uint32:
+			converted := make([]float64, len(v))
+			for i, d := range v {
+				converted[i] = u.Convert(float64(d))
+			}
+			fields[name] = converted
+		}
+	}
+}
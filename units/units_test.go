@@ -0,0 +1,96 @@
+package units
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"unolink-client/config"
+)
+
+// selectUnits selects units for a test, going back to the native ones after
+// it.
+func selectUnits(t *testing.T, speed, distance, energy string) {
+	t.Helper()
+	t.Cleanup(func() { Select(config.Units{Speed: "m/s", Distance: "m", Energy: "kcal"}) })
+	if err := Select(config.Units{Speed: speed, Distance: distance, Energy: energy}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		units config.Units
+		ok    bool
+	}{
+		{config.Units{Speed: "m/s", Distance: "m", Energy: "kcal"}, true},
+		{config.Units{Speed: "KM/H", Distance: "yd", Energy: "kJ"}, true},
+		{config.Units{Speed: "kmh", Distance: "km", Energy: "kj"}, true},
+		{config.Units{Speed: "furlongs", Distance: "m", Energy: "kcal"}, false},
+		{config.Units{Speed: "m/s", Distance: "mi", Energy: "kcal"}, false},
+		{config.Units{Speed: "m/s", Distance: "m", Energy: "J"}, false},
+	}
+	for _, tt := range tests {
+		err := Select(tt.units)
+		if (err == nil) != tt.ok {
+			t.Errorf("%+v: error %v", tt.units, err)
+		}
+	}
+	Select(config.Units{Speed: "m/s", Distance: "m", Energy: "kcal"})
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		speed, distance, energy string
+		field                   string
+		native                  float64
+		want                    float64
+		column                  string
+		format                  string
+	}{
+		{"m/s", "m", "kcal", "speed", 10, 10, "speed_ms", "10.00"},
+		{"km/h", "m", "kcal", "speed", 10, 36, "speed_kmh", "36.0"},
+		{"mph", "m", "kcal", "max_speed", 10, 22.369, "max_speed_mph", "22.4"},
+		{"kn", "m", "kcal", "speed", 10, 19.438, "speed_kn", "19.4"},
+		{"m/s", "km", "kcal", "distance", 1234.5, 1.2345, "distance_km", "1.23"},
+		{"m/s", "yd", "kcal", "hmld", 100, 109.361, "hmld_yd", "109"},
+		{"m/s", "m", "kJ", "energy", 100, 418.4, "energy_kj", "418.4"},
+		{"km/h", "km", "kJ", "hrm", 152, 152, "hrm_bpm", "152"},
+		{"km/h", "km", "kJ", "power", 7.25, 7.25, "power_w", "7.25"},
+		{"km/h", "km", "kJ", "acc", 12, 12, "acc", "12.000"},
+	}
+	for _, tt := range tests {
+		selectUnits(t, tt.speed, tt.distance, tt.energy)
+		if got := Convert(tt.field, tt.native); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("%s in %s: %v, want %v", tt.field, Field(tt.field).Symbol, got, tt.want)
+		}
+		if got := Column(tt.field); got != tt.column {
+			t.Errorf("column %s, want %s", got, tt.column)
+		}
+		if got := Format(tt.field, tt.native); got != tt.format {
+			t.Errorf("%s formatted %s, want %s", tt.field, got, tt.format)
+		}
+	}
+}
+
+func TestConvertFields(t *testing.T) {
+	selectUnits(t, "km/h", "km", "kcal")
+	fields := map[string]any{
+		"speed":        float32(5),
+		"distance":     uint32(2500),
+		"cum_distance": [5]uint32{1000, 2000, 0, 0, 500},
+		"energy":       float32(12.5),
+		"hrm":          uint8(150),
+	}
+	ConvertFields(fields)
+	want := map[string]any{
+		"speed":        18.0,
+		"distance":     2.5,
+		"cum_distance": []float64{1, 2, 0, 0, 0.5},
+		"energy":       float32(12.5),
+		"hrm":          uint8(150),
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields %v, want %v", fields, want)
+	}
+}